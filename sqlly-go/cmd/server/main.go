@@ -1,15 +1,56 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"sqlly-go/internal/engine"
 	"strings"
+	"syscall"
 )
 
 func main() {
-	dbManager := engine.NewDatabaseManager()
+	defaults := engine.DefaultOptions()
+	dataDir := flag.String("data-dir", "data", "directory holding one sub-directory per database")
+	walSync := flag.String("wal-sync", defaults.Sync.String(), "when to fsync the write-ahead log: commit, always or off")
+	checkpointInterval := flag.Duration("checkpoint-interval", defaults.CheckpointInterval, "how often to checkpoint the write-ahead log (0 disables)")
+	bufferPages := flag.Int("buffer-pool-pages", engine.DefaultBufferPoolPages, "number of 8 KiB table pages to cache in memory")
+	autoVacuum := flag.Float64("autovacuum-ratio", defaults.AutoVacuumRatio, "compact a table at checkpoint once deleted records are this fraction of its file (0 disables)")
+	lockTimeout := flag.Duration("lock-timeout", defaults.LockTimeout, "how long a schema change waits for open transactions to end before failing")
+	flag.Parse()
+
+	syncPolicy, err := engine.ParseSyncPolicy(*walSync)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := defaults
+	opts.Sync = syncPolicy
+	opts.CheckpointInterval = *checkpointInterval
+	opts.AutoVacuumRatio = *autoVacuum
+	opts.LockTimeout = *lockTimeout
+	opts.BufferPool = engine.NewBufferPool(*bufferPages)
+
+	dbManager, err := engine.NewDatabaseManager(*dataDir, opts)
+	if err != nil {
+		log.Fatalf("Failed to open data directory %s: %v", *dataDir, err)
+	}
+
+	// Checkpoint and close the databases on Ctrl+C or kill
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		if err := dbManager.Close(); err != nil {
+			log.Printf("Closing databases: %v", err)
+		}
+		os.Exit(0)
+	}()
 
 	// 1. Start REPL for the "default" database
 	repl := &engine.Repl{Db: dbManager.GetDatabase("default")}
@@ -45,7 +86,10 @@ func main() {
 				http.Error(w, "Database exists", http.StatusConflict)
 				return
 			}
-			dbManager.CreateDatabase(name)
+			if _, err := dbManager.CreateDatabase(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			fmt.Fprintf(w, "Database %s created.", name)
 			return
 		}
@@ -55,21 +99,21 @@ func main() {
 	http.HandleFunc("/api/query", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
 			dbName := r.URL.Query().Get("db")
+			resp := &queryWriter{w: w, failed: -1}
 			var sql string
 			// Decode the JSON string body
 			if err := json.NewDecoder(r.Body).Decode(&sql); err != nil {
-				http.Error(w, "Invalid body", http.StatusBadRequest)
+				resp.finish(&engine.Error{Code: engine.CodeInvalidRequest, Msg: "invalid body", Err: err})
 				return
 			}
 
 			db := dbManager.GetDatabase(dbName)
 			if db == nil {
-				http.Error(w, "Database not found", http.StatusNotFound)
+				resp.finish(&engine.Error{Code: engine.CodeUndefinedDatabase, Msg: fmt.Sprintf("database not found: %s", dbName)})
 				return
 			}
-			
-			result := db.ExecuteSql(sql)
-			json.NewEncoder(w).Encode(result)
+
+			resp.finish(db.ExecuteStream(sql, resp.result))
 		}
 	})
 
@@ -77,10 +121,139 @@ func main() {
 	fs := http.FileServer(http.Dir("./wwwroot"))
 	http.Handle("/", fs)
 
-	fmt.Println("Server started on http://localhost:5220")
+	fmt.Printf("Server started on http://localhost:5220 (data directory: %s)\n", *dataDir)
 	http.ListenAndServe(":5220", nil)
 }
 
+// streamThreshold is how much of a /api/query response is held back before
+// it starts going out.
+const streamThreshold = 64 << 10
+
+// queryWriter writes the body of a /api/query response, {"results": [...],
+// "error": {...}}, with the results of the statements that ran and, if one
+// failed, its error. Query rows are written as they are produced. The body is
+// held back until it outgrows streamThreshold, so that a response that fails
+// by then still gets the status of its error, and no partial result for a
+// query that failed midway; later errors only show in the body of a 200
+// response, after the rows sent.
+type queryWriter struct {
+	w         http.ResponseWriter
+	buf       bytes.Buffer
+	streaming bool
+	results   int
+	failed    int // Where in buf the result of a query that failed midway starts; -1 for none
+}
+
+type queryError struct {
+	Code    engine.Code `json:"code"`
+	Message string      `json:"message"`
+}
+
+func (q *queryWriter) Write(p []byte) (int, error) {
+	if q.streaming {
+		return q.w.Write(p)
+	}
+	q.buf.Write(p)
+	if q.buf.Len() >= streamThreshold {
+		q.streaming = true
+		q.w.Header().Set("Content-Type", "application/json")
+		q.w.WriteHeader(http.StatusOK)
+		if _, err := q.w.Write(q.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		q.buf.Reset()
+	}
+	return len(p), nil
+}
+
+// result writes the result of a statement, reading the rows of a query from
+// rows. It fails if the client has gone away.
+func (q *queryWriter) result(res *engine.Result, rows *engine.RowStream) error {
+	start := q.buf.Len()
+	sep := `{"results":[`
+	if q.results > 0 {
+		sep = ","
+	}
+	q.results++
+	if _, err := io.WriteString(q, sep); err != nil {
+		return err
+	}
+	if rows == nil {
+		return json.NewEncoder(q).Encode(res)
+	}
+
+	columns, err := json.Marshal(res.Columns)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(q, `{"columns":%s,"rows":[`, columns)
+	for n := 0; rows.Next(); n++ {
+		if n > 0 {
+			io.WriteString(q, ",")
+		}
+		row, err := json.Marshal(rows.Values())
+		if err != nil {
+			return err
+		}
+		if _, err := q.Write(row); err != nil {
+			return err
+		}
+	}
+	// Writes with RETURNING also report their count and message
+	tail := fmt.Sprintf(`],"rowsAffected":%d`, res.RowsAffected)
+	if res.LastInsertId != 0 {
+		tail += fmt.Sprintf(`,"lastInsertId":%d`, res.LastInsertId)
+	}
+	if res.Message != "" {
+		msg, _ := json.Marshal(res.Message)
+		tail += `,"message":` + string(msg)
+	}
+	_, err = io.WriteString(q, tail+"}")
+	if rows.Err() != nil && !q.streaming {
+		q.failed = start
+	}
+	return err
+}
+
+// finish ends the body with err, if any, and sends what was held back.
+func (q *queryWriter) finish(err error) {
+	if q.failed >= 0 {
+		q.buf.Truncate(q.failed)
+		q.results--
+	}
+	if q.results == 0 {
+		io.WriteString(q, `{"results":[`)
+	}
+	io.WriteString(q, "]")
+	status := http.StatusOK
+	if err != nil {
+		code := engine.CodeOf(err)
+		body, _ := json.Marshal(queryError{Code: code, Message: err.Error()})
+		fmt.Fprintf(q, `,"error":%s`, body)
+		status = httpStatus(code)
+	}
+	io.WriteString(q, "}\n")
+	if !q.streaming {
+		q.w.Header().Set("Content-Type", "application/json")
+		q.w.WriteHeader(status)
+		q.w.Write(q.buf.Bytes())
+	}
+}
+
+// httpStatus maps an engine error code to the status /api/query answers with.
+func httpStatus(code engine.Code) int {
+	switch code {
+	case engine.CodeUndefinedTable, engine.CodeUndefinedDatabase:
+		return http.StatusNotFound
+	case engine.CodeDuplicateTable, engine.CodeUniqueViolation, engine.CodeNotNullViolation,
+		engine.CodeSerialization: // A write-write conflict, which a retry may get past
+		return http.StatusConflict
+	case engine.CodeInternal, engine.CodeIO:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
 func handleTables(w http.ResponseWriter, r *http.Request, mgr *engine.DatabaseManager, dbName string, parts []string) {
 	db := mgr.GetDatabase(dbName)
 	if db == nil {
@@ -109,11 +282,22 @@ func handleTables(w http.ResponseWriter, r *http.Request, mgr *engine.DatabaseMa
 			return
 		}
 
-		rows := table.SelectAll()
-		data := make([]map[string]interface{}, len(rows))
-		for i, r := range rows {
-			data[i] = r.Data
+		// Stream the rows rather than holding the whole table in memory
+		c := table.Scan()
+		defer c.Close()
+		io.WriteString(w, "[")
+		for n := 0; c.Next(); n++ {
+			if n > 0 {
+				io.WriteString(w, ",")
+			}
+			row, _ := json.Marshal(c.Row().Data)
+			if _, err := w.Write(row); err != nil {
+				return
+			}
+		}
+		io.WriteString(w, "]\n")
+		if err := c.Err(); err != nil {
+			log.Printf("Reading table %s: %v", tableName, err)
 		}
-		json.NewEncoder(w).Encode(data)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,440 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func isAggregate(name string) bool {
+	switch name {
+	case "COUNT", "SUM", "AVG", "MIN", "MAX":
+		return true
+	}
+	return false
+}
+
+// containsAggregate reports whether e calls an aggregate function.
+func containsAggregate(e Expr) bool {
+	switch e := e.(type) {
+	case *FuncCall:
+		if isAggregate(e.Name) {
+			return true
+		}
+		for _, a := range e.Args {
+			if containsAggregate(a) {
+				return true
+			}
+		}
+	case *BinaryExpr:
+		return containsAggregate(e.Left) || containsAggregate(e.Right)
+	case *UnaryExpr:
+		return containsAggregate(e.Operand)
+	}
+	return false
+}
+
+// isAggregateQuery reports whether a SELECT must be evaluated per group.
+func isAggregateQuery(stmt *SelectStmt) bool {
+	if len(stmt.GroupBy) > 0 || stmt.Having != nil {
+		return true
+	}
+	for _, item := range stmt.Items {
+		if !item.Star && containsAggregate(item.Expr) {
+			return true
+		}
+	}
+	for _, item := range stmt.OrderBy {
+		if containsAggregate(item.Expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateCall is one distinct aggregate referenced by a grouped query.
+type aggregateCall struct {
+	call *FuncCall
+	arg  evalFunc // nil for COUNT(*)
+}
+
+// groupContext compiles expressions of a grouped query against "group rows",
+// laid out as the GROUP BY key values followed by one slot per aggregate.
+type groupContext struct {
+	src     *scope
+	keys    []Expr
+	keyText []string
+	aggs    []*aggregateCall
+	aggText []string
+}
+
+func newGroupContext(src *scope, groupBy []Expr) (*groupContext, error) {
+	g := &groupContext{src: src, keys: groupBy}
+	for _, e := range groupBy {
+		if containsAggregate(e) {
+			return nil, errorf(CodeGrouping, "aggregate functions are not allowed in GROUP BY")
+		}
+		text, err := g.canonical(e)
+		if err != nil {
+			return nil, err
+		}
+		g.keyText = append(g.keyText, text)
+	}
+	return g, nil
+}
+
+// canonical renders e with column references resolved to scope slots, so
+// that "age" and "users.age" are recognised as the same grouping expression.
+func (g *groupContext) canonical(e Expr) (string, error) {
+	switch e := e.(type) {
+	case *ColumnRef:
+		idx, err := g.src.resolve(e)
+		if err != nil {
+			return "", err
+		}
+		return "$" + strconv.Itoa(idx), nil
+	case *BinaryExpr:
+		l, err := g.canonical(e.Left)
+		if err != nil {
+			return "", err
+		}
+		r, err := g.canonical(e.Right)
+		if err != nil {
+			return "", err
+		}
+		return "(" + l + " " + e.Op + " " + r + ")", nil
+	case *UnaryExpr:
+		operand, err := g.canonical(e.Operand)
+		if err != nil {
+			return "", err
+		}
+		return "(" + e.Op + " " + operand + ")", nil
+	case *FuncCall:
+		args := make([]string, len(e.Args))
+		for i, a := range e.Args {
+			text, err := g.canonical(a)
+			if err != nil {
+				return "", err
+			}
+			args[i] = text
+		}
+		return fmt.Sprintf("%s(%v,%v,%s)", e.Name, e.Star, e.Distinct, strings.Join(args, ",")), nil
+	}
+	return exprString(e), nil
+}
+
+// compile turns a SELECT, HAVING or ORDER BY expression into an evaluator
+// over group rows. Column references must be covered by GROUP BY.
+func (g *groupContext) compile(e Expr) (evalFunc, error) {
+	text, err := g.canonical(e)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range g.keyText {
+		if key == text {
+			idx := i
+			return func(row []interface{}) (interface{}, error) { return row[idx], nil }, nil
+		}
+	}
+
+	switch e := e.(type) {
+	case *FuncCall:
+		if !isAggregate(e.Name) {
+			return compileExpr(e, &scope{})
+		}
+		return g.compileAggregate(e, text)
+
+	case *ColumnRef:
+		return nil, errorf(CodeGrouping, "column '%s' must appear in GROUP BY or be used in an aggregate function", exprString(e))
+
+	case *UnaryExpr:
+		operand, err := g.compile(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+		op := e.Op
+		return func(row []interface{}) (interface{}, error) {
+			v, err := operand(row)
+			if err != nil {
+				return nil, err
+			}
+			return evalUnary(op, v)
+		}, nil
+
+	case *BinaryExpr:
+		left, err := g.compile(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := g.compile(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		op := e.Op
+		return func(row []interface{}) (interface{}, error) {
+			l, err := left(row)
+			if err != nil {
+				return nil, err
+			}
+			r, err := right(row)
+			if err != nil {
+				return nil, err
+			}
+			if op == "AND" || op == "OR" {
+				return evalLogic(op, l, r)
+			}
+			return evalBinary(op, l, r)
+		}, nil
+	}
+	// Literals; anything referencing a column was handled above
+	return compileExpr(e, &scope{})
+}
+
+func (g *groupContext) compileAggregate(call *FuncCall, text string) (evalFunc, error) {
+	slot := -1
+	for i, t := range g.aggText {
+		if t == text {
+			slot = i
+		}
+	}
+	if slot == -1 {
+		agg := &aggregateCall{call: call}
+		switch {
+		case call.Star:
+			if call.Name != "COUNT" {
+				return nil, errorf(CodeNotSupported, "%s(*) is not supported; only COUNT(*)", call.Name)
+			}
+		case len(call.Args) != 1:
+			return nil, errorf(CodeUndefinedFunction, "%s expects exactly one argument", call.Name)
+		default:
+			if containsAggregate(call.Args[0]) {
+				return nil, errorf(CodeGrouping, "aggregate function calls cannot be nested")
+			}
+			arg, err := compileExpr(call.Args[0], g.src)
+			if err != nil {
+				return nil, err
+			}
+			agg.arg = arg
+		}
+		g.aggs = append(g.aggs, agg)
+		g.aggText = append(g.aggText, text)
+		slot = len(g.aggs) - 1
+	}
+
+	idx := len(g.keys) + slot
+	return func(row []interface{}) (interface{}, error) { return row[idx], nil }, nil
+}
+
+// aggregateState accumulates one aggregate over the rows of one group.
+type aggregateState struct {
+	count int
+	sum   interface{} // int or float64
+	best  interface{} // MIN/MAX so far
+	seen  map[string]bool
+}
+
+func (g *groupContext) accumulate(states []*aggregateState, row []interface{}) error {
+	for i, agg := range g.aggs {
+		st := states[i]
+		if agg.arg == nil {
+			st.count++
+			continue
+		}
+		v, err := agg.arg(row)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		if agg.call.Distinct {
+			key := valueKey(v)
+			if st.seen[key] {
+				continue
+			}
+			st.seen[key] = true
+		}
+		st.count++
+
+		switch agg.call.Name {
+		case "SUM", "AVG":
+			if _, ok := toFloat(v); !ok {
+				return errorf(CodeTypeMismatch, "%s expects numeric values, got %s", agg.call.Name, typeName(v))
+			}
+			if st.sum == nil {
+				st.sum = v
+			} else if st.sum, err = evalArithmetic("+", st.sum, v); err != nil {
+				return err
+			}
+		case "MIN", "MAX":
+			if st.best == nil {
+				st.best = v
+				continue
+			}
+			c, err := compareValues(v, st.best)
+			if err != nil {
+				return fmt.Errorf("%s: %w", agg.call.Name, err)
+			}
+			if (agg.call.Name == "MIN" && c < 0) || (agg.call.Name == "MAX" && c > 0) {
+				st.best = v
+			}
+		}
+	}
+	return nil
+}
+
+func (agg *aggregateCall) result(st *aggregateState) interface{} {
+	switch agg.call.Name {
+	case "COUNT":
+		return st.count
+	case "SUM":
+		return st.sum
+	case "AVG":
+		if st.count == 0 {
+			return nil
+		}
+		f, _ := toFloat(st.sum)
+		return f / float64(st.count)
+	default:
+		return st.best
+	}
+}
+
+// groupRows evaluates the GROUP BY keys and aggregates over the rows of src
+// and returns one group row per group, in order of first appearance. Without
+// GROUP BY the whole input forms a single group, even when it is empty.
+func (g *groupContext) groupRows(src rowIter) ([][]interface{}, error) {
+	keyEvals := make([]evalFunc, len(g.keys))
+	for i, e := range g.keys {
+		eval, err := compileExpr(e, g.src)
+		if err != nil {
+			return nil, err
+		}
+		keyEvals[i] = eval
+	}
+
+	type group struct {
+		keys   []interface{}
+		states []*aggregateState
+	}
+	newGroup := func(keys []interface{}) *group {
+		grp := &group{keys: keys, states: make([]*aggregateState, len(g.aggs))}
+		for i := range grp.states {
+			grp.states[i] = &aggregateState{seen: make(map[string]bool)}
+		}
+		return grp
+	}
+
+	var order []*group
+	groups := make(map[string]*group)
+	if len(g.keys) == 0 {
+		grp := newGroup(nil)
+		groups[""] = grp
+		order = append(order, grp)
+	}
+
+	for {
+		row, err := src.next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		keys := make([]interface{}, len(keyEvals))
+		var sb strings.Builder
+		for i, eval := range keyEvals {
+			v, err := eval(row)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = v
+			sb.WriteString(valueKey(v))
+			sb.WriteByte(0)
+		}
+		grp, ok := groups[sb.String()]
+		if !ok {
+			grp = newGroup(keys)
+			groups[sb.String()] = grp
+			order = append(order, grp)
+		}
+		if err := g.accumulate(grp.states, row); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([][]interface{}, 0, len(order))
+	for _, grp := range order {
+		row := make([]interface{}, 0, len(g.keys)+len(g.aggs))
+		row = append(row, grp.keys...)
+		for i, agg := range g.aggs {
+			row = append(row, agg.result(grp.states[i]))
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+// aggregateNode turns the rows of its input into group rows (see
+// groupContext).
+type aggregateNode struct {
+	nodeStats
+	input  planNode
+	groups *groupContext
+}
+
+func newAggregate(input planNode, groups *groupContext) *aggregateNode {
+	n := &aggregateNode{input: input, groups: groups}
+	n.estimate = 1
+	if len(groups.keys) > 0 {
+		n.estimate = fraction(input.stats().estimate, 10)
+	}
+	return n
+}
+
+func (n *aggregateNode) describe() (string, []string) {
+	if len(n.groups.keys) == 0 {
+		return "Aggregate", nil
+	}
+	keys := make([]string, len(n.groups.keys))
+	for i, e := range n.groups.keys {
+		keys[i] = exprString(e)
+	}
+	return "Aggregate", []string{"Group Key: " + strings.Join(keys, ", ")}
+}
+
+func (n *aggregateNode) inputs() []planNode { return []planNode{n.input} }
+
+func (n *aggregateNode) open(snap *snapshot) (rowIter, error) {
+	input, err := openNode(n.input, snap)
+	if err != nil {
+		return nil, err
+	}
+	defer input.close()
+	rows, err := n.groups.groupRows(input)
+	if err != nil {
+		return nil, err
+	}
+	return sliceIter(rows), nil
+}
+
+// valueKey encodes a value so that equal values (of the same type) map to
+// equal strings; used for grouping and DISTINCT.
+func valueKey(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "n"
+	case int:
+		return "i" + strconv.Itoa(v)
+	case float64:
+		if v == float64(int(v)) {
+			return "i" + strconv.Itoa(int(v))
+		}
+		return "f" + strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return "s" + v
+	case bool:
+		return "b" + strconv.FormatBool(v)
+	}
+	return fmt.Sprintf("?%v", v)
+}
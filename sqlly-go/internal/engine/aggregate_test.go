@@ -0,0 +1,24 @@
+package engine
+
+import "testing"
+
+func TestAggregates(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	mustExec(t, s, `CREATE TABLE e (id INT PRIMARY KEY, dept TEXT, sal INT);
+		INSERT INTO e VALUES (1, 'a', 10), (2, 'a', 20), (3, 'b', 5), (4, 'b', NULL), (5, NULL, 7)`)
+
+	// NULL forms a group of its own and is skipped by the aggregates
+	checkRows(t, s, "SELECT dept, COUNT(*), COUNT(sal), SUM(sal), MIN(sal), MAX(sal) FROM e GROUP BY dept ORDER BY dept",
+		[][]interface{}{{nil, 1, 1, 7, 7, 7}, {"a", 2, 2, 30, 10, 20}, {"b", 2, 1, 5, 5, 5}})
+	checkRows(t, s, "SELECT AVG(sal) FROM e WHERE dept = 'a'", [][]interface{}{{15.0}})
+	checkRows(t, s, "SELECT dept, SUM(sal) AS total FROM e GROUP BY dept HAVING SUM(sal) > 6 ORDER BY total DESC",
+		[][]interface{}{{"a", 30}, {nil, 7}})
+	checkRows(t, s, "SELECT COUNT(DISTINCT dept) FROM e", [][]interface{}{{2}})
+	// Without GROUP BY there is exactly one group, even with no rows
+	checkRows(t, s, "SELECT COUNT(*), SUM(sal) FROM e WHERE id > 100", [][]interface{}{{0, nil}})
+
+	execFails(t, s, "SELECT dept, sal FROM e GROUP BY dept", CodeGrouping)
+	execFails(t, s, "SELECT id FROM e WHERE SUM(sal) > 1", CodeGrouping)
+}
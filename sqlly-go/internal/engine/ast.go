@@ -0,0 +1,337 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Statement is a parsed SQL statement.
+type Statement interface {
+	statementNode()
+}
+
+// Expr is a parsed SQL expression.
+type Expr interface {
+	exprNode()
+}
+
+type CreateTableStmt struct {
+	Name    string
+	Columns []ColumnDef
+}
+
+type DropTableStmt struct {
+	Name string
+}
+
+// CreateIndexStmt is CREATE [UNIQUE] INDEX name ON table (col, ...).
+type CreateIndexStmt struct {
+	Index IndexDef
+	Table string
+}
+
+type DropIndexStmt struct {
+	Name string
+}
+
+// AlterTableStmt holds exactly one action: AddColumn or RenameTo.
+type AlterTableStmt struct {
+	Name      string
+	AddColumn *ColumnDef
+	RenameTo  string
+}
+
+// InsertStmt is INSERT INTO table [(col, ...)] followed by either
+// VALUES (expr, ...), ... or a SELECT, which Query is set to, and an
+// optional ON CONFLICT clause. Without a column list the values go to every
+// column in order; a nil value stands for DEFAULT.
+type InsertStmt struct {
+	Table      string
+	Columns    []string
+	Rows       [][]Expr
+	Query      *SelectStmt
+	OnConflict *OnConflict
+	Returning  []SelectItem // Nil without a RETURNING clause
+}
+
+// OnConflict is ON CONFLICT [(col, ...)] followed by DO NOTHING, when Set is
+// nil, or DO UPDATE SET col = expr, ... [WHERE cond]. Set and Where may refer
+// to the proposed row as EXCLUDED.
+type OnConflict struct {
+	Columns []string
+	Set     []Assignment
+	Where   Expr
+}
+
+type SelectStmt struct {
+	Items   []SelectItem
+	From    TableRef // Zero when there is no FROM
+	Joins   []JoinClause
+	Where   Expr
+	GroupBy []Expr
+	Having  Expr
+	OrderBy []OrderItem
+	Limit   Expr // nil when absent
+	Offset  Expr // nil when absent
+}
+
+// exprs returns every expression of the statement; a star item has none.
+func (stmt *SelectStmt) exprs() []Expr {
+	exprs := append([]Expr{stmt.Where, stmt.Having, stmt.Limit, stmt.Offset}, itemExprs(stmt.Items)...)
+	for _, j := range stmt.Joins {
+		exprs = append(exprs, j.On)
+	}
+	exprs = append(exprs, stmt.GroupBy...)
+	for _, item := range stmt.OrderBy {
+		exprs = append(exprs, item.Expr)
+	}
+	return exprs
+}
+
+// itemExprs returns the expressions of a select list; star items have none.
+func itemExprs(items []SelectItem) []Expr {
+	var exprs []Expr
+	for _, item := range items {
+		if !item.Star {
+			exprs = append(exprs, item.Expr)
+		}
+	}
+	return exprs
+}
+
+type OrderItem struct {
+	Expr Expr
+	Desc bool
+}
+
+// SelectItem is one entry of the select list: "*", "t.*" or an expression
+// with an optional alias.
+type SelectItem struct {
+	Star      bool
+	StarTable string
+	Expr      Expr
+	Alias     string
+}
+
+type TableRef struct {
+	Name  string
+	Alias string
+	Pos   Pos
+}
+
+// RefName is the name columns of the table are qualified with: the alias
+// when one is given, otherwise the table name.
+func (r TableRef) RefName() string {
+	if r.Alias != "" {
+		return r.Alias
+	}
+	return r.Name
+}
+
+type JoinKind int
+
+const (
+	InnerJoin JoinKind = iota
+	LeftJoin
+	RightJoin
+	FullJoin
+	CrossJoin
+)
+
+func (k JoinKind) String() string {
+	switch k {
+	case LeftJoin:
+		return "LEFT JOIN"
+	case RightJoin:
+		return "RIGHT JOIN"
+	case FullJoin:
+		return "FULL OUTER JOIN"
+	case CrossJoin:
+		return "CROSS JOIN"
+	}
+	return "INNER JOIN"
+}
+
+type JoinClause struct {
+	Kind  JoinKind
+	Table TableRef
+	On    Expr // nil for CROSS JOIN
+}
+
+type UpdateStmt struct {
+	Table     string
+	Set       []Assignment
+	Where     Expr
+	Returning []SelectItem
+}
+
+type Assignment struct {
+	Column string
+	Value  Expr
+}
+
+type DeleteStmt struct {
+	Table     string
+	Where     Expr
+	Returning []SelectItem
+}
+
+// BeginStmt starts a transaction: BEGIN [TRANSACTION | WORK] or
+// START TRANSACTION.
+type BeginStmt struct{}
+
+// CommitStmt is COMMIT (or END) [TRANSACTION | WORK].
+type CommitStmt struct{}
+
+// RollbackStmt is ROLLBACK [TRANSACTION | WORK] [TO [SAVEPOINT] name]; it
+// undoes the whole transaction when Savepoint is empty.
+type RollbackStmt struct {
+	Savepoint string
+}
+
+type SavepointStmt struct {
+	Name string
+}
+
+// ReleaseStmt is RELEASE [SAVEPOINT] name.
+type ReleaseStmt struct {
+	Name string
+}
+
+// VacuumStmt is VACUUM [table]; it compacts every table when Table is empty.
+type VacuumStmt struct {
+	Table string
+}
+
+// CreateSequenceStmt is CREATE SEQUENCE name [START [WITH] n].
+type CreateSequenceStmt struct {
+	Name  string
+	Start int
+}
+
+type DropSequenceStmt struct {
+	Name string
+}
+
+// ExplainStmt is EXPLAIN [ANALYZE] followed by a SELECT.
+type ExplainStmt struct {
+	Analyze bool
+	Query   *SelectStmt
+}
+
+func (*CreateTableStmt) statementNode()    {}
+func (*DropTableStmt) statementNode()      {}
+func (*AlterTableStmt) statementNode()     {}
+func (*CreateIndexStmt) statementNode()    {}
+func (*DropIndexStmt) statementNode()      {}
+func (*InsertStmt) statementNode()         {}
+func (*SelectStmt) statementNode()         {}
+func (*UpdateStmt) statementNode()         {}
+func (*DeleteStmt) statementNode()         {}
+func (*BeginStmt) statementNode()          {}
+func (*CommitStmt) statementNode()         {}
+func (*RollbackStmt) statementNode()       {}
+func (*SavepointStmt) statementNode()      {}
+func (*ReleaseStmt) statementNode()        {}
+func (*VacuumStmt) statementNode()         {}
+func (*ExplainStmt) statementNode()        {}
+func (*CreateSequenceStmt) statementNode() {}
+func (*DropSequenceStmt) statementNode()   {}
+
+// Literal holds an int, float64 or string constant, or nil for NULL.
+type Literal struct {
+	Value interface{}
+	Pos   Pos
+}
+
+// ColumnRef names a column, optionally qualified by a table name.
+type ColumnRef struct {
+	Table string
+	Name  string
+	Pos   Pos
+}
+
+type BinaryExpr struct {
+	Op    string // "=", "<>", "<", "<=", ">", ">=", "AND", "OR", "+", "-", "*", "/", "%", "||"
+	Left  Expr
+	Right Expr
+	Pos   Pos
+}
+
+type UnaryExpr struct {
+	Op      string // "NOT", "-", "+", "IS NULL", "IS NOT NULL"
+	Operand Expr
+	Pos     Pos
+}
+
+// FuncCall is a function application such as COUNT(*) or SUM(DISTINCT x).
+type FuncCall struct {
+	Name     string // Upper-cased
+	Args     []Expr
+	Star     bool // COUNT(*)
+	Distinct bool
+	Pos      Pos
+	seq      *sequence // Sequence a NEXTVAL call takes values from, once bound
+}
+
+func (*Literal) exprNode()    {}
+func (*ColumnRef) exprNode()  {}
+func (*BinaryExpr) exprNode() {}
+func (*UnaryExpr) exprNode()  {}
+func (*FuncCall) exprNode()   {}
+
+// exprString renders an expression back to SQL, e.g. for default column names.
+func exprString(e Expr) string {
+	switch e := e.(type) {
+	case *Literal:
+		switch v := e.Value.(type) {
+		case nil:
+			return "NULL"
+		case string:
+			return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return fmt.Sprint(e.Value)
+	case *ColumnRef:
+		if e.Table != "" {
+			return e.Table + "." + e.Name
+		}
+		return e.Name
+	case *UnaryExpr:
+		switch e.Op {
+		case "NOT":
+			return "NOT " + subExprString(e.Operand)
+		case "IS NULL", "IS NOT NULL":
+			return subExprString(e.Operand) + " " + e.Op
+		}
+		return e.Op + subExprString(e.Operand)
+	case *BinaryExpr:
+		return subExprString(e.Left) + " " + e.Op + " " + subExprString(e.Right)
+	case *FuncCall:
+		if e.Star {
+			return e.Name + "(*)"
+		}
+		if niladicFunctions[e.Name] && len(e.Args) == 0 {
+			return e.Name
+		}
+		args := make([]string, len(e.Args))
+		for i, a := range e.Args {
+			args[i] = exprString(a)
+		}
+		if e.Distinct {
+			return e.Name + "(DISTINCT " + strings.Join(args, ", ") + ")"
+		}
+		return e.Name + "(" + strings.Join(args, ", ") + ")"
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+// subExprString parenthesises compound operands so precedence survives.
+func subExprString(e Expr) string {
+	if _, ok := e.(*BinaryExpr); ok {
+		return "(" + exprString(e) + ")"
+	}
+	return exprString(e)
+}
@@ -0,0 +1,431 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// bTree is a B+tree mapping byte-string keys to int64 values, stored in the
+// pages of an index file. Leaves are chained left to right for ordered scans.
+// Deleting never merges nodes; pages emptied by deletes stay in the tree until
+// the index is rebuilt.
+//
+// Node pages are laid out as
+//
+//	kind byte | entry count uint16 | link uint32 | entries...
+//
+// where a leaf's link is the next leaf (0 for none) and its entries are
+// key length uint16 | key | value int64, and an internal node's link is its
+// leftmost child and its entries are key length uint16 | key | child uint32.
+// The subtree right of a key holds the keys greater than or equal to it.
+type bTree struct {
+	idx  *indexFile
+	name string
+	root uint32
+}
+
+const (
+	leafNode     = 1
+	internalNode = 2
+	nodeHeader   = 7
+	maxKeySize   = 1024
+)
+
+var errKeyTooLong = errorf(CodeProgramLimit, "value is too long to index: maximum size is %d bytes", maxKeySize)
+
+type node struct {
+	leaf     bool
+	keys     [][]byte
+	vals     []int64  // Leaf values
+	children []uint32 // Internal node children, one more than keys
+	next     uint32   // Next leaf
+}
+
+func (n *node) size() int {
+	size := nodeHeader
+	for _, k := range n.keys {
+		size += 2 + len(k)
+		if n.leaf {
+			size += 8
+		} else {
+			size += 4
+		}
+	}
+	return size
+}
+
+func (n *node) encode(page []byte) {
+	clear(page)
+	page[0] = internalNode
+	link := uint32(0)
+	if n.leaf {
+		page[0] = leafNode
+		link = n.next
+	} else {
+		link = n.children[0]
+	}
+	binary.LittleEndian.PutUint16(page[1:], uint16(len(n.keys)))
+	binary.LittleEndian.PutUint32(page[3:], link)
+	p := nodeHeader
+	for i, k := range n.keys {
+		binary.LittleEndian.PutUint16(page[p:], uint16(len(k)))
+		p += 2 + copy(page[p+2:], k)
+		if n.leaf {
+			binary.LittleEndian.PutUint64(page[p:], uint64(n.vals[i]))
+			p += 8
+		} else {
+			binary.LittleEndian.PutUint32(page[p:], n.children[i+1])
+			p += 4
+		}
+	}
+}
+
+func decodeNode(page []byte) (*node, error) {
+	count := int(binary.LittleEndian.Uint16(page[1:]))
+	link := binary.LittleEndian.Uint32(page[3:])
+	n := &node{leaf: page[0] != internalNode, keys: make([][]byte, 0, count+1)}
+	if n.leaf {
+		n.next = link
+		n.vals = make([]int64, 0, count+1)
+	} else {
+		n.children = make([]uint32, 0, count+2)
+		n.children = append(n.children, link)
+	}
+	// The keys share one copy of the page
+	page = append([]byte(nil), page...)
+	err := walkNode(page, func(key, val []byte) bool {
+		n.keys = append(n.keys, key[:len(key):len(key)])
+		if n.leaf {
+			n.vals = append(n.vals, int64(binary.LittleEndian.Uint64(val)))
+		} else {
+			n.children = append(n.children, binary.LittleEndian.Uint32(val))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// walkNode calls fn with the key and value bytes of each entry of the node in
+// page, in order, until fn returns false.
+func walkNode(page []byte, fn func(key, val []byte) bool) error {
+	count := int(binary.LittleEndian.Uint16(page[1:]))
+	valSize := 8
+	if page[0] == internalNode {
+		valSize = 4
+	}
+	p := nodeHeader
+	for i := 0; i < count; i++ {
+		if p+2 > len(page) {
+			return errCorruptIndex
+		}
+		klen := int(binary.LittleEndian.Uint16(page[p:]))
+		p += 2
+		if p+klen+valSize > len(page) {
+			return errCorruptIndex
+		}
+		if !fn(page[p:p+klen], page[p+klen:p+klen+valSize]) {
+			return nil
+		}
+		p += klen + valSize
+	}
+	return nil
+}
+
+var errCorruptIndex = errors.New("corrupt index page")
+
+func (t *bTree) read(page uint32) (*node, error) {
+	fr, err := t.idx.file.pin(page)
+	if err != nil {
+		return nil, err
+	}
+	defer t.idx.file.unpin(fr, false)
+	n, err := decodeNode(fr.data)
+	if err != nil {
+		return nil, fmt.Errorf("index %s page %d: %w", t.name, page, err)
+	}
+	return n, nil
+}
+
+func (t *bTree) write(page uint32, n *node) error {
+	if err := t.idx.markDirty(); err != nil {
+		return err
+	}
+	fr, err := t.idx.file.pin(page)
+	if err != nil {
+		return err
+	}
+	n.encode(fr.data)
+	t.idx.file.unpin(fr, true)
+	return nil
+}
+
+// childFor returns the index of the child of internal node n covering key.
+func (n *node) childFor(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) > 0 })
+}
+
+// find returns the position of key in leaf n, or where it would go.
+func (n *node) find(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// descend returns the page of the leaf that would hold key, together with
+// the internal nodes above it, root first. Nodes are searched in place
+// rather than decoded.
+func (t *bTree) descend(key []byte) (uint32, []uint32, error) {
+	var path []uint32
+	page := t.root
+	for {
+		fr, err := t.idx.file.pin(page)
+		if err != nil {
+			return 0, nil, err
+		}
+		if fr.data[0] != internalNode {
+			t.idx.file.unpin(fr, false)
+			return page, path, nil
+		}
+		child := binary.LittleEndian.Uint32(fr.data[3:])
+		err = walkNode(fr.data, func(k, val []byte) bool {
+			if bytes.Compare(k, key) > 0 {
+				return false
+			}
+			child = binary.LittleEndian.Uint32(val)
+			return true
+		})
+		t.idx.file.unpin(fr, false)
+		if err != nil {
+			return 0, nil, fmt.Errorf("index %s page %d: %w", t.name, page, err)
+		}
+		path = append(path, page)
+		page = child
+	}
+}
+
+func (t *bTree) get(key []byte) (int64, bool, error) {
+	page, _, err := t.descend(key)
+	if err != nil {
+		return 0, false, err
+	}
+	fr, err := t.idx.file.pin(page)
+	if err != nil {
+		return 0, false, err
+	}
+	defer t.idx.file.unpin(fr, false)
+	var val int64
+	var found bool
+	err = walkNode(fr.data, func(k, v []byte) bool {
+		c := bytes.Compare(k, key)
+		if c == 0 {
+			val, found = int64(binary.LittleEndian.Uint64(v)), true
+		}
+		return c < 0
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("index %s page %d: %w", t.name, page, err)
+	}
+	return val, found, nil
+}
+
+// put maps key to val, replacing any earlier value.
+func (t *bTree) put(key []byte, val int64) error {
+	if len(key) > maxKeySize {
+		return errKeyTooLong
+	}
+	page, path, err := t.descend(key)
+	if err != nil {
+		return err
+	}
+	if done, err := t.putInPlace(page, key, val); done || err != nil {
+		return err
+	}
+	n, err := t.read(page)
+	if err != nil {
+		return err
+	}
+	i, found := n.find(key)
+	if found {
+		n.vals[i] = val
+	} else {
+		n.keys = insertAt(n.keys, i, append([]byte(nil), key...))
+		n.vals = insertAt(n.vals, i, val)
+	}
+
+	// Split overfull nodes from the leaf upwards
+	for {
+		if n.size() <= pageSize {
+			return t.write(page, n)
+		}
+		sep, right, err := t.split(page, n)
+		if err != nil {
+			return err
+		}
+		if len(path) == 0 {
+			// The root split: grow the tree by one level
+			root := t.idx.allocPage()
+			if err := t.write(root, &node{keys: [][]byte{sep}, children: []uint32{t.root, right}}); err != nil {
+				return err
+			}
+			t.root = root
+			return nil
+		}
+		page, path = path[len(path)-1], path[:len(path)-1]
+		if n, err = t.read(page); err != nil {
+			return err
+		}
+		i := n.childFor(sep)
+		n.keys = insertAt(n.keys, i, sep)
+		n.children = insertAt(n.children, i+1, right)
+	}
+}
+
+// putInPlace maps key to val in the leaf at page by editing its bytes, if
+// that does not overfill it. It reports whether it did.
+func (t *bTree) putInPlace(page uint32, key []byte, val int64) (bool, error) {
+	if err := t.idx.markDirty(); err != nil {
+		return false, err
+	}
+	fr, err := t.idx.file.pin(page)
+	if err != nil {
+		return false, err
+	}
+	data := fr.data
+	// Find where key goes and where the entries end
+	at, end, found := -1, nodeHeader, false
+	err = walkNode(data, func(k, v []byte) bool {
+		if at < 0 {
+			if c := bytes.Compare(k, key); c >= 0 {
+				at, found = end, c == 0
+			}
+		}
+		end += 2 + len(k) + len(v)
+		return true
+	})
+	if err != nil {
+		t.idx.file.unpin(fr, false)
+		return false, fmt.Errorf("index %s page %d: %w", t.name, page, err)
+	}
+	if at < 0 {
+		at = end
+	}
+
+	entry := 2 + len(key) + 8
+	switch {
+	case found:
+		binary.LittleEndian.PutUint64(data[at+2+len(key):], uint64(val))
+	case end+entry <= pageSize:
+		copy(data[at+entry:], data[at:end])
+		binary.LittleEndian.PutUint16(data[at:], uint16(len(key)))
+		copy(data[at+2:], key)
+		binary.LittleEndian.PutUint64(data[at+2+len(key):], uint64(val))
+		binary.LittleEndian.PutUint16(data[1:], binary.LittleEndian.Uint16(data[1:])+1)
+	default:
+		t.idx.file.unpin(fr, false)
+		return false, nil
+	}
+	t.idx.file.unpin(fr, true)
+	return true, nil
+}
+
+// split moves the upper half (by size) of an overfull node to a new page,
+// writing both halves. It returns the first key of the new right sibling and
+// its page.
+func (t *bTree) split(page uint32, n *node) ([]byte, uint32, error) {
+	mid, size := 0, nodeHeader
+	for size < pageSize/2 && mid < len(n.keys)-1 {
+		size += 2 + len(n.keys[mid]) + 8
+		mid++
+	}
+
+	rightPage := t.idx.allocPage()
+	var right *node
+	var sep []byte
+	if n.leaf {
+		right = &node{leaf: true, keys: n.keys[mid:], vals: n.vals[mid:], next: n.next}
+		n.keys, n.vals, n.next = n.keys[:mid], n.vals[:mid], rightPage
+		sep = right.keys[0]
+	} else {
+		// The middle key moves up rather than into either half
+		right = &node{keys: n.keys[mid+1:], children: n.children[mid+1:]}
+		sep = n.keys[mid]
+		n.keys, n.children = n.keys[:mid], n.children[:mid+1]
+	}
+	if err := t.write(rightPage, right); err != nil {
+		return nil, 0, err
+	}
+	if err := t.write(page, n); err != nil {
+		return nil, 0, err
+	}
+	return sep, rightPage, nil
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	s = append(s, v)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+// delete removes key if present.
+func (t *bTree) delete(key []byte) error {
+	page, _, err := t.descend(key)
+	if err != nil {
+		return err
+	}
+	n, err := t.read(page)
+	if err != nil {
+		return err
+	}
+	i, found := n.find(key)
+	if !found {
+		return nil
+	}
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.vals = append(n.vals[:i], n.vals[i+1:]...)
+	return t.write(page, n)
+}
+
+// scan calls fn for each key from from (nil for the first) onwards, in
+// order, until fn returns false.
+func (t *bTree) scan(from []byte, fn func(key []byte, val int64) bool) error {
+	page, _, err := t.descend(from)
+	if err != nil {
+		return err
+	}
+	n, err := t.read(page)
+	if err != nil {
+		return err
+	}
+	i, _ := n.find(from)
+	for {
+		for ; i < len(n.keys); i++ {
+			if !fn(n.keys[i], n.vals[i]) {
+				return nil
+			}
+		}
+		if n.next == 0 {
+			return nil
+		}
+		if n, err = t.read(n.next); err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// pkKey encodes a primary key so that byte order matches numeric order.
+func pkKey(id int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(int32(id))^1<<31)
+	return key
+}
+
+func pkId(key []byte) int {
+	return int(int32(binary.BigEndian.Uint32(key) ^ 1<<31))
+}
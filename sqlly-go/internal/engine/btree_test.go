@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey is long enough that a few dozen keys fill a node, so a few
+// thousand make a tree three levels deep.
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("%06d%s", i, strings.Repeat("x", 200)))
+}
+
+func TestBTreeSplits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t.idx")
+	pool := NewBufferPool(16)
+	idx, _, err := openIndexFile(path, nil, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.reset(nil); err != nil {
+		t.Fatal(err)
+	}
+	tree := idx.pk
+
+	const n = 3000
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		if err := tree.put(testKey(i), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, above, err := tree.descend(testKey(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(above) < 2 {
+		t.Fatalf("tree of %d keys has %d internal levels, want at least 2", n, len(above))
+	}
+
+	check := func(deleted func(i int) bool, want int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			val, found, err := tree.get(testKey(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found == deleted(i) || found && val != int64(i) {
+				t.Fatalf("key %d: got %d, %v", i, val, found)
+			}
+		}
+		var prev []byte
+		count := 0
+		err := tree.scan(nil, func(key []byte, val int64) bool {
+			if prev != nil && bytes.Compare(prev, key) >= 0 {
+				t.Fatalf("scan out of order after key %.6s", prev)
+			}
+			prev = append(prev[:0], key...)
+			count++
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Fatalf("scan returned %d keys, want %d", count, want)
+		}
+	}
+	check(func(int) bool { return false }, n)
+
+	for i := 0; i < n; i += 3 {
+		if err := tree.delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted := func(i int) bool { return i%3 == 0 }
+	check(deleted, n-n/3)
+
+	// The tree survives the index file being closed and reopened
+	if err := idx.close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, ok, err := openIndexFile(path, nil, pool)
+	if err != nil || !ok {
+		t.Fatalf("reopening index: ok %v, %v", ok, err)
+	}
+	defer idx.close()
+	tree = idx.pk
+	check(deleted, n-n/3)
+}
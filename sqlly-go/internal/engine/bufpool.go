@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+)
+
+// DefaultBufferPoolPages is the size of the buffer pool a database gets when
+// Options.BufferPool is nil (8 MiB).
+const DefaultBufferPoolPages = 1024
+
+// BufferPool caches table file pages in memory for every table using it.
+// Pages are evicted least recently used first; a dirty page is written back
+// when it is evicted or its file is synced, after the write-ahead log records
+// of its changes. A page is only read or changed
+// while pinned, and pinned pages are never evicted, so the pool may briefly
+// hold more pages than its capacity when they are all in use.
+type BufferPool struct {
+	mu       sync.Mutex
+	capacity int
+	frames   map[pageKey]*frame
+	lru      *list.List // Unpinned and pinned frames, most recently used first
+}
+
+type pageKey struct {
+	file *pageFile
+	page uint32
+}
+
+// frame is a page held in the pool.
+type frame struct {
+	key   pageKey
+	data  slottedPage
+	pins  int
+	dirty bool
+	wal   *wal  // Log holding the records of the changes to the page; nil if none
+	lsn   int64 // Position in wal up to which it must be synced before the page is written
+	elem  *list.Element
+}
+
+func NewBufferPool(pages int) *BufferPool {
+	if pages < 1 {
+		pages = 1
+	}
+	return &BufferPool{
+		capacity: pages,
+		frames:   make(map[pageKey]*frame),
+		lru:      list.New(),
+	}
+}
+
+func (bp *BufferPool) pin(pf *pageFile, n uint32) (*frame, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	key := pageKey{pf, n}
+	if fr, ok := bp.frames[key]; ok {
+		fr.pins++
+		bp.lru.MoveToFront(fr.elem)
+		return fr, nil
+	}
+
+	if err := bp.evict(); err != nil {
+		return nil, err
+	}
+	data := make([]byte, pageSize)
+	if _, err := pf.f.ReadAt(data, int64(n)*pageSize); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	fr := &frame{key: key, data: data, pins: 1}
+	fr.elem = bp.lru.PushFront(fr)
+	bp.frames[key] = fr
+	return fr, nil
+}
+
+func (bp *BufferPool) unpin(fr *frame, dirty bool) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	fr.pins--
+	fr.dirty = fr.dirty || dirty
+}
+
+// unpinLogged unpins fr after a change recorded in w before position lsn.
+func (bp *BufferPool) unpinLogged(fr *frame, w *wal, lsn int64) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	fr.pins--
+	fr.dirty = true
+	fr.wal, fr.lsn = w, max(fr.lsn, lsn)
+}
+
+// evict makes room for one more page by dropping the least recently used
+// unpinned pages, writing them back first if dirty; bp.mu must be held.
+func (bp *BufferPool) evict() error {
+	for e := bp.lru.Back(); e != nil && len(bp.frames) >= bp.capacity; {
+		fr := e.Value.(*frame)
+		e = e.Prev()
+		if fr.pins > 0 {
+			continue
+		}
+		if err := bp.writeBack(fr); err != nil {
+			return err
+		}
+		bp.remove(fr)
+	}
+	return nil
+}
+
+func (bp *BufferPool) writeBack(fr *frame) error {
+	if !fr.dirty {
+		return nil
+	}
+	if fr.wal != nil {
+		if err := fr.wal.syncTo(fr.lsn); err != nil {
+			return err
+		}
+	}
+	if _, err := fr.key.file.f.WriteAt(fr.data, int64(fr.key.page)*pageSize); err != nil {
+		return err
+	}
+	fr.dirty = false
+	return nil
+}
+
+func (bp *BufferPool) remove(fr *frame) {
+	bp.lru.Remove(fr.elem)
+	delete(bp.frames, fr.key)
+}
+
+// flush writes back every dirty page of pf.
+func (bp *BufferPool) flush(pf *pageFile) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for key, fr := range bp.frames {
+		if key.file == pf {
+			if err := bp.writeBack(fr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// drop forgets every page of pf without writing it back.
+func (bp *BufferPool) drop(pf *pageFile) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for key, fr := range bp.frames {
+		if key.file == pf {
+			bp.remove(fr)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBufferPoolEvictsAndWritesBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t.db")
+	pool := NewBufferPool(4)
+	pf, err := openPageFile(path, nil, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.close()
+
+	const pages = 16
+	for n := uint32(1); n <= pages; n++ {
+		fr, err := pf.pin(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr.data[100] = byte(n)
+		pf.unpin(fr, true)
+		if len(pool.frames) > pool.capacity {
+			t.Fatalf("pool holds %d pages, capacity %d", len(pool.frames), pool.capacity)
+		}
+	}
+
+	// Evicted pages were written back before being dropped
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n <= pages-pool.capacity; n++ {
+		if off := n*pageSize + 100; off >= len(data) || data[off] != byte(n) {
+			t.Fatalf("evicted page %d not written back", n)
+		}
+	}
+	// and are read back from the file
+	for n := uint32(1); n <= pages; n++ {
+		fr, err := pf.pin(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fr.data[100] != byte(n) {
+			t.Fatalf("page %d reads back %d", n, fr.data[100])
+		}
+		pf.unpin(fr, false)
+	}
+
+	// Pinned pages stay, even past the capacity
+	var pinned []*frame
+	for n := uint32(1); n <= 6; n++ {
+		fr, err := pf.pin(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr.data[200] = byte(n)
+		pinned = append(pinned, fr)
+	}
+	if len(pool.frames) != 6 {
+		t.Fatalf("pool holds %d pages with 6 pinned", len(pool.frames))
+	}
+	for _, fr := range pinned {
+		pf.unpin(fr, true)
+	}
+	if err := pf.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n <= 6; n++ {
+		if data[n*pageSize+200] != byte(n) {
+			t.Fatalf("page %d not written back by sync", n)
+		}
+	}
+}
+
+func TestDatabaseWithTinyBufferPool(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.BufferPool = NewBufferPool(3)
+	db := openTestDB(t, dir, opts)
+	s := db.NewSession()
+	mustExec(t, s, "CREATE TABLE t (id INT PRIMARY KEY, v TEXT, n INT)")
+	pad := strings.Repeat("x", 100)
+	for i := 1; i <= 500; i++ {
+		mustExec(t, s, fmt.Sprintf("INSERT INTO t VALUES (%d, '%s', %d)", i, pad, i))
+	}
+	mustExec(t, s, "UPDATE t SET n = n * 2 WHERE id % 2 = 0; DELETE FROM t WHERE id % 5 = 0")
+	want := [][]interface{}{{400, 150000}}
+	checkRows(t, s, "SELECT COUNT(*), SUM(n) FROM t", want)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestDB(t, dir, testOptions())
+	defer db.Close()
+	s = db.NewSession()
+	checkRows(t, s, "SELECT COUNT(*), SUM(n) FROM t", want)
+	checkRows(t, s, "SELECT n FROM t WHERE id = 498", [][]interface{}{{996}})
+}
+
+func TestBufferPoolSyncsLogBeforeWriteBack(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(walPath(dir), SyncCommit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	pool := NewBufferPool(2)
+	pf, err := openPageFile(filepath.Join(dir, "t.db"), nil, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.close()
+
+	// Nothing commits, so only writing pages back syncs the log
+	const pages = 8
+	lsns := make([]int64, pages+1)
+	for n := uint32(1); n <= pages; n++ {
+		if err := w.append(walRecord{op: walDelete, txId: 1, table: "t", offset: int64(n) * pageSize}); err != nil {
+			t.Fatal(err)
+		}
+		lsns[n] = w.end()
+		fr, err := pf.pin(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr.data[100] = 1
+		pf.unpinLogged(fr, w)
+	}
+	for n := 1; n <= pages-pool.capacity; n++ {
+		if w.synced < lsns[n] {
+			t.Fatalf("page %d written back with the log synced to %d, before its record ending at %d", n, w.synced, lsns[n])
+		}
+	}
+}
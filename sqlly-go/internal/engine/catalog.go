@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// catalog is the persisted list of table schemas and sequences for one
+// database. It is rewritten whenever a table is created, dropped or altered,
+// and when a sequence runs out of reserved values.
+type catalog struct {
+	Tables    []TableSchema     `json:"tables"`
+	Sequences []SequenceDef     `json:"sequences,omitempty"`
+	Renames   map[string]string `json:"renames,omitempty"` // Old names of tables whose files may not be moved yet, by new name
+}
+
+// SequenceDef is a sequence created with CREATE SEQUENCE. Next is the first
+// value not reserved yet.
+type SequenceDef struct {
+	Name string `json:"name"`
+	Next int    `json:"next"`
+}
+
+func catalogPath(dbDir string) string {
+	return filepath.Join(dbDir, "catalog.json")
+}
+
+// loadCatalog reads a database catalog. A missing file yields (nil, nil).
+func loadCatalog(path string) (*catalog, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c catalog
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("corrupt catalog %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// saveCatalog writes the current table schemas and sequences; the caller
+// must hold db.mu, and db.seqMu or db.txMu exclusively so no sequence moves.
+// The file is synced and replaced atomically so a crash never leaves a
+// partial catalog, and is on disk when saveCatalog returns.
+func (db *Database) saveCatalog() error {
+	c := catalog{Tables: make([]TableSchema, 0, len(db.Tables))}
+	for _, t := range db.Tables {
+		schema := t.Schema
+		if t.autoId != nil {
+			schema.NextId = t.autoId.saved
+		}
+		c.Tables = append(c.Tables, schema)
+		if t.renamedFrom != "" {
+			if c.Renames == nil {
+				c.Renames = make(map[string]string)
+			}
+			c.Renames[schema.Name] = t.renamedFrom
+		}
+	}
+	sort.Slice(c.Tables, func(i, j int) bool { return c.Tables[i].Name < c.Tables[j].Name })
+	for name, s := range db.sequences {
+		c.Sequences = append(c.Sequences, SequenceDef{Name: name, Next: s.saved})
+	}
+	sort.Slice(c.Sequences, func(i, j int) bool { return c.Sequences[i].Name < c.Sequences[j].Name })
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := catalogPath(db.dir)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(db.dir)
+}
+
+// recoverTableFiles brings the files of the tables in cat in line with it
+// after a crash in the middle of a schema change, which changes the files and
+// the catalog in an order that lets it tell which happened:
+//
+//   - ALTER TABLE ... RENAME saves the catalog with the new name before
+//     moving the files, so files still under the old name are moved.
+//   - ALTER TABLE ... ADD COLUMN writes the new data file next to the old one
+//     (as name.db.new) before saving the catalog, and replaces the old file
+//     afterwards, so the new file is put in place if the catalog has its
+//     columns and removed otherwise.
+//
+// It fails if a table's data file is missing or was written with a number of
+// columns other than the catalog's, rather than let the table come up empty
+// or read its records wrongly.
+func recoverTableFiles(dir string, cat *catalog) error {
+	for _, schema := range cat.Tables {
+		path := tableFilePath(dir, schema.Name)
+		if from, ok := cat.Renames[schema.Name]; ok && !fileExists(path) && fileExists(tableFilePath(dir, from)) {
+			if err := os.Rename(tableFilePath(dir, from), path); err != nil {
+				return err
+			}
+			// The index is rebuilt if it did not follow
+			os.Rename(indexFilePath(dir, from), indexFilePath(dir, schema.Name))
+			if err := syncDir(dir); err != nil {
+				return err
+			}
+			log.Printf("database %s: finished renaming table %s to %s", filepath.Base(dir), from, schema.Name)
+		}
+
+		if pending := path + ".new"; fileExists(pending) {
+			n, err := fileColumns(pending)
+			if err != nil {
+				return err
+			}
+			if n == len(schema.Columns) {
+				// The index points into the old file, and is marked dirty
+				err = os.Rename(pending, path)
+			} else {
+				err = os.Remove(pending)
+			}
+			if err == nil {
+				err = syncDir(dir)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if !fileExists(path) {
+			return fmt.Errorf("data file %s of table %s is missing", path, schema.Name)
+		}
+		n, err := fileColumns(path)
+		if err != nil {
+			return err
+		}
+		if n != 0 && n != len(schema.Columns) {
+			return fmt.Errorf("data file %s has %d columns but the catalog gives table %s %d", path, n, schema.Name, len(schema.Columns))
+		}
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,88 @@
+package engine
+
+// cursorBatch is how many rows a cursor reads each time it locks its table.
+const cursorBatch = 256
+
+// Cursor reads rows of a table in id order, a batch at a time, so that
+// neither all of them are held in memory nor the table is kept locked while
+// the caller works through them:
+//
+//	c := table.Scan()
+//	defer c.Close()
+//	for c.Next() {
+//		row := c.Row()
+//		...
+//	}
+//	if err := c.Err(); err != nil {
+//		...
+//	}
+type Cursor struct {
+	t        *Table
+	snap     *snapshot
+	next, hi int   // Ids left to read, unless byIds
+	ids      []int // Ids left to read when byIds
+	byIds    bool
+	batch    []*Row // Read but not yet returned
+	changed  changedIds
+	row      *Row
+	err      error
+	done     bool // Nothing left to read
+}
+
+// changedIds holds the ids of the rows in a table's versions within a
+// cursor's range, sorted, as they were when the table's changedGen was gen.
+type changedIds struct {
+	ids   []int
+	gen   uint64
+	valid bool
+}
+
+// Next moves to the next row, reading another batch when needed. It returns
+// false once the rows run out or reading fails; Err tells which.
+func (c *Cursor) Next() bool {
+	for len(c.batch) == 0 {
+		if c.done || c.err != nil {
+			c.row = nil
+			return false
+		}
+		c.fetch()
+	}
+	c.row, c.batch = c.batch[0], c.batch[1:]
+	return true
+}
+
+func (c *Cursor) fetch() {
+	if c.byIds {
+		n := min(len(c.ids), cursorBatch)
+		c.batch, c.err = c.t.readIds(c.snap, c.ids[:n])
+		c.ids = c.ids[n:]
+		c.done = len(c.ids) == 0
+		return
+	}
+	c.batch, c.next, c.err = c.t.readRange(c.snap, c.next, c.hi, cursorBatch, &c.changed)
+	c.done = c.next > c.hi
+}
+
+// Row returns the row Next moved to.
+func (c *Cursor) Row() *Row {
+	return c.row
+}
+
+// Err returns the error that stopped the cursor, if any.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close stops the cursor; Next returns false from then on.
+func (c *Cursor) Close() error {
+	c.batch, c.row, c.done = nil, nil, true
+	return nil
+}
+
+// readIds reads the versions of rows ids that snap sees, skipping the rows
+// it sees none of.
+func (t *Table) readIds(snap *snapshot, ids []int) ([]*Row, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.readVisible(snap, ids)
+}
@@ -1,23 +1,94 @@
 package engine
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+)
+
+var (
+	ErrTableNotFound = &Error{Code: CodeUndefinedTable, Msg: "table not found"}
+	ErrTableExists   = &Error{Code: CodeDuplicateTable, Msg: "table already exists"}
 )
 
 type Database struct {
-	Name   string
-	Tables map[string]*Table
-	mu     sync.RWMutex
+	Name      string
+	Tables    map[string]*Table
+	dir       string
+	mu        sync.RWMutex
+	txMu      sync.RWMutex // Shared by running statements, exclusive for schema changes and checkpoints
+	seqMu     sync.Mutex   // Guards the counters of the sequences; taken before mu
+	sequences map[string]*sequence
+	txns      *txManager
+	wal       *wal
+	opts      Options
+	stop      chan struct{}
+
+	sessMu sync.Mutex
+	open   map[*Session]bool // Sessions with an open transaction
+	idle   chan struct{}     // Closed once no session has an open transaction; nil unless awaited
+	closed bool
 }
 
-func NewDatabase(name string) *Database {
+// NewDatabase opens the database stored in dir, creating the directory and an
+// empty catalog if needed, and recovers the table files from the write-ahead
+// log. The "default" database is seeded with sample tables the first time it
+// is created.
+func NewDatabase(dir, name string, opts Options) (*Database, error) {
+	if opts.BufferPool == nil {
+		opts.BufferPool = NewBufferPool(DefaultBufferPoolPages)
+	}
 	db := &Database{
-		Name:   name,
-		Tables: make(map[string]*Table),
+		Name:      name,
+		Tables:    make(map[string]*Table),
+		sequences: make(map[string]*sequence),
+		dir:       dir,
+		txns:      newTxManager(),
+		opts:      opts,
+		stop:      make(chan struct{}),
+		open:      make(map[*Session]bool),
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	cat, err := loadCatalog(catalogPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	if cat != nil {
+		if err := recoverTableFiles(dir, cat); err != nil {
+			return nil, err
+		}
+		if err := recoverDatabase(dir, cat); err != nil {
+			return nil, fmt.Errorf("recovering from write-ahead log: %w", err)
+		}
+	}
+	if db.wal, err = openWAL(walPath(dir), opts.Sync); err != nil {
+		return nil, err
+	}
+	if err := db.wal.reset(); err != nil {
+		return nil, err
+	}
+
+	if cat != nil {
+		for _, schema := range cat.Tables {
+			if db.Tables[schema.Name], err = db.newTable(schema); err != nil {
+				return nil, err
+			}
+		}
+		for _, def := range cat.Sequences {
+			db.sequences[def.Name] = newSequence(db, def.Next)
+		}
+		db.startCheckpoints()
+		return db, nil
 	}
 
 	if name == "default" {
@@ -27,375 +98,653 @@ func NewDatabase(name string) *Database {
 			{Name: "username", Type: StringType},
 			{Name: "age", Type: IntType},
 		}
-		db.Tables["users"] = NewTable(name, "users", userCols)
+		if db.Tables["users"], err = db.newTable(TableSchema{Name: "users", Columns: userCols}); err != nil {
+			return nil, err
+		}
 
 		orderCols := []ColumnDef{
 			{Name: "id", Type: IntType, IsPrimaryKey: true},
 			{Name: "user_id", Type: IntType},
 			{Name: "item", Type: StringType},
 		}
-		db.Tables["orders"] = NewTable(name, "orders", orderCols)
-
-		if len(db.Tables["users"].SelectAll()) == 0 {
-			db.ExecuteSql(`INSERT INTO users VALUES (001, "John Doe", 25)`)
-			db.ExecuteSql(`INSERT INTO users VALUES (002, "Jane Smith", 30)`)
-			db.ExecuteSql(`INSERT INTO orders VALUES (101, 001, "Laptop")`)
+		if db.Tables["orders"], err = db.newTable(TableSchema{Name: "orders", Columns: orderCols}); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.saveCatalog(); err != nil {
+		return nil, err
+	}
+	if name == "default" && db.Tables["users"].Count() == 0 {
+		if _, err := db.Execute(`INSERT INTO users VALUES (001, "John Doe", 25);
+			INSERT INTO users VALUES (002, "Jane Smith", 30);
+			INSERT INTO orders VALUES (101, 001, "Laptop")`); err != nil {
+			return nil, err
 		}
 	}
-	return db
+	db.startCheckpoints()
+	return db, nil
 }
 
-func (db *Database) ExecuteSql(sql string) string {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered in ExecuteSql", r)
+// newTable opens a table of the database, sharing its transaction manager
+// and buffer pool.
+func (db *Database) newTable(schema TableSchema) (*Table, error) {
+	t, err := NewTable(db.dir, schema, db.opts.BufferPool)
+	if err != nil {
+		return nil, fmt.Errorf("opening table %s: %w", schema.Name, err)
+	}
+	t.txns, t.wal = db.txns, db.wal
+	if id, _ := t.column("id"); id.AutoIncrement {
+		t.autoId = newSequence(db, max(schema.NextId, 1))
+	}
+	return t, nil
+}
+
+// recoverDatabase replays the write-ahead log left by the previous run onto
+// the table files and flushes them, so the log can be emptied afterwards.
+func recoverDatabase(dir string, cat *catalog) error {
+	records, err := readWAL(walPath(dir))
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	// Schema changes start from an empty log, so every table in it should
+	// still be in the catalog; skip any that is not rather than recreate it
+	known := make(map[string]bool, len(cat.Tables))
+	for _, schema := range cat.Tables {
+		known[schema.Name] = true
+	}
+	kept := records[:0]
+	for _, rec := range records {
+		if rec.op == walCommit || rec.op == walAbort || known[rec.table] {
+			kept = append(kept, rec)
 		}
-	}()
+	}
 
-	parts := strings.Fields(sql)
-	if len(parts) == 0 {
-		return ""
-	}
-	command := strings.ToUpper(parts[0])
-
-	switch command {
-	case "CREATE":
-		return db.handleCreate(sql)
-	case "DROP":
-		return db.handleDrop(sql)
-	case "INSERT":
-		return db.handleInsert(sql)
-	case "SELECT":
-		return db.handleSelect(sql)
-	case "UPDATE":
-		return db.handleUpdate(sql)
-	case "DELETE":
-		return db.handleDelete(sql)
-	default:
-		return "Unknown command."
+	tables, err := recoverWAL(dir, kept)
+	if err != nil {
+		return err
 	}
+	for _, name := range tables {
+		if err := syncFile(tableFilePath(dir, name)); err != nil {
+			return err
+		}
+	}
+	log.Printf("database %s: replayed %d write-ahead log records", filepath.Base(dir), len(kept))
+	return nil
 }
 
-func (db *Database) handleCreate(sql string) string {
-	openParen := strings.Index(sql, "(")
-	closeParen := strings.LastIndex(sql, ")")
+// checkpoint folds the row versions no transaction needs into the tables'
+// indexes, flushes every table, empties the write-ahead log and auto-vacuums
+// the tables. The caller must hold db.txMu exclusively, so no statement is
+// running. Transactions may be open between statements: their records stay
+// in the log, and the tables are not compacted under them.
+func (db *Database) checkpoint() error {
+	tables := db.tableList()
+	space, err := db.vacuumTables(tables)
+	if err != nil {
+		return err
+	}
+	if err := db.flush(tables); err != nil {
+		return err
+	}
+	if db.openTransactions() > 0 {
+		return nil
+	}
+	return db.autoVacuum(tables, space)
+}
 
-	if openParen == -1 || closeParen == -1 {
-		return "Syntax error. Usage: CREATE TABLE [name] (col type [UNIQUE], ...)"
+// flush syncs tables to disk and empties the write-ahead log but for the
+// records of open transactions, after which nothing else refers to record
+// offsets any more.
+func (db *Database) flush(tables []*Table) error {
+	for _, t := range tables {
+		if err := t.sync(); err != nil {
+			return err
+		}
+	}
+	if db.wal.len() == 0 {
+		return nil
 	}
+	return db.wal.truncate(db.txns.isActive)
+}
 
-	headerPart := strings.TrimSpace(sql[:openParen])
-	bodyPart := sql[openParen+1 : closeParen]
+func (db *Database) openTransactions() int {
+	db.sessMu.Lock()
+	defer db.sessMu.Unlock()
+	return len(db.open)
+}
 
-	headerSplit := strings.Fields(headerPart)
-	tableName := headerSplit[len(headerSplit)-1]
+// lockAlone takes db.txMu exclusively for a statement that runs alone, once
+// no session has a transaction open. Open transactions hold no lock between
+// their statements, so it waits for them without the lock, letting other
+// statements run meanwhile, and fails after opts.LockTimeout.
+func (db *Database) lockAlone() error {
+	timeout := time.NewTimer(db.opts.LockTimeout)
+	defer timeout.Stop()
+	for {
+		db.txMu.Lock()
+		db.sessMu.Lock()
+		if len(db.open) == 0 {
+			db.sessMu.Unlock()
+			return nil
+		}
+		if db.idle == nil {
+			db.idle = make(chan struct{})
+		}
+		idle := db.idle
+		db.sessMu.Unlock()
+		db.txMu.Unlock()
+
+		select {
+		case <-idle:
+		case <-timeout.C:
+			return errorf(CodeLockNotAvailable, "timed out after %v waiting for open transactions to end", db.opts.LockTimeout)
+		}
+	}
+}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	if _, exists := db.Tables[tableName]; exists {
-		return "Table already exists."
+func (db *Database) tableList() []*Table {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	tables := make([]*Table, 0, len(db.Tables))
+	for _, t := range db.Tables {
+		tables = append(tables, t)
 	}
+	return tables
+}
 
-	colDefs := strings.Split(bodyPart, ",")
-	var columns []ColumnDef
+// maybeCheckpoint checkpoints once the log has outgrown opts.CheckpointSize,
+// unless a statement is running: waiting for it would hold up every other
+// statement behind the checkpoint, so the next statement or periodic
+// checkpoint tries again. The caller must not hold db.txMu. Failures leave the log in
+// place and are only logged, since the commit itself has succeeded.
+func (db *Database) maybeCheckpoint() {
+	if db.opts.CheckpointSize <= 0 || db.wal.len() < db.opts.CheckpointSize {
+		return
+	}
+	if !db.txMu.TryLock() {
+		return
+	}
+	defer db.txMu.Unlock()
+	if err := db.checkpoint(); err != nil {
+		log.Printf("database %s: checkpoint failed: %v", db.Name, err)
+	}
+}
 
-	for _, c := range colDefs {
-		def := strings.Fields(strings.TrimSpace(c))
-		if len(def) < 2 {
-			continue
+// startCheckpoints checkpoints every opts.CheckpointInterval until Close,
+// skipping ticks at which a statement is running.
+func (db *Database) startCheckpoints() {
+	if db.opts.CheckpointInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(db.opts.CheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-db.stop:
+				return
+			case <-ticker.C:
+				if !db.txMu.TryLock() {
+					continue
+				}
+				if err := db.checkpoint(); err != nil {
+					log.Printf("database %s: checkpoint failed: %v", db.Name, err)
+				}
+				db.txMu.Unlock()
+			}
 		}
-		name := def[0]
-		typeStr := strings.ToLower(def[1])
-		isUnique := len(def) > 2 && strings.ToUpper(def[2]) == "UNIQUE"
+	}()
+}
 
-		colType := StringType
-		if typeStr == "int" {
-			colType = IntType
+// Close stops periodic checkpoints, rolls back the transactions sessions
+// still have open, checkpoints a last time and closes the table files and the
+// write-ahead log. It waits for statements already running to end.
+func (db *Database) Close() error {
+	close(db.stop)
+	db.sessMu.Lock()
+	db.closed = true
+	sessions := make([]*Session, 0, len(db.open))
+	for s := range db.open {
+		sessions = append(sessions, s)
+	}
+	db.sessMu.Unlock()
+	var err error
+	for _, s := range sessions {
+		if rbErr := s.Close(); err == nil {
+			err = rbErr
 		}
+	}
 
-		columns = append(columns, ColumnDef{Name: name, Type: colType, IsUnique: isUnique, IsPrimaryKey: name == "id"})
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+	if cpErr := db.checkpoint(); err == nil {
+		err = cpErr
+	}
+	if seqErr := db.saveSequences(); err == nil {
+		err = seqErr
+	}
+	for _, t := range db.tableList() {
+		if closeErr := t.close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := db.wal.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Execute runs sql in a new session. A transaction left open at the end of
+// sql is rolled back and fails the call (see endSession).
+func (db *Database) Execute(sql string) ([]*Result, error) {
+	s := db.NewSession()
+	results, err := s.Execute(sql)
+	return results, s.endSession(err)
+}
+
+// ExecuteStream runs sql like Session.ExecuteStream, in a session of its
+// own, which ends like that of Execute.
+func (db *Database) ExecuteStream(sql string, fn func(res *Result, rows *RowStream) error) error {
+	s := db.NewSession()
+	return s.endSession(s.ExecuteStream(sql, fn))
+}
+
+// execute runs a data or schema statement, recording row changes in tx.
+func (db *Database) execute(stmt Statement, tx *transaction) (*Result, error) {
+	if err := db.bindSequences(stmt); err != nil {
+		return nil, err
+	}
+	switch s := stmt.(type) {
+	case *CreateTableStmt:
+		return db.handleCreate(s)
+	case *DropTableStmt:
+		return db.handleDrop(s)
+	case *AlterTableStmt:
+		return db.handleAlter(s)
+	case *CreateIndexStmt:
+		return db.handleCreateIndex(s)
+	case *DropIndexStmt:
+		return db.handleDropIndex(s)
+	case *CreateSequenceStmt:
+		return db.handleCreateSequence(s)
+	case *DropSequenceStmt:
+		return db.handleDropSequence(s)
+	case *InsertStmt:
+		return db.handleInsert(s, tx)
+	case *SelectStmt:
+		return db.handleSelect(s, tx.snap)
+	case *ExplainStmt:
+		return db.handleExplain(s, tx.snap)
+	case *UpdateStmt:
+		return db.handleUpdate(s, tx)
+	case *DeleteStmt:
+		return db.handleDelete(s, tx)
+	default:
+		return nil, errorf(CodeNotSupported, "unknown command")
+	}
+}
+
+func (db *Database) handleCreate(stmt *CreateTableStmt) (*Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.Tables[stmt.Name]; exists {
+		return nil, fmt.Errorf("%w: %s", ErrTableExists, stmt.Name)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range stmt.Columns {
+		if seen[c.Name] {
+			return nil, errorf(CodeDuplicateColumn, "duplicate column '%s'", c.Name)
+		}
+		seen[c.Name] = true
 	}
 
 	// Validation: Must have 'id' int
 	hasId := false
-	for _, c := range columns {
+	for _, c := range stmt.Columns {
 		if c.Name == "id" && c.Type == IntType {
 			hasId = true
 			break
 		}
 	}
 	if !hasId {
-		return "Error: Table must include an 'id' column of type 'int'."
+		return nil, errorf(CodeInvalidTableDef, "table must include an 'id' column of type 'int'")
+	}
+	for _, c := range stmt.Columns {
+		if err := db.checkColumnDef(c); err != nil {
+			return nil, err
+		}
 	}
 
-	db.Tables[tableName] = NewTable(db.Name, tableName, columns)
-	return fmt.Sprintf("Table '%s' created successfully.", tableName)
-}
-
-func (db *Database) handleDrop(sql string) string {
-	parts := strings.Fields(sql)
-	if len(parts) < 3 {
-		return "Syntax error."
+	if err := checkTableName(db.dir, stmt.Name); err != nil {
+		return nil, err
+	}
+	t, err := db.newTable(TableSchema{Name: stmt.Name, Columns: stmt.Columns})
+	if err != nil {
+		return nil, &Error{Code: CodeIO, Msg: "creating the table file failed", Err: err}
 	}
-	tableName := parts[2]
+	db.Tables[stmt.Name] = t
+	if err := db.saveCatalog(); err != nil {
+		return nil, &Error{Code: CodeIO, Msg: "table created but catalog not saved", Err: err}
+	}
+	return &Result{Message: fmt.Sprintf("Table '%s' created successfully.", stmt.Name)}, nil
+}
 
+func (db *Database) handleDrop(stmt *DropTableStmt) (*Result, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if t, ok := db.Tables[tableName]; ok {
+	if t, ok := db.Tables[stmt.Name]; ok {
+		// Forget the table before removing its files, which a catalog
+		// listing it would fail to open without
+		delete(db.Tables, stmt.Name)
+		if err := db.saveCatalog(); err != nil {
+			db.Tables[stmt.Name] = t
+			return nil, &Error{Code: CodeIO, Msg: "catalog not saved; table not dropped", Err: err}
+		}
 		t.Drop()
-		delete(db.Tables, tableName)
-		return fmt.Sprintf("Table '%s' dropped.", tableName)
+		return &Result{Message: fmt.Sprintf("Table '%s' dropped.", stmt.Name)}, nil
 	}
-	return "Table not found."
+	return nil, fmt.Errorf("%w: %s", ErrTableNotFound, stmt.Name)
 }
 
-func (db *Database) handleInsert(sql string) string {
-	// Simple parsing: INSERT INTO users VALUES (1, "name", 2)
-	idxInto := strings.Index(strings.ToUpper(sql), "INTO ")
-	idxValues := strings.Index(strings.ToUpper(sql), " VALUES")
-	if idxInto == -1 || idxValues == -1 {
-		return "Syntax Error."
-	}
-
-	tableName := strings.TrimSpace(sql[idxInto+5 : idxValues])
-	valPart := strings.TrimSpace(sql[idxValues+7:])
-	valPart = strings.Trim(valPart, "()")
-	values := strings.Split(valPart, ",")
-
-	db.mu.RLock()
-	table, ok := db.Tables[tableName]
-	db.mu.RUnlock()
+func (db *Database) handleCreateIndex(stmt *CreateIndexStmt) (*Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
+	t, ok := db.Tables[stmt.Table]
 	if !ok {
-		return "Table not found."
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, stmt.Table)
 	}
-
-	row := NewRow()
-	for i, col := range table.Schema.Columns {
-		if i >= len(values) {
-			break
-		}
-		rawVal := strings.TrimSpace(values[i])
-		// strip quotes
-		rawVal = strings.Trim(rawVal, "\"'")
-
-		if col.Name == "id" {
-			id, _ := strconv.Atoi(rawVal)
-			row.Id = id
+	if db.indexTable(stmt.Index.Name) != nil {
+		return nil, errorf(CodeDuplicateTable, "index '%s' already exists", stmt.Index.Name)
+	}
+	seen := make(map[string]bool)
+	for _, name := range stmt.Index.Columns {
+		if _, ok := t.column(name); !ok {
+			return nil, errorf(CodeUndefinedColumn, "column '%s' not found", name)
 		}
-
-		if col.Type == IntType {
-			val, _ := strconv.Atoi(rawVal)
-			row.Data[col.Name] = val
-		} else {
-			row.Data[col.Name] = rawVal
+		if seen[name] {
+			return nil, errorf(CodeDuplicateColumn, "column '%s' appears twice in index '%s'", name, stmt.Index.Name)
 		}
+		seen[name] = true
 	}
 
-	if err := table.Insert(row); err != nil {
-		return fmt.Sprintf("Error: %s", err.Error())
+	if err := t.CreateIndex(stmt.Index); err != nil {
+		return nil, err
+	}
+	if err := db.saveCatalog(); err != nil {
+		return nil, &Error{Code: CodeIO, Msg: "index created but catalog not saved", Err: err}
 	}
-	return "Row inserted successfully."
+	return &Result{Message: fmt.Sprintf("Index '%s' created.", stmt.Index.Name)}, nil
 }
 
-func (db *Database) handleSelect(sql string) string {
-	if strings.Contains(strings.ToUpper(sql), " JOIN ") {
-		return db.handleJoin(sql)
+func (db *Database) handleDropIndex(stmt *DropIndexStmt) (*Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t := db.indexTable(stmt.Name)
+	if t == nil {
+		return nil, errorf(CodeUndefinedObject, "index '%s' not found", stmt.Name)
+	}
+	if err := t.DropIndex(stmt.Name); err != nil {
+		return nil, err
 	}
+	if err := db.saveCatalog(); err != nil {
+		return nil, &Error{Code: CodeIO, Msg: "index dropped but catalog not saved", Err: err}
+	}
+	return &Result{Message: fmt.Sprintf("Index '%s' dropped.", stmt.Name)}, nil
+}
 
-	parts := strings.Fields(sql)
-	tableName := ""
-	if len(parts) > 3 {
-		tableName = parts[3]
+// indexTable returns the table holding the index created as name, if any.
+// Index names are unique within a database. The caller must hold db.mu.
+func (db *Database) indexTable(name string) *Table {
+	for _, t := range db.Tables {
+		for _, def := range t.Schema.Indexes {
+			if def.Name == name {
+				return t
+			}
+		}
 	}
+	return nil
+}
 
-	db.mu.RLock()
-	table, ok := db.Tables[tableName]
-	db.mu.RUnlock()
+func (db *Database) handleAlter(stmt *AlterTableStmt) (*Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
+	t, ok := db.Tables[stmt.Name]
 	if !ok {
-		return "Table not found."
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, stmt.Name)
 	}
-
-	// Check for WHERE ID=
-	whereIdx := strings.Index(strings.ToUpper(sql), "WHERE ID=")
-	if whereIdx != -1 {
-		idPart := strings.TrimSpace(sql[whereIdx+9:])
-		id, _ := strconv.Atoi(idPart)
-		row := table.SelectById(id)
-		if row == nil {
-			return "No results."
+	// The table's files change only once the catalog describes the change
+	save := func() error {
+		if err := db.saveCatalog(); err != nil {
+			return &Error{Code: CodeIO, Msg: "catalog not saved; table not altered", Err: err}
 		}
-		b, _ := json.Marshal(row.Data)
-		return string(b)
+		return nil
 	}
 
-	rows := table.SelectAll()
-	var sb strings.Builder
-	for _, r := range rows {
-		b, _ := json.Marshal(r.Data)
-		sb.Write(b)
-		sb.WriteString("\n")
+	switch {
+	case stmt.AddColumn != nil:
+		col := *stmt.AddColumn
+		if _, exists := t.column(col.Name); exists {
+			return nil, errorf(CodeDuplicateColumn, "duplicate column '%s'", col.Name)
+		}
+		if err := db.checkColumnDef(col); err != nil {
+			return nil, err
+		}
+		// Existing rows get the default; a NEXTVAL default would save the
+		// catalog while db.mu is held
+		fill, varies, err := db.columnDefault(col)
+		if err != nil {
+			return nil, err
+		}
+		if varies {
+			return nil, errorf(CodeNotSupported, "adding a column whose DEFAULT calls NEXTVAL is not supported")
+		}
+		if err := t.AddColumn(col, fill, save); err != nil {
+			return nil, err
+		}
+	case stmt.RenameTo != "":
+		if _, exists := db.Tables[stmt.RenameTo]; exists {
+			return nil, fmt.Errorf("%w: %s", ErrTableExists, stmt.RenameTo)
+		}
+		if err := t.Rename(stmt.RenameTo, save); err != nil {
+			return nil, err
+		}
+		delete(db.Tables, stmt.Name)
+		db.Tables[stmt.RenameTo] = t
 	}
-	return sb.String()
+	return &Result{Message: fmt.Sprintf("Table '%s' altered.", stmt.Name)}, nil
 }
 
-func (db *Database) handleJoin(sql string) string {
-	// SELECT * FROM t1 JOIN t2 ON t1.c = t2.c
-	upperSql := strings.ToUpper(sql)
-	idxFrom := strings.Index(upperSql, "FROM ")
-	idxJoin := strings.Index(upperSql, " JOIN ")
-	idxOn := strings.Index(upperSql, " ON ")
-
-	if idxFrom == -1 || idxJoin == -1 || idxOn == -1 {
-		return "Syntax error."
-	}
-
-	t1Name := strings.TrimSpace(sql[idxFrom+5 : idxJoin])
-	t2Name := strings.TrimSpace(sql[idxJoin+6 : idxOn])
-	condition := strings.TrimSpace(sql[idxOn+4:])
-
+func (db *Database) handleDelete(stmt *DeleteStmt, tx *transaction) (*Result, error) {
+	// DELETE FROM table [WHERE cond]
 	db.mu.RLock()
-	t1, ok1 := db.Tables[t1Name]
-	t2, ok2 := db.Tables[t2Name]
+	t, ok := db.Tables[stmt.Table]
 	db.mu.RUnlock()
 
-	if !ok1 || !ok2 {
-		return "Table not found."
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, stmt.Table)
 	}
 
-	condParts := strings.Split(condition, "=")
-	if len(condParts) != 2 {
-		return "Invalid join condition"
+	ret, err := compileReturning(stmt.Returning, t, stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.matchingRows(t, stmt.Table, stmt.Where, tx.snap)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if err := tx.delete(t, row.Id); err != nil {
+			return nil, err
+		}
+		if err := ret.add(row); err != nil {
+			return nil, err
+		}
 	}
+	return ret.result(&Result{Message: fmt.Sprintf("%d row(s) deleted.", len(rows)), RowsAffected: len(rows)}), nil
+}
 
-	// Parse t1.col and t2.col
-	left := strings.Split(strings.TrimSpace(condParts[0]), ".")
-	right := strings.Split(strings.TrimSpace(condParts[1]), ".")
-	if len(left) != 2 || len(right) != 2 {
-		return "Cols must be t.col"
+func (db *Database) handleUpdate(stmt *UpdateStmt, tx *transaction) (*Result, error) {
+	// UPDATE table SET col = expr, ... [WHERE cond]
+	db.mu.RLock()
+	t, ok := db.Tables[stmt.Table]
+	db.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, stmt.Table)
 	}
 
-	t1Col := left[1]
-	if left[0] != t1Name {
-		t1Col = right[1]
+	// Compile the assignments against the old row values
+	sc := tableScope(t, stmt.Table)
+	cols := make([]ColumnDef, len(stmt.Set))
+	exprs := make([]evalFunc, len(stmt.Set))
+	for i, set := range stmt.Set {
+		col, ok := t.column(set.Column)
+		if !ok {
+			return nil, errorf(CodeUndefinedColumn, "column '%s' not found", set.Column)
+		}
+		if col.Name == "id" {
+			return nil, errorf(CodeNotSupported, "updating the 'id' column is not supported")
+		}
+		eval, err := compileExpr(set.Value, sc)
+		if err != nil {
+			return nil, err
+		}
+		cols[i], exprs[i] = col, eval
 	}
-	t2Col := right[1]
-	if right[0] != t2Name {
-		t2Col = left[1]
+	ret, err := compileReturning(stmt.Returning, t, stmt.Table)
+	if err != nil {
+		return nil, err
 	}
 
-	rows1 := t1.SelectAll()
-	rows2 := t2.SelectAll()
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("--- JOIN RESULT (%s + %s) ---\n", t1Name, t2Name))
-
-	for _, r1 := range rows1 {
-		for _, r2 := range rows2 {
-			val1 := fmt.Sprintf("%v", r1.Data[t1Col])
-			val2 := fmt.Sprintf("%v", r2.Data[t2Col])
-
-			if val1 == val2 {
-				sb.WriteString(fmt.Sprintf("%d | ", r1.Id))
-				for k, v := range r1.Data {
-					if k != "id" {
-						sb.WriteString(fmt.Sprintf("%v, ", v))
-					}
-				}
-				sb.WriteString(" <-> ")
-				for k, v := range r2.Data {
-					if k != "id" {
-						sb.WriteString(fmt.Sprintf("%v, ", v))
-					}
-				}
-				sb.WriteString("\n")
+	rows, err := db.matchingRows(t, stmt.Table, stmt.Where, tx.snap)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		old := rowValues(t, row)
+		for i, col := range cols {
+			v, err := exprs[i](old)
+			if err != nil {
+				return nil, err
+			}
+			if row.Data[col.Name], err = coerceValue(col, v); err != nil {
+				return nil, err
 			}
 		}
+		if err := tx.update(t, row); err != nil {
+			return nil, err
+		}
+		if err := ret.add(row); err != nil {
+			return nil, err
+		}
 	}
-
-	return sb.String()
+	return ret.result(&Result{Message: fmt.Sprintf("%d row(s) updated.", len(rows)), RowsAffected: len(rows)}), nil
 }
 
-func (db *Database) handleDelete(sql string) string {
-	// DELETE FROM table WHERE id=1
-	parts := strings.Fields(sql)
-	if len(parts) < 5 {
-		return "Syntax error."
-	}
-	tableName := parts[2]
-	
-	whereClause := strings.Join(parts[4:], "")
-	if !strings.HasPrefix(strings.ToUpper(whereClause), "ID=") {
-		return "Only delete by ID supported."
+// checkColumnDef validates the AUTOINCREMENT and DEFAULT options of a new
+// column. The caller must hold db.mu.
+func (db *Database) checkColumnDef(col ColumnDef) error {
+	if col.AutoIncrement {
+		if col.Name != "id" {
+			return errorf(CodeInvalidTableDef, "only the 'id' column can be AUTOINCREMENT")
+		}
+		if col.Default != "" {
+			return errorf(CodeInvalidTableDef, "column 'id' cannot have both AUTOINCREMENT and a DEFAULT")
+		}
 	}
-	
-	idStr := strings.TrimPrefix(strings.ToUpper(whereClause), "ID=")
-	id, _ := strconv.Atoi(idStr)
-
-	db.mu.RLock()
-	t, ok := db.Tables[tableName]
-	db.mu.RUnlock()
-
-	if !ok {
-		return "Table not found."
+	fill, varies, err := db.columnDefault(col)
+	if err != nil {
+		return err
 	}
-
-	if err := t.Delete(id); err != nil {
-		return err.Error()
+	// Catch a default of the wrong type now rather than at the first insert
+	if !varies {
+		_, err = fill()
 	}
-	return "Row deleted successfully."
+	return err
 }
 
-func (db *Database) handleUpdate(sql string) string {
-	// UPDATE table SET col=val WHERE id=1
-	upperSql := strings.ToUpper(sql)
-	idxSet := strings.Index(upperSql, " SET ")
-	idxWhere := strings.Index(upperSql, " WHERE ")
-	
-	if idxSet == -1 || idxWhere == -1 {
-		return "Syntax error."
+// columnDefault compiles col's DEFAULT expression into a function returning
+// the value a new row gets, NULL when there is none, and reports whether it
+// may differ between calls. The caller must hold db.mu.
+func (db *Database) columnDefault(col ColumnDef) (fill func() (interface{}, error), varies bool, err error) {
+	if col.Default == "" {
+		return func() (interface{}, error) { return nil, nil }, false, nil
+	}
+	e, err := parseExprText(col.Default)
+	if err != nil {
+		return nil, false, fmt.Errorf("DEFAULT of column '%s': %w", col.Name, err)
 	}
-	
-	tableName := strings.TrimSpace(sql[6:idxSet]) // 6 is len("UPDATE")
-	setClause := strings.TrimSpace(sql[idxSet+5:idxWhere])
-	whereClause := strings.TrimSpace(sql[idxWhere+7:])
+	if err := db.bindExprs([]Expr{e}); err != nil {
+		return nil, false, err
+	}
+	eval, err := compileExpr(e, &scope{})
+	if CodeOf(err) == CodeUndefinedColumn {
+		return nil, false, errorf(CodeInvalidTableDef, "cannot use column reference in DEFAULT expression of column '%s'", col.Name)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return func() (interface{}, error) {
+		v, err := eval(nil)
+		if err != nil {
+			return nil, err
+		}
+		return coerceValue(col, v)
+	}, volatile(e), nil
+}
 
-	db.mu.RLock()
-	t, ok := db.Tables[tableName]
-	db.mu.RUnlock()
-	if !ok { return "Table not found." }
-
-	// ID check
-	idParts := strings.Split(whereClause, "=")
-	if len(idParts) < 2 { return "Invalid ID" }
-	id, _ := strconv.Atoi(strings.TrimSpace(idParts[1]))
-
-	row := t.SelectById(id)
-	if row == nil { return "Row not found." }
-
-	// Apply updates
-	updates := strings.Split(setClause, ",")
-	for _, up := range updates {
-		kv := strings.Split(up, "=")
-		key := strings.TrimSpace(kv[0])
-		val := strings.Trim(strings.TrimSpace(kv[1]), "\"'")
-
-		// Find col type
-		for _, col := range t.Schema.Columns {
-			if col.Name == key {
-				if col.Type == IntType {
-					intVal, _ := strconv.Atoi(val)
-					row.Data[key] = intVal
-				} else {
-					row.Data[key] = val
+// coerceValue converts an evaluated value to the Go type stored for col.
+// NULL stays nil; the table checks NOT NULL constraints.
+func coerceValue(col ColumnDef, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch col.Type {
+	case IntType:
+		i, ok := 0, false
+		switch n := v.(type) {
+		case int:
+			i, ok = n, true
+		case float64:
+			if n == math.Trunc(n) && !math.IsInf(n, 0) {
+				if n < math.MinInt32 || n > math.MaxInt32 {
+					return nil, intRangeErr(col, v)
 				}
+				i, ok = int(n), true
+			}
+		case string:
+			var err error
+			i, err = strconv.Atoi(strings.TrimSpace(n))
+			if errors.Is(err, strconv.ErrRange) {
+				return nil, intRangeErr(col, v)
 			}
+			ok = err == nil
+		}
+		if !ok {
+			return nil, errorf(CodeTypeMismatch, "column '%s' expects an int, got %s %v", col.Name, typeName(v), v)
+		}
+		// Ints are stored in 32 bits
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, intRangeErr(col, v)
 		}
+		return i, nil
+	default:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case bool:
+			return nil, errorf(CodeTypeMismatch, "column '%s' expects a string, got boolean", col.Name)
+		}
+		return fmt.Sprint(v), nil
 	}
+}
 
-	if err := t.Update(row); err != nil {
-		return err.Error()
-	}
-	return "Row updated successfully."
-}
\ No newline at end of file
+// intRangeErr reports a value too large for the 32 bits an int is stored in.
+func intRangeErr(col ColumnDef, v interface{}) error {
+	return errorf(CodeNumericRange, "value %v is out of range for int column '%s'", v, col.Name)
+}
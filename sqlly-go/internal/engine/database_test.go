@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testOptions leaves checkpoints to the test and flushing to the operating
+// system, which is enough to survive the process going away.
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.Sync = SyncOff
+	opts.CheckpointInterval = 0
+	opts.CheckpointSize = 0
+	return opts
+}
+
+func openTestDB(t *testing.T, dir string, opts Options) *Database {
+	t.Helper()
+	db, err := NewDatabase(dir, "test", opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	return db
+}
+
+// mustExec runs sql in s and fails the test if it fails.
+func mustExec(t *testing.T, s *Session, sql string) []*Result {
+	t.Helper()
+	res, err := s.Execute(sql)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	return res
+}
+
+// queryRows runs the query sql in s and returns its rows.
+func queryRows(t *testing.T, s *Session, sql string) [][]interface{} {
+	t.Helper()
+	res := mustExec(t, s, sql)
+	return res[len(res)-1].Rows
+}
+
+// execFails runs sql in s and checks that it fails with code.
+func execFails(t *testing.T, s *Session, sql string, code Code) {
+	t.Helper()
+	_, err := s.Execute(sql)
+	if CodeOf(err) != code {
+		t.Fatalf("%s: got error %v (code %q), want code %q", sql, err, CodeOf(err), code)
+	}
+}
+
+func checkRows(t *testing.T, s *Session, sql string, want [][]interface{}) {
+	t.Helper()
+	if got := queryRows(t, s, sql); !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: got %v, want %v", sql, got, want)
+	}
+}
+
+func TestIntOutOfRange(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	mustExec(t, s, `CREATE TABLE t (id INT PRIMARY KEY, n INT);
+		INSERT INTO t VALUES (1, 2147483647), (2, -2147483648)`)
+
+	for _, sql := range []string{
+		"INSERT INTO t VALUES (3, 2147483648)",
+		"INSERT INTO t VALUES (3, -2147483649)",
+		"INSERT INTO t VALUES (3, '3000000000')",
+		"INSERT INTO t VALUES (4294967297, 0)",
+		"UPDATE t SET n = n + 1 WHERE id = 1",
+		"UPDATE t SET n = n - 1 WHERE id = 2",
+	} {
+		execFails(t, s, sql, CodeNumericRange)
+	}
+	checkRows(t, s, "SELECT id, n FROM t ORDER BY id", [][]interface{}{{1, 2147483647}, {2, -2147483648}})
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code is a stable, SQLSTATE-like identifier for a class of engine error.
+// Values follow PostgreSQL's codes where one exists.
+type Code string
+
+const (
+	CodeSyntax              Code = "42601" // Malformed statement
+	CodeUndefinedTable      Code = "42P01"
+	CodeUndefinedColumn     Code = "42703"
+	CodeUndefinedFunction   Code = "42883"
+	CodeUndefinedDatabase   Code = "3D000"
+	CodeUndefinedObject     Code = "42704" // e.g. an unknown index
+	CodeAmbiguousColumn     Code = "42702"
+	CodeDuplicateTable      Code = "42P07"
+	CodeDuplicateColumn     Code = "42701"
+	CodeDuplicateAlias      Code = "42712"
+	CodeInvalidName         Code = "42602"
+	CodeInvalidTableDef     Code = "42P16"
+	CodeInvalidColumnRef    Code = "42P10" // e.g. ORDER BY position out of range
+	CodeGrouping            Code = "42803" // Misplaced aggregate or ungrouped column
+	CodeTypeMismatch        Code = "42804"
+	CodeUniqueViolation     Code = "23505" // Primary key or UNIQUE constraint
+	CodeNotNullViolation    Code = "23502"
+	CodeCardinality         Code = "21000" // e.g. ON CONFLICT DO UPDATE changing a row twice
+	CodeDivisionByZero      Code = "22012"
+	CodeNumericRange        Code = "22003" // e.g. an int outside the 32-bit range
+	CodeSequenceLimit       Code = "2200H" // Sequence or AUTOINCREMENT id past the largest int
+	CodeActiveTransaction   Code = "25001" // e.g. BEGIN or CREATE TABLE inside a transaction
+	CodeNoActiveTransaction Code = "25P01"
+	CodeLockNotAvailable    Code = "55P03" // e.g. a schema change waiting too long for open transactions
+	CodeSerialization       Code = "40001" // Row changed by a concurrent transaction
+	CodeUndefinedSavepoint  Code = "3B001"
+	CodeInvalidParameter    Code = "22023" // e.g. negative LIMIT
+	CodeNotSupported        Code = "0A000"
+	CodeProgramLimit        Code = "54000" // e.g. a row too big for a page
+	CodeInvalidRequest      Code = "08P01" // Request to the server could not be decoded
+	CodeIO                  Code = "58030" // Reading or writing the data directory failed
+	CodeInternal            Code = "XX000"
+)
+
+// Error is an engine error carrying its Code. Callers usually see it wrapped
+// with extra context; use CodeOf or errors.As to recover it.
+type Error struct {
+	Code Code
+	Msg  string
+	Err  error // Underlying cause, if any
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Msg, e.Err.Error())
+	}
+	return e.Msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func errorf(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// CodeOf returns the code of the first engine error in err's chain, treating
+// syntax errors as CodeSyntax and anything unclassified as CodeInternal.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return CodeSyntax
+	}
+	var engineErr *Error
+	if errors.As(err, &engineErr) {
+		return engineErr.Code
+	}
+	return CodeInternal
+}
@@ -0,0 +1,455 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// scopeColumn describes one value slot of the rows an expression is evaluated
+// against.
+type scopeColumn struct {
+	Table string // Table name (or alias) the column belongs to
+	Name  string
+	Type  DbType
+}
+
+// scope is the list of columns visible to an expression, in row order.
+type scope struct {
+	columns []scopeColumn
+	joined  bool // Spans several tables
+}
+
+// tableScope exposes a table's columns in schema order under the given name.
+func tableScope(t *Table, name string) *scope {
+	sc := &scope{}
+	for _, col := range t.Schema.Columns {
+		sc.columns = append(sc.columns, scopeColumn{Table: name, Name: col.Name, Type: col.Type})
+	}
+	return sc
+}
+
+// resolve finds the slot for a column reference. Names are case-insensitive.
+func (sc *scope) resolve(ref *ColumnRef) (int, error) {
+	idx := -1
+	for i, c := range sc.columns {
+		if !strings.EqualFold(c.Name, ref.Name) {
+			continue
+		}
+		if ref.Table != "" && !strings.EqualFold(c.Table, ref.Table) {
+			continue
+		}
+		if idx != -1 {
+			return -1, errorf(CodeAmbiguousColumn, "column reference '%s' is ambiguous", ref.Name)
+		}
+		idx = i
+	}
+	if idx == -1 {
+		if ref.Table != "" {
+			return -1, errorf(CodeUndefinedColumn, "unknown column '%s.%s'", ref.Table, ref.Name)
+		}
+		return -1, errorf(CodeUndefinedColumn, "unknown column '%s'", ref.Name)
+	}
+	return idx, nil
+}
+
+// outputName is the result column name for slot i: qualified as "t.col"
+// when the scope spans several tables.
+func (sc *scope) outputName(i int) string {
+	c := sc.columns[i]
+	if sc.joined {
+		return c.Table + "." + c.Name
+	}
+	return c.Name
+}
+
+// rowValues lays out a row's data in schema order, matching tableScope.
+func rowValues(t *Table, row *Row) []interface{} {
+	vals := make([]interface{}, len(t.Schema.Columns))
+	for i, col := range t.Schema.Columns {
+		vals[i] = row.Data[col.Name]
+	}
+	return vals
+}
+
+// evalFunc evaluates a compiled expression against one row laid out by a scope.
+type evalFunc func(row []interface{}) (interface{}, error)
+
+// compileExpr resolves column references once so that evaluating an
+// expression per row is just a walk over closures.
+func compileExpr(e Expr, sc *scope) (evalFunc, error) {
+	switch e := e.(type) {
+	case *Literal:
+		v := e.Value
+		return func([]interface{}) (interface{}, error) { return v, nil }, nil
+
+	case *ColumnRef:
+		idx, err := sc.resolve(e)
+		if err != nil {
+			return nil, err
+		}
+		return func(row []interface{}) (interface{}, error) { return row[idx], nil }, nil
+
+	case *UnaryExpr:
+		operand, err := compileExpr(e.Operand, sc)
+		if err != nil {
+			return nil, err
+		}
+		op := e.Op
+		return func(row []interface{}) (interface{}, error) {
+			v, err := operand(row)
+			if err != nil {
+				return nil, err
+			}
+			return evalUnary(op, v)
+		}, nil
+
+	case *BinaryExpr:
+		left, err := compileExpr(e.Left, sc)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileExpr(e.Right, sc)
+		if err != nil {
+			return nil, err
+		}
+		op := e.Op
+		switch op {
+		case "AND", "OR":
+			return func(row []interface{}) (interface{}, error) {
+				l, err := left(row)
+				if err != nil {
+					return nil, err
+				}
+				// Short-circuit: FALSE AND x, TRUE OR x
+				if lb, ok := l.(bool); ok && lb == (op == "OR") {
+					return lb, nil
+				}
+				r, err := right(row)
+				if err != nil {
+					return nil, err
+				}
+				return evalLogic(op, l, r)
+			}, nil
+		}
+		return func(row []interface{}) (interface{}, error) {
+			l, err := left(row)
+			if err != nil {
+				return nil, err
+			}
+			r, err := right(row)
+			if err != nil {
+				return nil, err
+			}
+			return evalBinary(op, l, r)
+		}, nil
+
+	case *FuncCall:
+		if isAggregate(e.Name) {
+			return nil, errorf(CodeGrouping, "aggregate function %s is not allowed here", e.Name)
+		}
+		return compileFunc(e)
+	}
+	return nil, errorf(CodeInternal, "unsupported expression %T", e)
+}
+
+// niladicFunctions can be called without parentheses, as in standard SQL.
+var niladicFunctions = map[string]bool{"CURRENT_TIMESTAMP": true, "CURRENT_DATE": true}
+
+// compileFunc compiles a call to a scalar function. Times are UTC and
+// returned as strings, in the formats the string column type sorts by.
+func compileFunc(e *FuncCall) (evalFunc, error) {
+	args := func(n int) error {
+		if len(e.Args) != n || e.Star || e.Distinct {
+			return errorf(CodeUndefinedFunction, "function %s takes %d argument(s)", e.Name, n)
+		}
+		return nil
+	}
+	switch e.Name {
+	case "CURRENT_TIMESTAMP", "NOW":
+		if err := args(0); err != nil {
+			return nil, err
+		}
+		return func([]interface{}) (interface{}, error) {
+			return time.Now().UTC().Format("2006-01-02 15:04:05"), nil
+		}, nil
+	case "CURRENT_DATE":
+		if err := args(0); err != nil {
+			return nil, err
+		}
+		return func([]interface{}) (interface{}, error) {
+			return time.Now().UTC().Format("2006-01-02"), nil
+		}, nil
+	case "NEXTVAL":
+		if err := args(1); err != nil {
+			return nil, err
+		}
+		seq := e.seq
+		if seq == nil {
+			return nil, errorf(CodeNotSupported, "NEXTVAL cannot be used here")
+		}
+		return func([]interface{}) (interface{}, error) { return seq.nextValue() }, nil
+	}
+	return nil, errorf(CodeUndefinedFunction, "unknown function %s", e.Name)
+}
+
+// volatile reports whether e calls a function that may return a different
+// value each time, so it cannot be evaluated ahead of time.
+func volatile(e Expr) bool {
+	switch e := e.(type) {
+	case *BinaryExpr:
+		return volatile(e.Left) || volatile(e.Right)
+	case *UnaryExpr:
+		return volatile(e.Operand)
+	case *FuncCall:
+		if e.Name == "NEXTVAL" {
+			return true
+		}
+		for _, a := range e.Args {
+			if volatile(a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compilePredicate compiles a WHERE/ON condition. A nil expression matches
+// every row.
+func compilePredicate(e Expr, sc *scope) (func(row []interface{}) (bool, error), error) {
+	if e == nil {
+		return func([]interface{}) (bool, error) { return true, nil }, nil
+	}
+	eval, err := compileExpr(e, sc)
+	if err != nil {
+		return nil, err
+	}
+	return asPredicate(eval), nil
+}
+
+// asPredicate wraps an evaluator whose result must be a boolean.
+func asPredicate(eval evalFunc) func(row []interface{}) (bool, error) {
+	return func(row []interface{}) (bool, error) {
+		v, err := eval(row)
+		if err != nil {
+			return false, err
+		}
+		if v == nil {
+			return false, nil // UNKNOWN does not match
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return false, errorf(CodeTypeMismatch, "condition must be a boolean expression, got %s", typeName(v))
+		}
+		return b, nil
+	}
+}
+
+// evalConst evaluates an expression that may not reference any column.
+func evalConst(e Expr) (interface{}, error) {
+	eval, err := compileExpr(e, &scope{})
+	if err != nil {
+		return nil, err
+	}
+	return eval(nil)
+}
+
+// evalLogic applies AND/OR with SQL three-valued logic, NULL being UNKNOWN.
+func evalLogic(op string, l, r interface{}) (interface{}, error) {
+	for _, v := range []interface{}{l, r} {
+		if _, ok := v.(bool); !ok && v != nil {
+			return nil, errorf(CodeTypeMismatch, "operand of %s must be a boolean, got %s", op, typeName(v))
+		}
+	}
+	lb, lok := l.(bool)
+	rb, rok := r.(bool)
+	if op == "AND" {
+		if (lok && !lb) || (rok && !rb) {
+			return false, nil
+		}
+	} else if (lok && lb) || (rok && rb) {
+		return true, nil
+	}
+	if !lok || !rok {
+		return nil, nil
+	}
+	return op == "AND", nil
+}
+
+func evalUnary(op string, v interface{}) (interface{}, error) {
+	switch op {
+	case "IS NULL":
+		return v == nil, nil
+	case "IS NOT NULL":
+		return v != nil, nil
+	}
+	if v == nil {
+		return nil, nil // NULL in, NULL out
+	}
+	switch op {
+	case "NOT":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errorf(CodeTypeMismatch, "operand of NOT must be a boolean, got %s", typeName(v))
+		}
+		return !b, nil
+	case "-":
+		switch n := v.(type) {
+		case int:
+			return -n, nil
+		case float64:
+			return -n, nil
+		}
+	case "+":
+		switch v.(type) {
+		case int, float64:
+			return v, nil
+		}
+	}
+	return nil, errorf(CodeTypeMismatch, "cannot apply unary %s to %s", op, typeName(v))
+}
+
+func evalBinary(op string, l, r interface{}) (interface{}, error) {
+	if l == nil || r == nil {
+		return nil, nil // Comparisons and arithmetic with NULL yield NULL
+	}
+	switch op {
+	case "=", "<>", "<", "<=", ">", ">=":
+		c, err := compareValues(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "=":
+			return c == 0, nil
+		case "<>":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case "||":
+		return fmt.Sprint(l) + fmt.Sprint(r), nil
+	case "+", "-", "*", "/", "%":
+		return evalArithmetic(op, l, r)
+	}
+	return nil, errorf(CodeInternal, "unknown operator %s", op)
+}
+
+func evalArithmetic(op string, l, r interface{}) (interface{}, error) {
+	li, lInt := l.(int)
+	ri, rInt := r.(int)
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/", "%":
+			if ri == 0 {
+				return nil, errorf(CodeDivisionByZero, "division by zero")
+			}
+			if op == "/" {
+				return li / ri, nil
+			}
+			return li % ri, nil
+		}
+	}
+
+	lf, lNum := toFloat(l)
+	rf, rNum := toFloat(r)
+	if !lNum || !rNum {
+		return nil, errorf(CodeTypeMismatch, "cannot apply %s to %s and %s", op, typeName(l), typeName(r))
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, errorf(CodeDivisionByZero, "division by zero")
+		}
+		return lf / rf, nil
+	}
+	return nil, errorf(CodeTypeMismatch, "cannot apply %s to %s and %s", op, typeName(l), typeName(r))
+}
+
+// compareValues orders two values of compatible types: numbers numerically,
+// strings lexically and booleans false < true.
+func compareValues(l, r interface{}) (int, error) {
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return strings.Compare(ls, rs), nil
+		}
+	}
+	if li, ok := l.(int); ok {
+		if ri, ok := r.(int); ok {
+			switch {
+			case li < ri:
+				return -1, nil
+			case li > ri:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if lb, ok := l.(bool); ok {
+		if rb, ok := r.(bool); ok {
+			switch {
+			case lb == rb:
+				return 0, nil
+			case rb:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	lf, lNum := toFloat(l)
+	rf, rNum := toFloat(r)
+	if lNum && rNum {
+		switch {
+		case lf < rf:
+			return -1, nil
+		case lf > rf:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, errorf(CodeTypeMismatch, "cannot compare %s with %s", typeName(l), typeName(r))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "NULL"
+	case int:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// handleExplain returns the operator tree planned for a query, one line per
+// row, with the number of rows each operator is expected to return. With
+// ANALYZE the query is run too, and each operator also reports the rows it
+// actually returned per loop, how many times it ran and how long it took in
+// total, its inputs included.
+func (db *Database) handleExplain(stmt *ExplainStmt, snap *snapshot) (*Result, error) {
+	plan, err := db.planSelect(stmt.Query)
+	if err != nil {
+		return nil, err
+	}
+	var elapsed time.Duration
+	if stmt.Analyze {
+		timeNodes(plan.root)
+		start := time.Now()
+		it, err := openNode(plan.root, snap)
+		if err != nil {
+			return nil, err
+		}
+		// Read the rows without keeping them
+		for {
+			row, err := it.next()
+			if err != nil {
+				it.close()
+				return nil, err
+			}
+			if row == nil {
+				break
+			}
+		}
+		it.close()
+		elapsed = time.Since(start)
+	}
+
+	var lines []string
+	explainNode(&lines, plan.root, 0, stmt.Analyze)
+	if stmt.Analyze {
+		lines = append(lines, "Execution Time: "+millis(elapsed))
+	}
+	rows := make([][]interface{}, len(lines))
+	for i, line := range lines {
+		rows[i] = []interface{}{line}
+	}
+	return &Result{Columns: []Column{{Name: "QUERY PLAN", Type: "string"}}, Rows: rows}, nil
+}
+
+// explainNode appends the lines describing n, indented by indent, then those
+// of its inputs.
+func explainNode(lines *[]string, n planNode, indent int, analyze bool) {
+	line, details := n.describe()
+	s := n.stats()
+	line += fmt.Sprintf("  (rows=%d)", s.estimate)
+	if analyze {
+		if s.loops == 0 {
+			line += " (never executed)"
+		} else {
+			line += fmt.Sprintf(" (actual rows=%d loops=%d time=%s)", s.rows/s.loops, s.loops, millis(s.elapsed))
+		}
+	}
+
+	arrow := ""
+	if indent > 0 {
+		arrow = "->  "
+	}
+	*lines = append(*lines, strings.Repeat(" ", indent)+arrow+line)
+	inner := indent + len(arrow) + 2
+	for _, d := range details {
+		*lines = append(*lines, strings.Repeat(" ", inner)+d)
+	}
+	for _, in := range n.inputs() {
+		explainNode(lines, in, inner, analyze)
+	}
+}
+
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d.Microseconds())/1000)
+}
@@ -0,0 +1,318 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// indexFile holds a table's B+trees next to its data file: the primary key
+// index, mapping each id to the offset of its record, and its secondary
+// indexes (see secondaryIndex), mapping column values to ids. Together they record
+// the rows committed as of the last checkpoint; changes made since are kept
+// in memory as row versions and folded into the trees by the next checkpoint.
+//
+// Page 0 is a header written in place:
+//
+//	magic [8]byte | version uint32 | clean byte | rows int64 |
+//	live bytes int64 | used bytes int64 | tree count uint16 |
+//	trees... (name length uint16 | name | root page uint32)
+//
+// The header is marked dirty before the first page of a clean index changes,
+// and clean again once every page has been written back, so an index left
+// half-updated by a crash is rebuilt from the data file when the table opens.
+type indexFile struct {
+	file      *pageFile
+	clean     bool // The header on disk says the index is complete
+	rows      int64
+	live      int64 // Bytes of the records the primary key index points to
+	used      int64 // Bytes of all records in the data file, live or dead
+	pk        *bTree
+	secondary map[string]*bTree
+	trees     []*bTree // All trees in header order, primary key first
+}
+
+const indexVersion = 3
+
+var indexMagic = [8]byte{'S', 'Q', 'L', 'l', 'y', 'I', 'D', 'X'}
+
+func indexFilePath(dbDir, tableName string) string {
+	return filepath.Join(dbDir, safeName(tableName)+".idx")
+}
+
+// openIndexFile opens the index at path. It reports ok == false, without an
+// error, if the file is missing, was not closed cleanly or does not hold
+// exactly the given secondary trees; the caller then rebuilds it.
+func openIndexFile(path string, trees []string, pool *BufferPool) (idx *indexFile, ok bool, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	idx = &indexFile{
+		file:      &pageFile{f: f, pool: pool, pages: uint32(info.Size() / pageSize)},
+		secondary: make(map[string]*bTree),
+	}
+
+	header := make([]byte, pageSize)
+	if _, err := f.ReadAt(header, 0); err != nil && !errors.Is(err, io.EOF) {
+		f.Close()
+		return nil, false, err
+	}
+	if !idx.decodeHeader(header) || !idx.clean || len(idx.trees) != len(trees)+1 || idx.pk.name != "id" {
+		return idx, false, nil
+	}
+	for i, name := range trees {
+		if idx.trees[i+1].name != name {
+			return idx, false, nil
+		}
+	}
+	return idx, true, nil
+}
+
+func (idx *indexFile) decodeHeader(page []byte) bool {
+	r := bytes.NewReader(page)
+	var magic [8]byte
+	var version uint32
+	var clean byte
+	var count uint16
+	binary.Read(r, binary.LittleEndian, &magic)
+	binary.Read(r, binary.LittleEndian, &version)
+	binary.Read(r, binary.LittleEndian, &clean)
+	binary.Read(r, binary.LittleEndian, &idx.rows)
+	binary.Read(r, binary.LittleEndian, &idx.live)
+	binary.Read(r, binary.LittleEndian, &idx.used)
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil || magic != indexMagic || version != indexVersion {
+		return false
+	}
+	idx.clean = clean == 1
+	for i := 0; i < int(count); i++ {
+		var nameLen uint16
+		if err := binary.Read(r, binary.LittleEndian, &nameLen); err != nil {
+			return false
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return false
+		}
+		var root uint32
+		if err := binary.Read(r, binary.LittleEndian, &root); err != nil || root == 0 || root >= idx.file.pages {
+			return false
+		}
+		idx.addTree(string(name), root)
+	}
+	return len(idx.trees) > 0
+}
+
+func (idx *indexFile) addTree(name string, root uint32) {
+	t := &bTree{idx: idx, name: name, root: root}
+	if len(idx.trees) == 0 {
+		idx.pk = t
+	} else {
+		idx.secondary[name] = t
+	}
+	idx.trees = append(idx.trees, t)
+}
+
+func (idx *indexFile) writeHeader() error {
+	var buf bytes.Buffer
+	buf.Write(indexMagic[:])
+	binary.Write(&buf, binary.LittleEndian, uint32(indexVersion))
+	clean := byte(0)
+	if idx.clean {
+		clean = 1
+	}
+	buf.WriteByte(clean)
+	binary.Write(&buf, binary.LittleEndian, idx.rows)
+	binary.Write(&buf, binary.LittleEndian, idx.live)
+	binary.Write(&buf, binary.LittleEndian, idx.used)
+	binary.Write(&buf, binary.LittleEndian, uint16(len(idx.trees)))
+	for _, t := range idx.trees {
+		binary.Write(&buf, binary.LittleEndian, uint16(len(t.name)))
+		buf.WriteString(t.name)
+		binary.Write(&buf, binary.LittleEndian, t.root)
+	}
+	if buf.Len() > pageSize {
+		return fmt.Errorf("index header too large")
+	}
+	page := make([]byte, pageSize)
+	copy(page, buf.Bytes())
+	_, err := idx.file.f.WriteAt(page, 0)
+	return err
+}
+
+// reset empties the index, leaving an empty tree for the primary key and
+// each of the named secondary trees.
+func (idx *indexFile) reset(trees []string) error {
+	idx.file.pool.drop(idx.file)
+	if err := idx.file.f.Truncate(0); err != nil {
+		return err
+	}
+	idx.file.pages = 1
+	idx.clean = true // So markDirty records the change
+	idx.rows, idx.live, idx.used = 0, 0, 0
+	idx.trees, idx.pk, idx.secondary = nil, nil, make(map[string]*bTree)
+	for _, name := range append([]string{"id"}, trees...) {
+		idx.addTree(name, idx.allocPage())
+	}
+	for _, t := range idx.trees {
+		if err := t.write(t.root, &node{leaf: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *indexFile) allocPage() uint32 {
+	idx.file.pages++
+	return idx.file.pages - 1
+}
+
+// markDirty records on disk that the index is being changed, before any of
+// its pages can be written back.
+func (idx *indexFile) markDirty() error {
+	if !idx.clean {
+		return nil
+	}
+	idx.clean = false
+	if err := idx.writeHeader(); err != nil {
+		return err
+	}
+	return idx.file.f.Sync()
+}
+
+// sync writes every changed page back and marks the index clean.
+func (idx *indexFile) sync() error {
+	if idx.clean {
+		// Only the statistics may have moved
+		return idx.writeHeader()
+	}
+	if err := idx.file.sync(); err != nil {
+		return err
+	}
+	idx.clean = true
+	if err := idx.writeHeader(); err != nil {
+		return err
+	}
+	return idx.file.f.Sync()
+}
+
+func (idx *indexFile) close() error {
+	err := idx.sync()
+	idx.file.pool.drop(idx.file)
+	if closeErr := idx.file.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (idx *indexFile) discard() {
+	idx.file.discard()
+}
+
+// secondaryIndex is a B+tree of the index file other than the primary key:
+// one per UNIQUE column and one per CREATE INDEX. Its keys are the encoded
+// values of its columns, followed by the row id unless the index is unique,
+// and its values are row ids. NULL never equals anything, so a unique index
+// also ends the key of a row with a NULL column with the row id.
+type secondaryIndex struct {
+	tree    string // Name of the tree in the index file
+	name    string // Name given by CREATE INDEX; empty for a UNIQUE column
+	columns []ColumnDef
+	unique  bool
+}
+
+// key returns the key of row in the index.
+func (ix *secondaryIndex) key(row *Row) string {
+	var key []byte
+	hasNull := false
+	for _, col := range ix.columns {
+		v := row.Data[col.Name]
+		key = appendKey(key, col, v)
+		hasNull = hasNull || v == nil
+	}
+	if !ix.unique || hasNull {
+		key = append(key, pkKey(row.Id)...)
+	}
+	return string(key)
+}
+
+// label names the index in EXPLAIN output.
+func (ix *secondaryIndex) label() string {
+	if ix.name == "" {
+		return "UNIQUE (" + ix.columns[0].Name + ")"
+	}
+	return ix.name
+}
+
+// duplicate returns the error for a row whose values the unique index
+// already holds.
+func (ix *secondaryIndex) duplicate(row *Row) error {
+	vals := make([]string, len(ix.columns))
+	for i, col := range ix.columns {
+		vals[i] = fmt.Sprintf("%v", row.Data[col.Name])
+	}
+	if ix.name == "" {
+		return errorf(CodeUniqueViolation, "violation of UNIQUE constraint on column '%s'. Value '%s' already exists", ix.columns[0].Name, vals[0])
+	}
+	return errorf(CodeUniqueViolation, "violation of UNIQUE index '%s'. Value '%s' already exists", ix.name, strings.Join(vals, ", "))
+}
+
+// The encoding of each value in a key starts with one of these, so that
+// NULLs sort before every other value of the column.
+const (
+	keyNull    = 0
+	keyNotNull = 1
+)
+
+// appendKey appends the encoding of v, a value of col or nil for NULL, to
+// key. Encoded values compare byte by byte in the same order as the values
+// themselves, and none is a prefix of another, so the values of several
+// columns can follow each other. A string is written with its zero bytes
+// escaped and ends in 0x00 0x01.
+func appendKey(key []byte, col ColumnDef, v interface{}) []byte {
+	if v == nil {
+		return append(key, keyNull)
+	}
+	key = append(key, keyNotNull)
+	if col.Type == IntType {
+		n, _ := v.(int)
+		return append(key, pkKey(n)...)
+	}
+	s, _ := v.(string)
+	for i := 0; i < len(s); i++ {
+		key = append(key, s[i])
+		if s[i] == 0 {
+			key = append(key, 0xff)
+		}
+	}
+	return append(key, 0, 1)
+}
+
+// leadingKeyLen returns the length of the encoding of col's value at the
+// start of key.
+func leadingKeyLen(col ColumnDef, key string) int {
+	if key[0] == keyNull {
+		return 1
+	}
+	if col.Type == IntType {
+		return 5
+	}
+	i := 1
+	for key[i] != 0 || key[i+1] != 1 {
+		if key[i] == 0 {
+			i++ // Skip the escape
+		}
+		i++
+	}
+	return i + 2
+}
@@ -0,0 +1,408 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// handleInsert inserts the rows of a VALUES list or of a query, one at a
+// time. A row that fails fails the statement, whose other rows are then
+// undone with it.
+func (db *Database) handleInsert(stmt *InsertStmt, tx *transaction) (*Result, error) {
+	db.mu.RLock()
+	table, ok := db.Tables[stmt.Table]
+	db.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, stmt.Table)
+	}
+
+	// The columns the values are for, in order
+	cols := table.Schema.Columns
+	if stmt.Columns != nil {
+		cols = make([]ColumnDef, len(stmt.Columns))
+		seen := make(map[string]bool)
+		for i, name := range stmt.Columns {
+			col, ok := table.column(name)
+			if !ok {
+				return nil, errorf(CodeUndefinedColumn, "column '%s' not found", name)
+			}
+			if seen[name] {
+				return nil, errorf(CodeDuplicateColumn, "column '%s' specified more than once", name)
+			}
+			seen[name], cols[i] = true, col
+		}
+	}
+	countErr := func(n int) error {
+		if stmt.Columns == nil {
+			return errorf(CodeSyntax, "table '%s' has %d columns but %d values were supplied", stmt.Table, len(cols), n)
+		}
+		return errorf(CodeSyntax, "%d columns were named but %d values were supplied", len(cols), n)
+	}
+
+	ins, err := db.newInserter(table, cols, tx)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.OnConflict != nil {
+		if err := ins.onConflict(stmt.OnConflict, stmt.Table); err != nil {
+			return nil, err
+		}
+	}
+	if ins.returning, err = compileReturning(stmt.Returning, table, stmt.Table); err != nil {
+		return nil, err
+	}
+	// Say which row failed when there are several
+	rowErr := func(i, rows int, err error) error {
+		if rows > 1 {
+			return fmt.Errorf("row %d: %w", i+1, err)
+		}
+		return err
+	}
+	if stmt.Query != nil {
+		rows, err := db.queryRows(stmt.Query, tx.snap, len(cols))
+		if err != nil {
+			return nil, err
+		}
+		for i, values := range rows {
+			if err := ins.insert(values); err != nil {
+				return nil, rowErr(i, len(rows), err)
+			}
+		}
+	} else {
+		for i, exprs := range stmt.Rows {
+			values, err := ins.evalValues(exprs, countErr)
+			if err == nil {
+				err = ins.insert(values)
+			}
+			if err != nil {
+				return nil, rowErr(i, len(stmt.Rows), err)
+			}
+		}
+	}
+
+	var res *Result
+	switch c := stmt.OnConflict; {
+	case c != nil:
+		msg := fmt.Sprintf("%d row(s) inserted, %d skipped.", ins.count, ins.skipped)
+		if c.Set != nil {
+			msg = fmt.Sprintf("%d row(s) inserted, %d updated.", ins.count, ins.updated)
+			if ins.skipped > 0 {
+				msg = fmt.Sprintf("%d row(s) inserted, %d updated, %d skipped.", ins.count, ins.updated, ins.skipped)
+			}
+		}
+		res = &Result{Message: msg, RowsAffected: ins.count + ins.updated}
+	case stmt.Query != nil || len(stmt.Rows) > 1:
+		res = &Result{Message: fmt.Sprintf("%d row(s) inserted.", ins.count), RowsAffected: ins.count}
+	case ins.generated:
+		res = &Result{Message: fmt.Sprintf("Row inserted successfully with id %d.", ins.lastId), RowsAffected: 1}
+	default:
+		res = &Result{Message: "Row inserted successfully.", RowsAffected: 1}
+	}
+	res.LastInsertId = ins.lastId
+	return ins.returning.result(res), nil
+}
+
+// queryRows runs the query of an INSERT ... SELECT, which must return n
+// columns, and reads all its rows before any is inserted, so that it does not
+// see the rows the statement inserts.
+func (db *Database) queryRows(query *SelectStmt, snap *snapshot, n int) ([][]interface{}, error) {
+	plan, err := db.planSelect(query)
+	if err != nil {
+		return nil, err
+	}
+	if got := len(plan.project.columns); got != n {
+		return nil, errorf(CodeSyntax, "the query returns %d columns but %d are inserted", got, n)
+	}
+	it, err := openNode(plan.root, snap)
+	if err != nil {
+		return nil, err
+	}
+	defer it.close()
+	var rows [][]interface{}
+	for {
+		row, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, append([]interface{}(nil), row...))
+	}
+}
+
+// inserter builds and inserts the rows of one INSERT statement, filling the
+// columns it is not given values for with their defaults. With ON CONFLICT,
+// a row holding a key that a row already visible holds is skipped or turned
+// into an update of that row instead.
+type inserter struct {
+	table     *Table
+	cols      []ColumnDef // Columns values are given for, in order
+	tx        *transaction
+	defaults  map[string]func() (interface{}, error)
+	count     int
+	lastId    int  // Id of the last row inserted or updated
+	generated bool // The last row's id was generated
+
+	conflict *conflictAction // Nil without ON CONFLICT
+	updated  int
+	skipped  int
+
+	returning *returning // Nil without RETURNING
+}
+
+// conflictAction is a compiled ON CONFLICT clause. The arbiters are the
+// primary key, if pk, and the unique index trees checked for conflicts. Set
+// and where are evaluated against the existing row followed by the proposed
+// one; set is empty for DO NOTHING.
+type conflictAction struct {
+	pk      bool
+	trees   []string
+	cols    []ColumnDef
+	set     []evalFunc
+	where   func(row []interface{}) (bool, error)
+	touched map[int]bool // Rows the statement inserted or updated
+}
+
+func (db *Database) newInserter(t *Table, cols []ColumnDef, tx *transaction) (*inserter, error) {
+	ins := &inserter{table: t, cols: cols, tx: tx, defaults: make(map[string]func() (interface{}, error))}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, col := range t.Schema.Columns {
+		if col.Default == "" {
+			continue
+		}
+		fill, _, err := db.columnDefault(col)
+		if err != nil {
+			return nil, err
+		}
+		ins.defaults[col.Name] = fill
+	}
+	return ins, nil
+}
+
+// onConflict compiles the ON CONFLICT clause of an insert into table.
+func (ins *inserter) onConflict(c *OnConflict, table string) error {
+	t := ins.table
+	a := &conflictAction{touched: make(map[int]bool)}
+	if c.Columns == nil {
+		a.pk, a.trees = true, t.uniqueTrees()
+	} else {
+		for _, name := range c.Columns {
+			if _, ok := t.column(name); !ok {
+				return errorf(CodeUndefinedColumn, "column '%s' not found", name)
+			}
+		}
+		pk, tree, ok := t.arbiter(c.Columns)
+		if !ok {
+			return errorf(CodeInvalidColumnRef, "there is no primary key or unique constraint on (%s) matching the ON CONFLICT columns", strings.Join(c.Columns, ", "))
+		}
+		a.pk = pk
+		if tree != "" {
+			a.trees = []string{tree}
+		}
+	}
+
+	// Unqualified names refer to the existing row
+	sc := tableScope(t, table)
+	sc.columns = append(sc.columns, tableScope(t, "excluded").columns...)
+	for _, set := range c.Set {
+		col, ok := t.column(set.Column)
+		if !ok {
+			return errorf(CodeUndefinedColumn, "column '%s' not found", set.Column)
+		}
+		if col.Name == "id" {
+			return errorf(CodeNotSupported, "updating the 'id' column is not supported")
+		}
+		eval, err := compileExpr(qualifyTarget(set.Value, table), sc)
+		if err != nil {
+			return err
+		}
+		a.cols, a.set = append(a.cols, col), append(a.set, eval)
+	}
+	if c.Where != nil {
+		var err error
+		if a.where, err = compilePredicate(qualifyTarget(c.Where, table), sc); err != nil {
+			return err
+		}
+	}
+	ins.conflict = a
+	return nil
+}
+
+// qualifyTarget qualifies the unqualified column references in e with the
+// name of the table inserted into.
+func qualifyTarget(e Expr, table string) Expr {
+	switch e := e.(type) {
+	case *ColumnRef:
+		if e.Table == "" {
+			c := *e
+			c.Table = table
+			return &c
+		}
+	case *BinaryExpr:
+		c := *e
+		c.Left, c.Right = qualifyTarget(e.Left, table), qualifyTarget(e.Right, table)
+		return &c
+	case *UnaryExpr:
+		c := *e
+		c.Operand = qualifyTarget(e.Operand, table)
+		return &c
+	case *FuncCall:
+		c := *e
+		c.Args = make([]Expr, len(e.Args))
+		for i, a := range e.Args {
+			c.Args[i] = qualifyTarget(a, table)
+		}
+		return &c
+	}
+	return e
+}
+
+// resolveConflict skips row, or updates the existing row id it conflicts
+// with as the ON CONFLICT clause says.
+func (ins *inserter) resolveConflict(id int, row *Row) error {
+	a, t := ins.conflict, ins.table
+	if a.set == nil {
+		ins.skipped++
+		return nil
+	}
+	if a.touched[id] {
+		return errorf(CodeCardinality, "ON CONFLICT DO UPDATE cannot change row %d a second time in the same statement", id)
+	}
+	existing, err := t.selectById(ins.tx.snap, id)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return errSerialization
+	}
+	values := append(rowValues(t, existing), rowValues(t, row)...)
+	if a.where != nil {
+		ok, err := a.where(values)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			ins.skipped++
+			return nil
+		}
+	}
+
+	updated := NewRow()
+	updated.Id = id
+	for name, v := range existing.Data {
+		updated.Data[name] = v
+	}
+	for i, col := range a.cols {
+		v, err := a.set[i](values)
+		if err != nil {
+			return err
+		}
+		if updated.Data[col.Name], err = coerceValue(col, v); err != nil {
+			return err
+		}
+	}
+	if err := ins.tx.update(t, updated); err != nil {
+		return err
+	}
+	if err := ins.returning.add(updated); err != nil {
+		return err
+	}
+	a.touched[id] = true
+	ins.updated++
+	ins.lastId = id
+	return nil
+}
+
+// defaultValue returns the value col gets when none is given. It is NULL for
+// an AUTOINCREMENT id, which insert then generates.
+func (ins *inserter) defaultValue(col ColumnDef) (interface{}, error) {
+	if fill := ins.defaults[col.Name]; fill != nil {
+		return fill()
+	}
+	return nil, nil
+}
+
+// evalValues evaluates one row of a VALUES list; DEFAULT gives the column's
+// default.
+func (ins *inserter) evalValues(exprs []Expr, countErr func(int) error) ([]interface{}, error) {
+	if len(exprs) != len(ins.cols) {
+		return nil, countErr(len(exprs))
+	}
+	values := make([]interface{}, len(exprs))
+	for i, e := range exprs {
+		var err error
+		if e == nil {
+			values[i], err = ins.defaultValue(ins.cols[i])
+		} else {
+			values[i], err = evalConst(e)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// insert inserts the row holding values in ins.cols and defaults elsewhere.
+func (ins *inserter) insert(values []interface{}) error {
+	t := ins.table
+	row := NewRow()
+	for i, col := range ins.cols {
+		v, err := coerceValue(col, values[i])
+		if err != nil {
+			return err
+		}
+		row.Data[col.Name] = v
+	}
+	for _, col := range t.Schema.Columns {
+		if _, given := row.Data[col.Name]; given {
+			continue
+		}
+		v, err := ins.defaultValue(col)
+		if err != nil {
+			return err
+		}
+		row.Data[col.Name] = v
+	}
+
+	// An AUTOINCREMENT id left out or given as NULL is generated
+	ins.generated = false
+	if t.autoId != nil && row.Data["id"] == nil {
+		id, err := t.autoId.nextValue()
+		if err != nil {
+			return err
+		}
+		row.Data["id"], ins.generated = id, true
+	}
+	row.Id, _ = row.Data["id"].(int)
+
+	if a := ins.conflict; a != nil {
+		id, found, err := t.conflict(ins.tx.snap, row, a.pk, a.trees)
+		if err != nil {
+			return err
+		}
+		if found {
+			return ins.resolveConflict(id, row)
+		}
+	}
+	if err := ins.tx.insert(t, row); err != nil {
+		return err
+	}
+	if err := ins.returning.add(row); err != nil {
+		return err
+	}
+	if ins.conflict != nil {
+		ins.conflict.touched[row.Id] = true
+	}
+	if t.autoId != nil && !ins.generated {
+		if err := t.autoId.skipPast(row.Id); err != nil {
+			return err
+		}
+	}
+	ins.count++
+	ins.lastId = row.Id
+	return nil
+}
@@ -0,0 +1,80 @@
+package engine
+
+import "testing"
+
+func TestInsertColumnsAndRows(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	mustExec(t, s, "CREATE TABLE t (id INT PRIMARY KEY, a TEXT, b INT NOT NULL DEFAULT 0)")
+
+	res := mustExec(t, s, "INSERT INTO t (b, id) VALUES (5, 1), (6, 2); INSERT INTO t VALUES (3, 'c', 7)")
+	if res[0].RowsAffected != 2 {
+		t.Errorf("rowsAffected: got %d, want 2", res[0].RowsAffected)
+	}
+	mustExec(t, s, "INSERT INTO t (id) VALUES (4)")
+	want := [][]interface{}{{1, nil, 5}, {2, nil, 6}, {3, "c", 7}, {4, nil, 0}}
+	checkRows(t, s, "SELECT id, a, b FROM t ORDER BY id", want)
+
+	// A rejected row rejects the whole statement
+	execFails(t, s, "INSERT INTO t (id, a) VALUES (5, 'e'), (1, 'dup')", CodeUniqueViolation)
+	execFails(t, s, "INSERT INTO t (id, b) VALUES (5, 1), (6, NULL)", CodeNotNullViolation)
+	checkRows(t, s, "SELECT id, a, b FROM t ORDER BY id", want)
+
+	execFails(t, s, "INSERT INTO t (id, nope) VALUES (5, 1)", CodeUndefinedColumn)
+	execFails(t, s, "INSERT INTO t (id, a, a) VALUES (5, 'x', 'y')", CodeDuplicateColumn)
+	execFails(t, s, "INSERT INTO t (id, a) VALUES (5)", CodeSyntax)
+	execFails(t, s, "INSERT INTO t VALUES (5, 'x')", CodeSyntax)
+}
+
+func TestInsertSelect(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	mustExec(t, s, `CREATE TABLE t (id INT PRIMARY KEY, a TEXT);
+		CREATE TABLE u (id INT PRIMARY KEY, a TEXT, n INT);
+		INSERT INTO t VALUES (1, 'x'), (2, 'y')`)
+
+	mustExec(t, s, "INSERT INTO u (a, id) SELECT a, id * 10 FROM t WHERE id > 1")
+	checkRows(t, s, "SELECT id, a, n FROM u", [][]interface{}{{20, "y", nil}})
+	// The query is read in full before the first row goes in
+	res := mustExec(t, s, "INSERT INTO t SELECT id + 2, a FROM t")
+	if res[0].RowsAffected != 2 {
+		t.Errorf("rowsAffected: got %d, want 2", res[0].RowsAffected)
+	}
+	checkRows(t, s, "SELECT id FROM t ORDER BY id", [][]interface{}{{1}, {2}, {3}, {4}})
+	execFails(t, s, "INSERT INTO u SELECT id, a FROM t", CodeSyntax)
+	execFails(t, s, "INSERT INTO t SELECT id, a FROM t", CodeUniqueViolation)
+	checkRows(t, s, "SELECT COUNT(*) FROM t", [][]interface{}{{4}})
+}
+
+func TestInsertOnConflict(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	mustExec(t, s, `CREATE TABLE t (id INT PRIMARY KEY, email TEXT UNIQUE, n INT);
+		INSERT INTO t VALUES (1, 'a@x', 1), (2, 'b@x', 1)`)
+
+	res := mustExec(t, s, "INSERT INTO t VALUES (1, 'c@x', 5) ON CONFLICT DO NOTHING")
+	if res[0].RowsAffected != 0 {
+		t.Errorf("rowsAffected: got %d, want 0", res[0].RowsAffected)
+	}
+	mustExec(t, s, "INSERT INTO t VALUES (3, 'a@x', 5), (4, 'd@x', 1) ON CONFLICT (email) DO NOTHING")
+	// Only the named constraint is handled
+	execFails(t, s, "INSERT INTO t VALUES (5, 'a@x', 5) ON CONFLICT (id) DO NOTHING", CodeUniqueViolation)
+	execFails(t, s, "INSERT INTO t VALUES (5, 'e@x', 1) ON CONFLICT (n) DO NOTHING", CodeInvalidColumnRef)
+
+	res = mustExec(t, s, "INSERT INTO t VALUES (1, 'z@x', 5) ON CONFLICT (id) DO UPDATE SET n = t.n + excluded.n")
+	if res[0].RowsAffected != 1 {
+		t.Errorf("rowsAffected: got %d, want 1", res[0].RowsAffected)
+	}
+	mustExec(t, s, "INSERT INTO t VALUES (2, 'q@x', 5) ON CONFLICT (id) DO UPDATE SET n = 100 WHERE t.n > 10")
+	checkRows(t, s, "SELECT id, email, n FROM t ORDER BY id",
+		[][]interface{}{{1, "a@x", 6}, {2, "b@x", 1}, {4, "d@x", 1}})
+
+	// A statement may not update the row it inserted or updated
+	execFails(t, s, "INSERT INTO t VALUES (6, 'f@x', 1), (6, 'f@x', 2) ON CONFLICT (id) DO UPDATE SET n = excluded.n",
+		CodeCardinality)
+	mustExec(t, s, "INSERT INTO t VALUES (6, 'f@x', 1), (6, 'f@x', 2) ON CONFLICT (id) DO NOTHING")
+	checkRows(t, s, "SELECT n FROM t WHERE id = 6", [][]interface{}{{1}})
+}
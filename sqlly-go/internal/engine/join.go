@@ -0,0 +1,730 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// joinInput is one table of the FROM clause, already resolved.
+type joinInput struct {
+	ref   TableRef
+	table *Table
+}
+
+// indexJoinFactor is how many times smaller the left input must be than the
+// right table before probing an index beats scanning the table.
+const indexJoinFactor = 4
+
+// joinNode joins the rows of its left input with those of a table scan.
+// Equality conditions between the two sides are executed as lookups in the
+// primary key index or a secondary index of the table, when the left input
+// is expected to be much smaller than the table, or as a hash join built on
+// the side expected to be smaller; anything else falls back to a nested loop.
+type joinNode struct {
+	nodeStats
+	kind      JoinKind
+	left      planNode
+	right     *scanNode
+	on        Expr // nil when every pair of rows matches
+	sc        *scope
+	keys      []equiKey
+	residual  func(row []interface{}) (bool, error)
+	probe     *equiKey // Key looked up in the right table's index; nil unless an index join
+	buildLeft bool     // A hash join builds its table on the left rows rather than the right
+	layout    []int    // Positions in the joined rows of the columns returned; nil for all in order
+}
+
+// newJoin plans joining the rows of left, laid out by leftScope, with the
+// rows of right as on requires.
+func newJoin(kind JoinKind, left planNode, leftScope *scope, right *scanNode, on Expr) (*joinNode, error) {
+	n := &joinNode{
+		kind:  kind,
+		left:  left,
+		right: right,
+		on:    on,
+		sc:    &scope{columns: append(append([]scopeColumn{}, leftScope.columns...), right.sc.columns...), joined: true},
+	}
+	keys, rest, err := splitEquiJoin(on, leftScope, right.sc)
+	if err != nil {
+		return nil, fmt.Errorf("%s ON: %w", kind, err)
+	}
+	if n.residual, err = compilePredicate(rest, n.sc); err != nil {
+		return nil, fmt.Errorf("%s ON: %w", kind, err)
+	}
+	n.keys = keys
+
+	// A left row matches at most one row through a unique key, about as
+	// many as the larger side has through another equality, and a third of
+	// the pairs through other conditions
+	l, r := left.stats().estimate, right.estimate
+	unique := false
+	for _, k := range keys {
+		unique = unique || k.rightPK || right.table.uniqueColumn(k.rightColumn)
+	}
+	switch {
+	case unique:
+		n.estimate = l
+	case len(keys) > 0:
+		n.estimate = max(l, r)
+	case on != nil:
+		n.estimate = l * r / 3
+	default:
+		n.estimate = l * r
+	}
+	switch kind {
+	case LeftJoin:
+		n.estimate = max(n.estimate, l)
+	case RightJoin:
+		n.estimate = max(n.estimate, r)
+	case FullJoin:
+		n.estimate = max(n.estimate, l, r)
+	}
+
+	if len(keys) > 0 && (kind == InnerJoin || kind == LeftJoin) && l*indexJoinFactor < right.table.Count() {
+		// Prefer the primary key to a secondary index
+		for i, k := range n.keys {
+			if k.rightPK {
+				n.probe = &n.keys[i]
+				break
+			}
+			if n.probe == nil && right.table.indexOn(k.rightColumn) != nil {
+				n.probe = &n.keys[i]
+			}
+		}
+		if n.probe != nil {
+			right.lookupBy(n.probe, n.estimate/max(l, 1))
+		}
+	}
+	n.buildLeft = n.probe == nil && len(keys) > 0 && l < r
+	return n, nil
+}
+
+// restoreOrder makes the join return the columns of scans, joined in the
+// given order, in the order of scans instead, and returns the scope laying
+// them out.
+func (n *joinNode) restoreOrder(scans []*scanNode, order []int) *scope {
+	start := make([]int, len(scans))
+	at := 0
+	for _, i := range order {
+		start[i] = at
+		at += len(scans[i].sc.columns)
+	}
+	n.sc = &scope{joined: true}
+	n.layout = nil
+	for i, s := range scans {
+		for k, col := range s.sc.columns {
+			n.sc.columns = append(n.sc.columns, col)
+			n.layout = append(n.layout, start[i]+k)
+		}
+	}
+	return n.sc
+}
+
+func (n *joinNode) describe() (string, []string) {
+	kind := ""
+	switch n.kind {
+	case LeftJoin:
+		kind = "Left "
+	case RightJoin:
+		kind = "Right "
+	case FullJoin:
+		kind = "Full "
+	}
+	var line string
+	switch {
+	case n.probe != nil:
+		line = "Index " + kind + "Join"
+	case len(n.keys) > 0:
+		line = "Hash " + kind + "Join"
+	case kind != "":
+		line = "Nested Loop " + kind + "Join"
+	default:
+		line = "Nested Loop"
+	}
+	var details []string
+	if n.on != nil {
+		details = append(details, "Join Cond: "+exprString(n.on))
+	}
+	if n.probe == nil && len(n.keys) > 0 {
+		side := "right"
+		if n.buildLeft {
+			side = "left"
+		}
+		details = append(details, "Hash Build: "+side)
+	}
+	return line, details
+}
+
+func (n *joinNode) inputs() []planNode { return []planNode{n.left, n.right} }
+
+// open joins the left rows as they are pulled, each against every right
+// row for a hash join or nested loop, which reads the right rows first, and
+// a batch at a time for an index join. A hash join built on the left rows
+// reads them first instead and joins the right rows as they are pulled.
+func (n *joinNode) open(snap *snapshot) (rowIter, error) {
+	left, err := openNode(n.left, snap)
+	if err != nil {
+		return nil, err
+	}
+	rightWidth := len(n.right.sc.columns)
+	j := &joiner{
+		kind:       n.kind,
+		leftWidth:  len(n.sc.columns) - rightWidth,
+		rightWidth: rightWidth,
+		residual:   n.residual,
+	}
+
+	// The input pulled a row at a time, closed with the join
+	input := left
+	var fill func() error
+	switch {
+	case n.probe != nil:
+		fill = j.indexJoin(snap, left, n.right, n.keys)
+	case n.buildLeft:
+		rows, err := drain(left)
+		left.close()
+		if err != nil {
+			return nil, err
+		}
+		join, err := j.hashJoinLeft(rows, n.keys)
+		if err != nil {
+			return nil, err
+		}
+		if input, err = openNode(n.right, snap); err != nil {
+			return nil, err
+		}
+		fill = func() error {
+			r, err := input.next()
+			if err != nil {
+				return err
+			}
+			if r == nil {
+				j.padLeft(rows)
+				j.done = true
+				return nil
+			}
+			return join(r)
+		}
+	default:
+		right, err := openNode(n.right, snap)
+		if err != nil {
+			left.close()
+			return nil, err
+		}
+		rows, err := drain(right)
+		right.close()
+		var join func(l []interface{}) error
+		if err == nil {
+			if len(n.keys) == 0 {
+				join = j.nestedLoop(rows)
+			} else {
+				join, err = j.hashJoin(rows, n.keys)
+			}
+		}
+		if err != nil {
+			left.close()
+			return nil, err
+		}
+		fill = func() error {
+			l, err := left.next()
+			if err != nil {
+				return err
+			}
+			if l == nil {
+				j.padRight(rows)
+				j.done = true
+				return nil
+			}
+			return join(l)
+		}
+	}
+
+	next := func() ([]interface{}, error) {
+		for len(j.out) == 0 && !j.done {
+			if err := fill(); err != nil {
+				return nil, err
+			}
+		}
+		if len(j.out) == 0 {
+			return nil, nil
+		}
+		row := j.out[0]
+		j.out = j.out[1:]
+		if n.layout != nil {
+			out := make([]interface{}, len(n.layout))
+			for k, at := range n.layout {
+				out[k] = row[at]
+			}
+			row = out
+		}
+		return row, nil
+	}
+	return &funcIter{nextRow: next, done: input.close}, nil
+}
+
+// equiKey is one "left expr = right expr" conjunct of a join condition.
+type equiKey struct {
+	cond        Expr     // The conjunct itself
+	left        evalFunc // Evaluated on left rows
+	right       evalFunc // Evaluated on right rows
+	rightColumn string   // Right side is this column of the right table; empty for other expressions
+	rightPK     bool     // Right side is the right table's primary key column
+}
+
+// splitEquiJoin separates the conjuncts of on that equate an expression over
+// the left input with one over the right table from the remaining conditions.
+func splitEquiJoin(on Expr, leftScope, rightScope *scope) ([]equiKey, Expr, error) {
+	var keys []equiKey
+	var rest Expr
+	for _, c := range conjuncts(on) {
+		if cmp, ok := c.(*BinaryExpr); ok && cmp.Op == "=" {
+			for _, sides := range [][2]Expr{{cmp.Left, cmp.Right}, {cmp.Right, cmp.Left}} {
+				l, r := sides[0], sides[1]
+				if !refersOnlyTo(l, leftScope) || !refersOnlyTo(r, rightScope) {
+					continue
+				}
+				lEval, err := compileExpr(l, leftScope)
+				if err != nil {
+					return nil, nil, err
+				}
+				rEval, err := compileExpr(r, rightScope)
+				if err != nil {
+					return nil, nil, err
+				}
+				key := equiKey{cond: c, left: lEval, right: rEval}
+				if ref, ok := r.(*ColumnRef); ok {
+					idx, _ := rightScope.resolve(ref)
+					key.rightColumn = rightScope.columns[idx].Name
+					key.rightPK = key.rightColumn == "id"
+				}
+				keys = append(keys, key)
+				c = nil
+				break
+			}
+		}
+		if c != nil {
+			if rest == nil {
+				rest = c
+			} else {
+				rest = &BinaryExpr{Op: "AND", Left: rest, Right: c}
+			}
+		}
+	}
+	return keys, rest, nil
+}
+
+// conjuncts flattens a tree of ANDs.
+func conjuncts(e Expr) []Expr {
+	if b, ok := e.(*BinaryExpr); ok && b.Op == "AND" {
+		return append(conjuncts(b.Left), conjuncts(b.Right)...)
+	}
+	if e == nil {
+		return nil
+	}
+	return []Expr{e}
+}
+
+// refersOnlyTo reports whether e references at least one column and every
+// column it references resolves in sc.
+func refersOnlyTo(e Expr, sc *scope) bool {
+	found := false
+	ok := true
+	var walk func(Expr)
+	walk = func(e Expr) {
+		switch e := e.(type) {
+		case *ColumnRef:
+			found = true
+			if _, err := sc.resolve(e); err != nil {
+				ok = false
+			}
+		case *BinaryExpr:
+			walk(e.Left)
+			walk(e.Right)
+		case *UnaryExpr:
+			walk(e.Operand)
+		case *FuncCall:
+			ok = false
+		}
+	}
+	walk(e)
+	return found && ok
+}
+
+// joiner holds what the join strategies share: the join kind, the widths
+// used for NULL padding and the residual (non-equality) ON condition. Joined
+// rows wait in out until they are returned.
+type joiner struct {
+	kind         JoinKind
+	leftWidth    int
+	rightWidth   int
+	residual     func(row []interface{}) (bool, error)
+	out          [][]interface{}
+	done         bool   // The rows pulled a row at a time have run out
+	rightMatched []bool // Right rows joined so far, when the join keeps the others
+	leftMatched  []bool // Likewise for left rows, when they were read first
+	seq          []int
+}
+
+func (j *joiner) emit(l, r []interface{}) {
+	if l == nil {
+		l = make([]interface{}, j.leftWidth)
+	}
+	if r == nil {
+		r = make([]interface{}, j.rightWidth)
+	}
+	j.out = append(j.out, concatRow(l, r))
+}
+
+func (j *joiner) keepsLeft() bool  { return j.kind == LeftJoin || j.kind == FullJoin }
+func (j *joiner) keepsRight() bool { return j.kind == RightJoin || j.kind == FullJoin }
+
+// joinLeft joins l with the rows of right at candidates that satisfy the
+// residual condition, or pads it with NULLs if there are none and the join
+// keeps unmatched left rows.
+func (j *joiner) joinLeft(l []interface{}, right [][]interface{}, candidates []int) error {
+	matched := false
+	for _, k := range candidates {
+		row := concatRow(l, right[k])
+		ok, err := j.residual(row)
+		if err != nil {
+			return err
+		}
+		if ok {
+			j.out = append(j.out, row)
+			matched = true
+			if j.rightMatched != nil {
+				j.rightMatched[k] = true
+			}
+		}
+	}
+	if !matched && j.keepsLeft() {
+		j.emit(l, nil)
+	}
+	return nil
+}
+
+// joinRight joins r with the rows of left at candidates that satisfy the
+// residual condition, or pads it with NULLs if there are none and the join
+// keeps unmatched right rows.
+func (j *joiner) joinRight(r []interface{}, left [][]interface{}, candidates []int) error {
+	matched := false
+	for _, k := range candidates {
+		row := concatRow(left[k], r)
+		ok, err := j.residual(row)
+		if err != nil {
+			return err
+		}
+		if ok {
+			j.out = append(j.out, row)
+			matched = true
+			if j.leftMatched != nil {
+				j.leftMatched[k] = true
+			}
+		}
+	}
+	if !matched && j.keepsRight() {
+		j.emit(nil, r)
+	}
+	return nil
+}
+
+// padLeft pads the rows of left that no right row joined with NULLs, if the
+// join keeps them.
+func (j *joiner) padLeft(left [][]interface{}) {
+	if !j.keepsLeft() {
+		return
+	}
+	for k, l := range left {
+		if !j.leftMatched[k] {
+			j.emit(l, nil)
+		}
+	}
+}
+
+// padRight pads the rows of right that no left row joined with NULLs, if the
+// join keeps them.
+func (j *joiner) padRight(right [][]interface{}) {
+	if !j.keepsRight() {
+		return
+	}
+	for k, r := range right {
+		if !j.rightMatched[k] {
+			j.emit(nil, r)
+		}
+	}
+}
+
+// upTo returns the indexes 0 to n-1.
+func (j *joiner) upTo(n int) []int {
+	for len(j.seq) < n {
+		j.seq = append(j.seq, len(j.seq))
+	}
+	return j.seq[:n]
+}
+
+// nestedLoop returns a function joining a left row with every row of right
+// the residual condition accepts.
+func (j *joiner) nestedLoop(right [][]interface{}) func(l []interface{}) error {
+	if j.keepsRight() {
+		j.rightMatched = make([]bool, len(right))
+	}
+	all := j.upTo(len(right))
+	return func(l []interface{}) error {
+		return j.joinLeft(l, right, all)
+	}
+}
+
+// hashJoin builds a hash table on right keyed by the equality expressions and
+// returns a function joining a left row with the right rows it finds there.
+func (j *joiner) hashJoin(right [][]interface{}, keys []equiKey) (func(l []interface{}) error, error) {
+	if j.keepsRight() {
+		j.rightMatched = make([]bool, len(right))
+	}
+	kinds := make([]keyKinds, len(keys))
+	leftKey, rightKey := leftKeyFunc(keys), rightKeyFunc(keys)
+	table := make(map[string][]int, len(right))
+	for i, row := range right {
+		key, ok, err := rightKey(row, kinds, false)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			table[key] = append(table[key], i)
+		}
+	}
+	return func(l []interface{}) error {
+		key, ok, err := leftKey(l, kinds, true)
+		if err != nil {
+			return err
+		}
+		var candidates []int
+		if ok {
+			candidates = table[key]
+		}
+		return j.joinLeft(l, right, candidates)
+	}, nil
+}
+
+// hashJoinLeft is hashJoin with the roles swapped: it builds the hash table
+// on left and returns a function joining a right row with the left rows it
+// finds there.
+func (j *joiner) hashJoinLeft(left [][]interface{}, keys []equiKey) (func(r []interface{}) error, error) {
+	if j.keepsLeft() {
+		j.leftMatched = make([]bool, len(left))
+	}
+	kinds := make([]keyKinds, len(keys))
+	leftKey, rightKey := leftKeyFunc(keys), rightKeyFunc(keys)
+	table := make(map[string][]int, len(left))
+	for i, row := range left {
+		key, ok, err := leftKey(row, kinds, true)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			table[key] = append(table[key], i)
+		}
+	}
+	return func(r []interface{}) error {
+		key, ok, err := rightKey(r, kinds, false)
+		if err != nil {
+			return err
+		}
+		var candidates []int
+		if ok {
+			candidates = table[key]
+		}
+		return j.joinRight(r, left, candidates)
+	}, nil
+}
+
+// indexJoin returns a function joining the next batch of left rows with the
+// right rows an index lookup finds for them.
+func (j *joiner) indexJoin(snap *snapshot, left rowIter, right *scanNode, keys []equiKey) func() error {
+	// The other equality conjuncts still have to hold, and this one too for
+	// rows changed since the index was last updated
+	residual := j.residual
+	kinds := make([]keyKinds, len(keys))
+	leftKey, rightKey := leftKeyFunc(keys), rightKeyFunc(keys)
+	j.residual = func(row []interface{}) (bool, error) {
+		lk, lok, err := leftKey(row[:j.leftWidth], kinds, true)
+		if err != nil || !lok {
+			return false, err
+		}
+		rk, rok, err := rightKey(row[j.leftWidth:], kinds, false)
+		if err != nil || !rok || lk != rk {
+			return false, err
+		}
+		return residual(row)
+	}
+
+	return func() error {
+		var batch [][]interface{}
+		for len(batch) < cursorBatch {
+			l, err := left.next()
+			if err != nil {
+				return err
+			}
+			if l == nil {
+				j.done = true
+				break
+			}
+			batch = append(batch, l)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		matches, err := right.lookup(snap, batch)
+		if err != nil {
+			return err
+		}
+		for i, l := range batch {
+			if err := j.joinLeft(l, matches[i], j.upTo(len(matches[i]))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// probeIndex returns the rows of right that may match each left row on
+// probe, looked up through the index on its right column. The result is
+// aligned with left.
+func probeIndex(left [][]interface{}, right *Table, probe equiKey, snap *snapshot) ([][]*Row, error) {
+	if probe.rightPK {
+		ids := make([]int, len(left))
+		valid := make([]bool, len(left))
+		for i, l := range left {
+			v, err := probe.left(l)
+			if err != nil {
+				return nil, err
+			}
+			switch n := v.(type) {
+			case int:
+				ids[i], valid[i] = n, true
+			case float64:
+				if n == float64(int(n)) {
+					ids[i], valid[i] = int(n), true
+				}
+			case string:
+				return nil, errorf(CodeTypeMismatch, "cannot compare string with int")
+			}
+		}
+		rows, err := right.selectByIds(snap, ids)
+		if err != nil {
+			return nil, err
+		}
+		matches := make([][]*Row, len(left))
+		for i, row := range rows {
+			if valid[i] && row != nil {
+				matches[i] = []*Row{row}
+			}
+		}
+		return matches, nil
+	}
+
+	ix := right.indexOn(probe.rightColumn)
+	isInt := ix.columns[0].Type == IntType
+	values := make([]interface{}, len(left))
+	for i, l := range left {
+		v, err := probe.left(l)
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := v.(float64); ok && f == float64(int(f)) {
+			v = int(f)
+		}
+		switch v := v.(type) {
+		case int:
+			if !isInt {
+				return nil, errorf(CodeTypeMismatch, "cannot compare number with string")
+			}
+			// Int columns hold 32-bit values
+			if v == int(int32(v)) {
+				values[i] = v
+			}
+		case string:
+			if isInt {
+				return nil, errorf(CodeTypeMismatch, "cannot compare string with number")
+			}
+			values[i] = v
+		case float64:
+			if !isInt {
+				return nil, errorf(CodeTypeMismatch, "cannot compare number with string")
+			}
+		}
+	}
+	return right.lookupKeys(snap, ix, values)
+}
+
+// keyKinds remembers the kind of values seen on each side of a join key, so
+// that comparing e.g. an int column with a string column is reported as an
+// error just like in the nested loop.
+type keyKinds struct {
+	left, right string
+}
+
+func (k *keyKinds) check(v interface{}, isLeft bool) error {
+	kind := "number"
+	switch v.(type) {
+	case string:
+		kind = "string"
+	case bool:
+		kind = "boolean"
+	}
+	side, other := &k.right, k.left
+	if isLeft {
+		side, other = &k.left, k.right
+	}
+	*side = kind
+	if other != "" && other != kind {
+		return errorf(CodeTypeMismatch, "cannot compare %s with %s", k.left, k.right)
+	}
+	return nil
+}
+
+type keyFunc func(row []interface{}, kinds []keyKinds, isLeft bool) (string, bool, error)
+
+func leftKeyFunc(keys []equiKey) keyFunc {
+	evals := make([]evalFunc, len(keys))
+	for i, k := range keys {
+		evals[i] = k.left
+	}
+	return makeKeyFunc(evals)
+}
+
+func rightKeyFunc(keys []equiKey) keyFunc {
+	evals := make([]evalFunc, len(keys))
+	for i, k := range keys {
+		evals[i] = k.right
+	}
+	return makeKeyFunc(evals)
+}
+
+// makeKeyFunc encodes the join key of a row; ok is false when any part is
+// NULL, since NULL never equals anything.
+func makeKeyFunc(evals []evalFunc) keyFunc {
+	return func(row []interface{}, kinds []keyKinds, isLeft bool) (string, bool, error) {
+		var sb strings.Builder
+		for i, eval := range evals {
+			v, err := eval(row)
+			if err != nil {
+				return "", false, err
+			}
+			if v == nil {
+				return "", false, nil
+			}
+			if err := kinds[i].check(v, isLeft); err != nil {
+				return "", false, err
+			}
+			sb.WriteString(valueKey(v))
+			sb.WriteByte(0)
+		}
+		return sb.String(), true, nil
+	}
+}
+
+func concatRow(l, r []interface{}) []interface{} {
+	row := make([]interface{}, 0, len(l)+len(r))
+	row = append(row, l...)
+	return append(row, r...)
+}
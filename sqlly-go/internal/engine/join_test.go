@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// openJoinDB returns a session on tables a, b and c, where b refers to a by
+// a_id and c to b by b_id. Row 12 of b and row 101 of c match nothing.
+func openJoinDB(t *testing.T) *Session {
+	t.Helper()
+	db := openTestDB(t, t.TempDir(), testOptions())
+	t.Cleanup(func() { db.Close() })
+	s := db.NewSession()
+	mustExec(t, s, `CREATE TABLE a (id INT PRIMARY KEY, x TEXT);
+		CREATE TABLE b (id INT PRIMARY KEY, a_id INT, y TEXT);
+		CREATE TABLE c (id INT PRIMARY KEY, b_id INT);
+		INSERT INTO a VALUES (1, 'a1'), (2, 'a2'), (3, 'a3');
+		INSERT INTO b VALUES (10, 1, 'b10'), (11, 1, 'b11'), (12, 9, 'b12');
+		INSERT INTO c VALUES (100, 10), (101, 13)`)
+	return s
+}
+
+func TestOuterJoins(t *testing.T) {
+	s := openJoinDB(t)
+	checkRows(t, s, "SELECT a.id, b.id FROM a LEFT JOIN b ON b.a_id = a.id ORDER BY a.id, b.id",
+		[][]interface{}{{1, 10}, {1, 11}, {2, nil}, {3, nil}})
+	checkRows(t, s, "SELECT a.id, b.id FROM a RIGHT JOIN b ON b.a_id = a.id ORDER BY b.id",
+		[][]interface{}{{1, 10}, {1, 11}, {nil, 12}})
+	checkRows(t, s, "SELECT a.id, b.id FROM a FULL OUTER JOIN b ON b.a_id = a.id ORDER BY a.id, b.id",
+		[][]interface{}{{nil, 12}, {1, 10}, {1, 11}, {2, nil}, {3, nil}})
+
+	// A condition in ON only decides which rows match; in WHERE it filters
+	// the padded rows too
+	checkRows(t, s, "SELECT a.id, b.id FROM a LEFT JOIN b ON b.a_id = a.id AND b.y = 'b11' ORDER BY a.id",
+		[][]interface{}{{1, 11}, {2, nil}, {3, nil}})
+	checkRows(t, s, "SELECT a.id FROM a LEFT JOIN b ON b.a_id = a.id WHERE b.id IS NULL ORDER BY a.id",
+		[][]interface{}{{2}, {3}})
+}
+
+func TestMultiTableJoins(t *testing.T) {
+	s := openJoinDB(t)
+	checkRows(t, s, "SELECT a.x, b.y, c.id FROM a JOIN b ON b.a_id = a.id JOIN c ON c.b_id = b.id",
+		[][]interface{}{{"a1", "b10", 100}})
+	checkRows(t, s, "SELECT a.id, b.id, c.id FROM a LEFT JOIN b ON b.a_id = a.id LEFT JOIN c ON c.b_id = b.id ORDER BY a.id, b.id",
+		[][]interface{}{{1, 10, 100}, {1, 11, nil}, {2, nil, nil}, {3, nil, nil}})
+	checkRows(t, s, "SELECT COUNT(*) FROM a CROSS JOIN b CROSS JOIN c", [][]interface{}{{18}})
+	checkRows(t, s, "SELECT p.id, q.id FROM a p JOIN a q ON q.id = p.id + 1 ORDER BY p.id",
+		[][]interface{}{{1, 2}, {2, 3}})
+	execFails(t, s, "SELECT id FROM a JOIN b ON b.a_id = a.id", CodeAmbiguousColumn)
+	execFails(t, s, "SELECT * FROM a JOIN a ON a.id = a.id", CodeDuplicateAlias)
+}
+
+func TestHashJoinBuildsOnSmallerSide(t *testing.T) {
+	s := openJoinDB(t)
+	for i := 20; i < 60; i++ {
+		mustExec(t, s, fmt.Sprintf("INSERT INTO b VALUES (%d, %d, 'b%d')", i, i%4, i))
+	}
+	for _, tc := range []struct{ sql, build string }{
+		{"SELECT a.x, b.id FROM a LEFT JOIN b ON b.a_id = a.id", "Hash Build: left"},
+		{"SELECT a.x, b.id FROM b RIGHT JOIN a ON b.a_id = a.id", "Hash Build: right"},
+	} {
+		res := mustExec(t, s, "EXPLAIN "+tc.sql)
+		plan := ""
+		for _, row := range res[0].Rows {
+			plan += fmt.Sprint(row[0]) + "\n"
+		}
+		if !strings.Contains(plan, tc.build) {
+			t.Errorf("%s: want %q in plan\n%s", tc.sql, tc.build, plan)
+		}
+	}
+
+	// Rows and columns come out the same whichever side is built
+	checkRows(t, s, "SELECT a.x, b.id FROM a JOIN b ON b.a_id = a.id AND b.id < 30 ORDER BY b.id",
+		[][]interface{}{{"a1", 10}, {"a1", 11}, {"a1", 21}, {"a2", 22}, {"a3", 23}, {"a1", 25}, {"a2", 26}, {"a3", 27}, {"a1", 29}})
+	checkRows(t, s, "SELECT a.id, b.id FROM a LEFT JOIN b ON b.a_id = a.id AND b.id > 50 ORDER BY a.id, b.id",
+		[][]interface{}{{1, 53}, {1, 57}, {2, 54}, {2, 58}, {3, 51}, {3, 55}, {3, 59}})
+	checkRows(t, s, "SELECT a.id, b.id FROM a LEFT JOIN b ON b.a_id = a.id AND b.id > 57 ORDER BY a.id, b.id",
+		[][]interface{}{{1, nil}, {2, 58}, {3, 59}})
+	checkRows(t, s, "SELECT a.id, b.id FROM a RIGHT JOIN b ON b.a_id = a.id WHERE b.id < 22 ORDER BY b.id",
+		[][]interface{}{{1, 10}, {1, 11}, {nil, 12}, {nil, 20}, {1, 21}})
+	checkRows(t, s, "SELECT COUNT(*), COUNT(a.id), COUNT(b.id) FROM a FULL OUTER JOIN b ON b.a_id = a.id AND b.id > 57",
+		[][]interface{}{{44, 3, 43}})
+	checkRows(t, s, "SELECT * FROM a JOIN b ON b.a_id = a.id WHERE b.id = 58",
+		[][]interface{}{{2, "a2", 58, 2, "b58"}})
+}
@@ -0,0 +1,248 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+// Pos is a 1-based line/column position in the SQL source.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+type token struct {
+	kind tokenKind
+	text string // Identifier name, unescaped string contents, number text or symbol
+	pos  Pos
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokString:
+		return fmt.Sprintf("string '%s'", t.text)
+	case tokNumber:
+		return fmt.Sprintf("number %s", t.text)
+	default:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+}
+
+// SyntaxError reports a lexing or parsing failure at a position in the source.
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, col %d: %s", e.Pos.Line, e.Pos.Col, e.Msg)
+}
+
+type lexer struct {
+	src  []rune
+	i    int
+	line int
+	col  int
+}
+
+// tokenize splits a SQL string into tokens, skipping whitespace and comments.
+func tokenize(sql string) ([]token, error) {
+	lx := &lexer{src: []rune(sql), line: 1, col: 1}
+	var toks []token
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.kind == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (lx *lexer) peek(offset int) rune {
+	if lx.i+offset >= len(lx.src) {
+		return 0
+	}
+	return lx.src[lx.i+offset]
+}
+
+func (lx *lexer) advance() rune {
+	r := lx.src[lx.i]
+	lx.i++
+	if r == '\n' {
+		lx.line++
+		lx.col = 1
+	} else {
+		lx.col++
+	}
+	return r
+}
+
+func (lx *lexer) pos() Pos {
+	return Pos{Line: lx.line, Col: lx.col}
+}
+
+func (lx *lexer) skipSpaceAndComments() error {
+	for lx.i < len(lx.src) {
+		r := lx.peek(0)
+		switch {
+		case unicode.IsSpace(r):
+			lx.advance()
+		case r == '-' && lx.peek(1) == '-':
+			for lx.i < len(lx.src) && lx.peek(0) != '\n' {
+				lx.advance()
+			}
+		case r == '/' && lx.peek(1) == '*':
+			start := lx.pos()
+			lx.advance()
+			lx.advance()
+			for {
+				if lx.i >= len(lx.src) {
+					return &SyntaxError{Pos: start, Msg: "unterminated comment"}
+				}
+				if lx.peek(0) == '*' && lx.peek(1) == '/' {
+					lx.advance()
+					lx.advance()
+					break
+				}
+				lx.advance()
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (lx *lexer) next() (token, error) {
+	if err := lx.skipSpaceAndComments(); err != nil {
+		return token{}, err
+	}
+	start := lx.pos()
+	if lx.i >= len(lx.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	r := lx.peek(0)
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		var sb strings.Builder
+		for lx.i < len(lx.src) && (lx.peek(0) == '_' || unicode.IsLetter(lx.peek(0)) || unicode.IsDigit(lx.peek(0))) {
+			sb.WriteRune(lx.advance())
+		}
+		return token{kind: tokIdent, text: sb.String(), pos: start}, nil
+
+	case unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(lx.peek(1))):
+		var sb strings.Builder
+		seenDot := false
+		for lx.i < len(lx.src) {
+			c := lx.peek(0)
+			if c == '.' && !seenDot && unicode.IsDigit(lx.peek(1)) {
+				seenDot = true
+			} else if !unicode.IsDigit(c) {
+				break
+			}
+			sb.WriteRune(lx.advance())
+		}
+		if c := lx.peek(0); c == '_' || unicode.IsLetter(c) {
+			return token{}, &SyntaxError{Pos: lx.pos(), Msg: fmt.Sprintf("unexpected character '%c' in number", c)}
+		}
+		return token{kind: tokNumber, text: sb.String(), pos: start}, nil
+
+	case r == '\'' || r == '"':
+		s, err := lx.readQuoted(r, true)
+		if err != nil {
+			return token{}, err
+		}
+		return token{kind: tokString, text: s, pos: start}, nil
+
+	case r == '`':
+		s, err := lx.readQuoted(r, false)
+		if err != nil {
+			return token{}, err
+		}
+		if s == "" {
+			return token{}, &SyntaxError{Pos: start, Msg: "empty quoted identifier"}
+		}
+		return token{kind: tokQuotedIdent, text: s, pos: start}, nil
+	}
+
+	// Two-character operators first
+	if lx.i+1 < len(lx.src) {
+		switch string(lx.src[lx.i : lx.i+2]) {
+		case "<>", "!=", "<=", ">=", "||":
+			op := string(lx.src[lx.i : lx.i+2])
+			lx.advance()
+			lx.advance()
+			return token{kind: tokSymbol, text: op, pos: start}, nil
+		}
+	}
+	switch r {
+	case '(', ')', ',', ';', '.', '*', '=', '<', '>', '+', '-', '/', '%':
+		lx.advance()
+		return token{kind: tokSymbol, text: string(r), pos: start}, nil
+	}
+	return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character '%c'", r)}
+}
+
+// readQuoted reads a literal delimited by quote. A doubled quote stands for
+// itself; backslash escapes are only honoured in string literals.
+func (lx *lexer) readQuoted(quote rune, escapes bool) (string, error) {
+	start := lx.pos()
+	lx.advance()
+	var sb strings.Builder
+	for {
+		if lx.i >= len(lx.src) {
+			if escapes {
+				return "", &SyntaxError{Pos: start, Msg: "unterminated string literal"}
+			}
+			return "", &SyntaxError{Pos: start, Msg: "unterminated quoted identifier"}
+		}
+		charPos := lx.pos()
+		r := lx.advance()
+		switch {
+		case r == quote:
+			if lx.peek(0) == quote {
+				sb.WriteRune(lx.advance())
+				continue
+			}
+			return sb.String(), nil
+		case r == '\\' && escapes:
+			if lx.i >= len(lx.src) {
+				return "", &SyntaxError{Pos: start, Msg: "unterminated string literal"}
+			}
+			switch e := lx.advance(); e {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			case 'r':
+				sb.WriteRune('\r')
+			case '0':
+				sb.WriteRune(0)
+			case '\\', '\'', '"':
+				sb.WriteRune(e)
+			default:
+				return "", &SyntaxError{Pos: charPos, Msg: fmt.Sprintf("unknown escape sequence '\\%c'", e)}
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []token
+	}{
+		{"SELECT a, b1 FROM t;", []token{
+			{tokIdent, "SELECT", Pos{1, 1}}, {tokIdent, "a", Pos{1, 8}}, {tokSymbol, ",", Pos{1, 9}},
+			{tokIdent, "b1", Pos{1, 11}}, {tokIdent, "FROM", Pos{1, 14}}, {tokIdent, "t", Pos{1, 19}},
+			{tokSymbol, ";", Pos{1, 20}}, {tokEOF, "", Pos{1, 21}},
+		}},
+		{"12 3.5 .5 7.", []token{
+			{tokNumber, "12", Pos{1, 1}}, {tokNumber, "3.5", Pos{1, 4}}, {tokNumber, ".5", Pos{1, 8}},
+			{tokNumber, "7", Pos{1, 11}}, {tokSymbol, ".", Pos{1, 12}}, {tokEOF, "", Pos{1, 13}},
+		}},
+		{`'it''s' "say \"hi\"" 'a\nb\t\\' ''`, []token{
+			{tokString, "it's", Pos{1, 1}}, {tokString, `say "hi"`, Pos{1, 9}},
+			{tokString, "a\nb\t\\", Pos{1, 22}}, {tokString, "", Pos{1, 33}}, {tokEOF, "", Pos{1, 35}},
+		}},
+		{"`my table` `a``b` `c\\d`", []token{
+			{tokQuotedIdent, "my table", Pos{1, 1}}, {tokQuotedIdent, "a`b", Pos{1, 12}},
+			{tokQuotedIdent, `c\d`, Pos{1, 19}}, {tokEOF, "", Pos{1, 24}},
+		}},
+		{"a<>b!=c<=d>=e||f<g", []token{
+			{tokIdent, "a", Pos{1, 1}}, {tokSymbol, "<>", Pos{1, 2}}, {tokIdent, "b", Pos{1, 4}},
+			{tokSymbol, "!=", Pos{1, 5}}, {tokIdent, "c", Pos{1, 7}}, {tokSymbol, "<=", Pos{1, 8}},
+			{tokIdent, "d", Pos{1, 10}}, {tokSymbol, ">=", Pos{1, 11}}, {tokIdent, "e", Pos{1, 13}},
+			{tokSymbol, "||", Pos{1, 14}}, {tokIdent, "f", Pos{1, 16}}, {tokSymbol, "<", Pos{1, 17}},
+			{tokIdent, "g", Pos{1, 18}}, {tokEOF, "", Pos{1, 19}},
+		}},
+		{"-- comment\n  x /* a\nb */ - y -- end", []token{
+			{tokIdent, "x", Pos{2, 3}}, {tokSymbol, "-", Pos{3, 6}}, {tokIdent, "y", Pos{3, 8}},
+			{tokEOF, "", Pos{3, 16}},
+		}},
+		{"", []token{{tokEOF, "", Pos{1, 1}}}},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.sql)
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", tt.sql, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q):\n got %v\nwant %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT 'abc", "line 1, col 8: unterminated string literal"},
+		{"SELECT 'abc\\", "line 1, col 8: unterminated string literal"},
+		{"SELECT\n  `abc", "line 2, col 3: unterminated quoted identifier"},
+		{"SELECT ``", "line 1, col 8: empty quoted identifier"},
+		{"SELECT 'a\\qb'", "line 1, col 10: unknown escape sequence '\\q'"},
+		{"SELECT 1 /* never closed", "line 1, col 10: unterminated comment"},
+		{"SELECT 12abc", "line 1, col 10: unexpected character 'a' in number"},
+		{"SELECT a\n  FROM t WHERE a ? 1", "line 2, col 18: unexpected character '?'"},
+		{"SELECT !a", "line 1, col 8: unexpected character '!'"},
+	}
+	for _, tt := range tests {
+		_, err := tokenize(tt.sql)
+		if _, ok := err.(*SyntaxError); !ok || err.Error() != tt.want {
+			t.Errorf("tokenize(%q): got error %v, want %q", tt.sql, err, tt.want)
+		}
+	}
+}
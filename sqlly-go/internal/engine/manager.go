@@ -1,18 +1,63 @@
 package engine
 
-import "sync"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
 
+var validDbName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// DatabaseManager owns every database under DataDir, one sub-directory each.
 type DatabaseManager struct {
+	DataDir   string
 	Databases map[string]*Database
+	opts      Options
 	mu        sync.RWMutex
 }
 
-func NewDatabaseManager() *DatabaseManager {
+// NewDatabaseManager opens every database found in dataDir and makes sure the
+// "default" database exists. Unless opts names a buffer pool, the databases
+// share a new one of DefaultBufferPoolPages pages.
+func NewDatabaseManager(dataDir string, opts Options) (*DatabaseManager, error) {
+	if opts.BufferPool == nil {
+		opts.BufferPool = NewBufferPool(DefaultBufferPoolPages)
+	}
 	mgr := &DatabaseManager{
+		DataDir:   dataDir,
 		Databases: make(map[string]*Database),
+		opts:      opts,
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !validDbName.MatchString(e.Name()) {
+			continue
+		}
+		dir := filepath.Join(dataDir, e.Name())
+		if _, err := os.Stat(catalogPath(dir)); err != nil {
+			continue
+		}
+		db, err := NewDatabase(dir, e.Name(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("opening database %s: %w", e.Name(), err)
+		}
+		mgr.Databases[e.Name()] = db
 	}
-	mgr.CreateDatabase("default")
-	return mgr
+
+	if _, err := mgr.CreateDatabase("default"); err != nil {
+		return nil, err
+	}
+	return mgr, nil
 }
 
 func (mgr *DatabaseManager) GetDatabase(name string) *Database {
@@ -21,26 +66,48 @@ func (mgr *DatabaseManager) GetDatabase(name string) *Database {
 	return mgr.Databases[name]
 }
 
-func (mgr *DatabaseManager) CreateDatabase(name string) *Database {
+func (mgr *DatabaseManager) CreateDatabase(name string) (*Database, error) {
+	if !validDbName.MatchString(name) {
+		return nil, errorf(CodeInvalidName, "invalid database name %q: use letters, digits, '_' or '-'", name)
+	}
+
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
-	
+
 	if db, exists := mgr.Databases[name]; exists {
-		return db
+		return db, nil
+	}
+
+	newDb, err := NewDatabase(filepath.Join(mgr.DataDir, name), name, mgr.opts)
+	if err != nil {
+		return nil, err
 	}
-	
-	newDb := NewDatabase(name)
 	mgr.Databases[name] = newDb
-	return newDb
+	return newDb, nil
 }
 
 func (mgr *DatabaseManager) ListDatabases() []string {
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
-	
+
 	keys := make([]string, 0, len(mgr.Databases))
 	for k := range mgr.Databases {
 		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 	return keys
-}
\ No newline at end of file
+}
+
+// Close closes every database, returning the first error.
+func (mgr *DatabaseManager) Close() error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	var first error
+	for _, db := range mgr.Databases {
+		if err := db.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
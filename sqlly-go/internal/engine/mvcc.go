@@ -0,0 +1,116 @@
+package engine
+
+import "sync"
+
+// rowVersion is one version of a row: the record at offset in the table's
+// data file. Updating a row ends its current version and appends a new one,
+// so a reader whose snapshot predates the update keeps seeing the old values.
+type rowVersion struct {
+	id     int
+	offset int64
+	size   int64             // Length of the record in bytes; 0 when only read
+	xmin   uint64            // Transaction that created the version; 0 once every transaction sees it
+	xmax   uint64            // Transaction that deleted it; 0 while live
+	keys   map[string]string // Keys in the secondary indexes, by tree
+	base   bool              // Found through the primary key index rather than written since the last checkpoint
+}
+
+// txManager hands out transaction ids and tracks which transactions are
+// running. Versions created by a transaction that rolls back are discarded as
+// part of the rollback, so a transaction that is no longer running counts as
+// committed.
+type txManager struct {
+	mu     sync.Mutex
+	last   uint64
+	active map[uint64]uint64 // Running transaction -> oldest transaction its snapshot does not see
+}
+
+func newTxManager() *txManager {
+	return &txManager{active: make(map[uint64]uint64)}
+}
+
+// begin starts a transaction and returns its id together with its snapshot.
+func (m *txManager) begin() (uint64, *snapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.last++
+	id := m.last
+	snap := m.snapshotLocked(id)
+	m.active[id] = min(snap.xmin(), id)
+	return id, snap
+}
+
+func (m *txManager) end(id uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.active, id)
+}
+
+func (m *txManager) isActive(id uint64) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.active[id]
+	return ok
+}
+
+// snapshot returns a view of the transactions committed so far, for readers
+// outside any transaction.
+func (m *txManager) snapshot() *snapshot {
+	if m == nil {
+		return &snapshot{xmax: ^uint64(0)}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshotLocked(0)
+}
+
+func (m *txManager) snapshotLocked(own uint64) *snapshot {
+	snap := &snapshot{own: own, xmax: m.last + 1, active: make(map[uint64]bool, len(m.active))}
+	for id := range m.active {
+		snap.active[id] = true
+	}
+	return snap
+}
+
+// horizon returns the oldest transaction whose changes some running
+// transaction cannot see. Versions deleted by a committed transaction older
+// than the horizon are invisible to everyone, and versions created by one are
+// visible to everyone.
+func (m *txManager) horizon() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.last + 1
+	for _, xmin := range m.active {
+		h = min(h, xmin)
+	}
+	return h
+}
+
+// snapshot decides which versions a transaction sees: those written by
+// transactions that committed before it began, and its own.
+type snapshot struct {
+	own    uint64          // The reading transaction; 0 for none
+	xmax   uint64          // Transactions from this id on started later
+	active map[uint64]bool // Transactions running when the snapshot was taken
+}
+
+// sees reports whether the changes of transaction id are visible.
+func (s *snapshot) sees(id uint64) bool {
+	return id == 0 || id == s.own || (id < s.xmax && !s.active[id])
+}
+
+func (s *snapshot) visible(v *rowVersion) bool {
+	return s.sees(v.xmin) && (v.xmax == 0 || !s.sees(v.xmax))
+}
+
+// xmin returns the oldest transaction the snapshot does not see.
+func (s *snapshot) xmin() uint64 {
+	oldest := s.xmax
+	for id := range s.active {
+		oldest = min(oldest, id)
+	}
+	return oldest
+}
@@ -0,0 +1,61 @@
+package engine
+
+import "testing"
+
+func TestSnapshotVisibility(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	a, b := db.NewSession(), db.NewSession()
+	mustExec(t, a, "CREATE TABLE t (id INT PRIMARY KEY, n INT); INSERT INTO t VALUES (1, 10)")
+
+	mustExec(t, a, "BEGIN; UPDATE t SET n = 20 WHERE id = 1; INSERT INTO t VALUES (2, 20)")
+	checkRows(t, a, "SELECT id, n FROM t ORDER BY id", [][]interface{}{{1, 20}, {2, 20}})
+	// Uncommitted changes are invisible to everyone else
+	checkRows(t, b, "SELECT id, n FROM t ORDER BY id", [][]interface{}{{1, 10}})
+
+	mustExec(t, b, "BEGIN")
+	mustExec(t, a, "COMMIT")
+	// b keeps the snapshot it began with, statements outside a transaction
+	// see the commit
+	checkRows(t, b, "SELECT id, n FROM t ORDER BY id", [][]interface{}{{1, 10}})
+	checkRows(t, a, "SELECT id, n FROM t ORDER BY id", [][]interface{}{{1, 20}, {2, 20}})
+	mustExec(t, a, "DELETE FROM t WHERE id = 1")
+	checkRows(t, b, "SELECT id, n FROM t WHERE id = 1", [][]interface{}{{1, 10}})
+	mustExec(t, b, "COMMIT")
+	checkRows(t, b, "SELECT id, n FROM t ORDER BY id", [][]interface{}{{2, 20}})
+}
+
+func TestWriteWriteConflict(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	a, b := db.NewSession(), db.NewSession()
+	mustExec(t, a, "CREATE TABLE t (id INT PRIMARY KEY, n INT); INSERT INTO t VALUES (1, 10), (2, 20)")
+
+	// A row another open transaction is changing
+	mustExec(t, a, "BEGIN; UPDATE t SET n = 11 WHERE id = 1")
+	mustExec(t, b, "BEGIN; UPDATE t SET n = 21 WHERE id = 2")
+	execFails(t, b, "UPDATE t SET n = n + 1", CodeSerialization)
+	execFails(t, b, "DELETE FROM t WHERE id = 1", CodeSerialization)
+	if !b.InTransaction() {
+		t.Fatal("conflict ended the transaction")
+	}
+	// The failed statements were undone, the earlier one kept
+	checkRows(t, b, "SELECT id, n FROM t ORDER BY id", [][]interface{}{{1, 10}, {2, 21}})
+	mustExec(t, a, "COMMIT")
+	mustExec(t, b, "COMMIT")
+	checkRows(t, a, "SELECT id, n FROM t ORDER BY id", [][]interface{}{{1, 11}, {2, 21}})
+
+	// A row committed by another transaction since the snapshot was taken
+	mustExec(t, b, "BEGIN; SELECT n FROM t")
+	mustExec(t, a, "UPDATE t SET n = 12 WHERE id = 1")
+	execFails(t, b, "UPDATE t SET n = 0 WHERE id = 1", CodeSerialization)
+	mustExec(t, b, "ROLLBACK")
+	checkRows(t, b, "SELECT n FROM t WHERE id = 1", [][]interface{}{{12}})
+
+	// A key another open transaction inserted
+	mustExec(t, a, "BEGIN; INSERT INTO t VALUES (3, 30)")
+	execFails(t, b, "INSERT INTO t VALUES (3, 31)", CodeSerialization)
+	mustExec(t, a, "ROLLBACK")
+	mustExec(t, b, "INSERT INTO t VALUES (3, 31)")
+	checkRows(t, a, "SELECT n FROM t WHERE id = 3", [][]interface{}{{31}})
+}
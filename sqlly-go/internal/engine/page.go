@@ -0,0 +1,338 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A table file is a sequence of fixed-size pages. Page 0 is the file header:
+//
+//	magic [8]byte | version uint32 | page size uint32 | columns uint32
+//
+// Columns is the number of columns the records were written with, so that
+// a file left out of step with the catalog by a crash is noticed; it is 0 in
+// files written before it was recorded. Version 1 files were written before records had a null bitmap (see
+// writeRecord) and are converted when opened.
+//
+// Every other page is a slotted page holding records:
+//
+//	slot count uint16 | free end uint16 | slots... | free space | records...
+//
+// Slot i (4 bytes at pageHeaderSize+4i) gives the position and length of
+// record i inside the page. Records fill the page from the end backwards, so
+// the slot directory and the record area grow towards each other. An all-zero
+// page is a valid empty page. Records are never moved or removed in place;
+// deleting one sets its deleted flag, and VACUUM rewrites the file.
+const (
+	pageSize       = 8192
+	pageHeaderSize = 4
+	slotSize       = 4
+	maxRecordSize  = pageSize - pageHeaderSize - slotSize
+	pageVersion    = 2
+)
+
+var pageMagic = [8]byte{'S', 'Q', 'L', 'l', 'y', 'T', 'B', 'L'}
+
+// slottedPage is a view of one page's bytes.
+type slottedPage []byte
+
+func (p slottedPage) slotCount() int {
+	return int(binary.LittleEndian.Uint16(p[0:]))
+}
+
+func (p slottedPage) freeEnd() int {
+	if end := int(binary.LittleEndian.Uint16(p[2:])); end != 0 {
+		return end
+	}
+	return pageSize
+}
+
+func (p slottedPage) freeSpace() int {
+	return p.freeEnd() - pageHeaderSize - slotSize*p.slotCount()
+}
+
+// slot returns the position and length of record i.
+func (p slottedPage) slot(i int) (pos, size int) {
+	s := pageHeaderSize + slotSize*i
+	return int(binary.LittleEndian.Uint16(p[s:])), int(binary.LittleEndian.Uint16(p[s+2:]))
+}
+
+// record returns the bytes of record i, or nil if the slot is empty or does
+// not fit the page.
+func (p slottedPage) record(i int) []byte {
+	pos, size := p.slot(i)
+	if size == 0 || pos < pageHeaderSize+slotSize*p.slotCount() || pos+size > pageSize {
+		return nil
+	}
+	return p[pos : pos+size]
+}
+
+// add appends record to the page, returning its slot and position. The
+// caller must have checked that it fits.
+func (p slottedPage) add(record []byte) (slot, pos int) {
+	slot = p.slotCount()
+	pos = p.freeEnd() - len(record)
+	p.put(slot, pos, record)
+	return slot, pos
+}
+
+// put stores record at pos as slot i, growing the slot directory if needed.
+// Putting the same record again changes nothing, which makes it safe to
+// replay from the write-ahead log.
+func (p slottedPage) put(i, pos int, record []byte) {
+	copy(p[pos:], record)
+	s := pageHeaderSize + slotSize*i
+	binary.LittleEndian.PutUint16(p[s:], uint16(pos))
+	binary.LittleEndian.PutUint16(p[s+2:], uint16(len(record)))
+	if i >= p.slotCount() {
+		binary.LittleEndian.PutUint16(p[0:], uint16(i+1))
+	}
+	if pos < p.freeEnd() {
+		binary.LittleEndian.PutUint16(p[2:], uint16(pos))
+	}
+}
+
+func fileHeaderPage(columns int) []byte {
+	page := make([]byte, pageSize)
+	copy(page, pageMagic[:])
+	binary.LittleEndian.PutUint32(page[8:], pageVersion)
+	binary.LittleEndian.PutUint32(page[12:], pageSize)
+	binary.LittleEndian.PutUint32(page[16:], uint32(columns))
+	return page
+}
+
+// fileColumns returns the number of columns the header of the table file at
+// path records, or 0 if it records none.
+func fileColumns(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	header := make([]byte, 20)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !bytes.Equal(header[:8], pageMagic[:]) {
+		return 0, nil
+	}
+	return int(binary.LittleEndian.Uint32(header[16:])), nil
+}
+
+// checkFileHeader returns the version of the table file f, or 0 if it has no
+// header, as an empty file or one in the earlier unpaged format.
+func checkFileHeader(f *os.File) (uint32, error) {
+	header := make([]byte, 16)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !bytes.Equal(header[:8], pageMagic[:]) {
+		return 0, nil
+	}
+	v := binary.LittleEndian.Uint32(header[8:])
+	if v == 0 || v > pageVersion {
+		return 0, fmt.Errorf("unsupported table file version %d", v)
+	}
+	if size := binary.LittleEndian.Uint32(header[12:]); size != pageSize {
+		return 0, fmt.Errorf("table file has %d byte pages, expected %d", size, pageSize)
+	}
+	return v, nil
+}
+
+// pageFile is an open table file, read and written a page at a time through a
+// buffer pool.
+type pageFile struct {
+	f     *os.File
+	pool  *BufferPool
+	pages uint32 // Number of pages, including the header
+}
+
+// openPageFile opens the table file at path, creating it if it does not
+// exist and converting it if it still holds an earlier format.
+func openPageFile(path string, columns []ColumnDef, pool *BufferPool) (*pageFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	version, err := checkFileHeader(f)
+	if err == nil && version != pageVersion {
+		err = convertLegacyFile(f, path, columns, version)
+		if err == nil {
+			f.Close()
+			return openPageFile(path, columns, pool)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// A page cut short by a crash while the file grew is written again in
+	// full when it is next used
+	return &pageFile{f: f, pool: pool, pages: uint32(info.Size() / pageSize)}, nil
+}
+
+// convertLegacyFile rewrites a file of the given earlier version in the
+// current format: version 0 is back-to-back records, as written before
+// tables were paged, and version 1 is paged records without a null bitmap.
+// An empty file just gets its header. The table's index file points into the
+// old file, so it is removed to be rebuilt.
+func convertLegacyFile(f *os.File, path string, columns []ColumnDef, version uint32) error {
+	var rows []*Row
+	add := func(r io.Reader) error {
+		row, isDeleted, err := decodeRecord(r, columns, false)
+		if err == nil && !isDeleted {
+			rows = append(rows, row)
+		}
+		return err
+	}
+	if version == 0 {
+		r := bufio.NewReader(io.NewSectionReader(f, 0, 1<<62))
+		for add(r) == nil {
+		}
+	} else {
+		page := slottedPage(make([]byte, pageSize))
+		for n := int64(1); ; n++ {
+			if _, err := f.ReadAt(page, n*pageSize); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return err
+			}
+			for i := 0; i < page.slotCount(); i++ {
+				if record := page.record(i); record != nil {
+					add(bytes.NewReader(record))
+				}
+			}
+		}
+	}
+	if err := writePageFile(path, columns, rows); err != nil {
+		return err
+	}
+	if err := os.Remove(strings.TrimSuffix(path, ".db") + ".idx"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(rows) > 0 {
+		log.Printf("converted %s to table file version %d (%d rows)", path, pageVersion, len(rows))
+	}
+	return nil
+}
+
+// writePageFile replaces the file at path with a paged file holding rows,
+// writing a temporary file first so the old contents survive a crash.
+func writePageFile(path string, columns []ColumnDef, rows []*Row) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	w := bufio.NewWriterSize(f, 64<<10)
+	w.Write(fileHeaderPage(len(columns)))
+	page := slottedPage(make([]byte, pageSize))
+	var buf bytes.Buffer
+	for _, row := range rows {
+		buf.Reset()
+		writeRecord(&buf, columns, row)
+		if buf.Len() > maxRecordSize {
+			return fail(errRowTooBig(buf.Len()))
+		}
+		if page.freeSpace() < buf.Len()+slotSize {
+			w.Write(page)
+			clear(page)
+		}
+		page.add(buf.Bytes())
+	}
+	if page.slotCount() > 0 {
+		w.Write(page)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func errRowTooBig(size int) error {
+	return errorf(CodeProgramLimit, "row is too big: %d bytes, maximum size is %d", size, maxRecordSize)
+}
+
+// pin returns the frame holding page n, which stays in memory until unpinned.
+// Pages past the end of the file read as empty.
+func (pf *pageFile) pin(n uint32) (*frame, error) {
+	return pf.pool.pin(pf, n)
+}
+
+func (pf *pageFile) unpin(fr *frame, dirty bool) {
+	pf.pool.unpin(fr, dirty)
+}
+
+// unpinLogged unpins fr after a change already recorded in w, which must
+// reach stable storage before the page is written back. A nil w logs nothing.
+func (pf *pageFile) unpinLogged(fr *frame, w *wal) {
+	if w == nil {
+		pf.pool.unpin(fr, true)
+		return
+	}
+	pf.pool.unpinLogged(fr, w, w.end())
+}
+
+// sync writes the file's dirty pages back and flushes the file to stable
+// storage.
+func (pf *pageFile) sync() error {
+	if err := pf.pool.flush(pf); err != nil {
+		return err
+	}
+	return pf.f.Sync()
+}
+
+// close syncs the file and forgets its pages.
+func (pf *pageFile) close() error {
+	err := pf.sync()
+	pf.pool.drop(pf)
+	if closeErr := pf.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// discard closes the file without writing its dirty pages back, for a file
+// that is about to be replaced or removed.
+func (pf *pageFile) discard() {
+	pf.pool.drop(pf)
+	pf.f.Close()
+}
@@ -0,0 +1,1146 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// reservedWords cannot be used as bare identifiers (e.g. implicit aliases).
+var reservedWords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "INSERT": true, "INTO": true,
+	"VALUES": true, "UPDATE": true, "SET": true, "DELETE": true, "CREATE": true,
+	"DROP": true, "TABLE": true, "JOIN": true, "ON": true, "AND": true, "OR": true,
+	"NOT": true, "AS": true, "UNIQUE": true, "ALTER": true, "ADD": true,
+	"RENAME": true, "ORDER": true, "BY": true, "ASC": true, "DESC": true,
+	"LIMIT": true, "OFFSET": true, "GROUP": true, "HAVING": true, "DISTINCT": true,
+	"INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "OUTER": true, "CROSS": true,
+	"BETWEEN": true, "IS": true, "NULL": true, "DEFAULT": true, "RETURNING": true,
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+// Parse parses one or more semicolon-separated SQL statements.
+func Parse(sql string) ([]Statement, error) {
+	toks, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+
+	var stmts []Statement
+	for {
+		for p.acceptSymbol(";") {
+		}
+		if p.peek().kind == tokEOF {
+			return stmts, nil
+		}
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+		if p.peek().kind != tokEOF && !p.isSymbol(";") {
+			return nil, p.errorf("expected ';' or end of input, found %s", p.peek())
+		}
+	}
+}
+
+// parseExprText parses a single expression, such as a stored column default.
+func parseExprText(sql string) (Expr, error) {
+	toks, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("expected end of expression, found %s", p.peek())
+	}
+	return e, nil
+}
+
+// --- Token helpers ---
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Pos: p.peek().pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, kw)
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf("expected %s, found %s", kw, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) isSymbol(sym string) bool {
+	tok := p.peek()
+	return tok.kind == tokSymbol && tok.text == sym
+}
+
+func (p *parser) acceptSymbol(sym string) bool {
+	if p.isSymbol(sym) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		return p.errorf("expected '%s', found %s", sym, p.peek())
+	}
+	return nil
+}
+
+// parseIdent accepts a bare (non-reserved) or backquoted identifier.
+func (p *parser) parseIdent(what string) (string, error) {
+	tok := p.peek()
+	switch {
+	case tok.kind == tokQuotedIdent:
+		p.i++
+		return tok.text, nil
+	case tok.kind == tokIdent && !reservedWords[strings.ToUpper(tok.text)]:
+		p.i++
+		return tok.text, nil
+	}
+	return "", p.errorf("expected %s, found %s", what, tok)
+}
+
+// --- Statements ---
+
+func (p *parser) parseStatement() (Statement, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		return nil, p.errorf("expected statement, found %s", tok)
+	}
+	switch strings.ToUpper(tok.text) {
+	case "CREATE":
+		return p.parseCreate()
+	case "DROP":
+		return p.parseDrop()
+	case "ALTER":
+		return p.parseAlter()
+	case "INSERT":
+		return p.parseInsert()
+	case "SELECT":
+		return p.parseSelect()
+	case "UPDATE":
+		return p.parseUpdate()
+	case "DELETE":
+		return p.parseDelete()
+	case "BEGIN", "START":
+		return p.parseBegin()
+	case "COMMIT", "END":
+		p.next()
+		p.acceptTransactionNoise()
+		return &CommitStmt{}, nil
+	case "ROLLBACK":
+		return p.parseRollback()
+	case "SAVEPOINT":
+		p.next()
+		name, err := p.parseIdent("savepoint name")
+		if err != nil {
+			return nil, err
+		}
+		return &SavepointStmt{Name: name}, nil
+	case "RELEASE":
+		p.next()
+		p.acceptKeyword("SAVEPOINT")
+		name, err := p.parseIdent("savepoint name")
+		if err != nil {
+			return nil, err
+		}
+		return &ReleaseStmt{Name: name}, nil
+	case "EXPLAIN":
+		return p.parseExplain()
+	case "VACUUM":
+		p.next()
+		if p.peek().kind == tokEOF || p.isSymbol(";") {
+			return &VacuumStmt{}, nil
+		}
+		name, err := p.parseIdent("table name")
+		if err != nil {
+			return nil, err
+		}
+		return &VacuumStmt{Table: name}, nil
+	}
+	return nil, p.errorf("unknown command '%s'", tok.text)
+}
+
+func (p *parser) parseBegin() (Statement, error) {
+	if p.acceptKeyword("START") {
+		if err := p.expectKeyword("TRANSACTION"); err != nil {
+			return nil, err
+		}
+		return &BeginStmt{}, nil
+	}
+	p.next() // BEGIN
+	p.acceptTransactionNoise()
+	return &BeginStmt{}, nil
+}
+
+func (p *parser) parseRollback() (Statement, error) {
+	p.next() // ROLLBACK
+	p.acceptTransactionNoise()
+	if !p.acceptKeyword("TO") {
+		return &RollbackStmt{}, nil
+	}
+	p.acceptKeyword("SAVEPOINT")
+	name, err := p.parseIdent("savepoint name")
+	if err != nil {
+		return nil, err
+	}
+	return &RollbackStmt{Savepoint: name}, nil
+}
+
+// acceptTransactionNoise skips the optional TRANSACTION or WORK keyword.
+func (p *parser) acceptTransactionNoise() {
+	if !p.acceptKeyword("TRANSACTION") {
+		p.acceptKeyword("WORK")
+	}
+}
+
+func (p *parser) parseExplain() (Statement, error) {
+	p.next() // EXPLAIN
+	stmt := &ExplainStmt{Analyze: p.acceptKeyword("ANALYZE")}
+	if !p.isKeyword("SELECT") {
+		return nil, p.errorf("expected SELECT after EXPLAIN, found %s", p.peek())
+	}
+	query, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Query = query.(*SelectStmt)
+	return stmt, nil
+}
+
+func (p *parser) parseCreate() (Statement, error) {
+	p.next() // CREATE
+	if p.isKeyword("UNIQUE") || p.isKeyword("INDEX") {
+		return p.parseCreateIndex()
+	}
+	if p.acceptKeyword("SEQUENCE") {
+		return p.parseCreateSequence()
+	}
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+
+	stmt := &CreateTableStmt{Name: name}
+	for {
+		col, err := p.parseColumnDef()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, col)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseCreateIndex() (Statement, error) {
+	stmt := &CreateIndexStmt{}
+	stmt.Index.Unique = p.acceptKeyword("UNIQUE")
+	if err := p.expectKeyword("INDEX"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.Index.Name, err = p.parseIdent("index name"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	if stmt.Table, err = p.parseIdent("table name"); err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.parseIdent("column name")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Index.Columns = append(stmt.Index.Columns, col)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseCreateSequence() (Statement, error) {
+	name, err := p.parseIdent("sequence name")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &CreateSequenceStmt{Name: name, Start: 1}
+	if p.acceptKeyword("START") {
+		p.acceptKeyword("WITH")
+		tok := p.peek()
+		if tok.kind != tokNumber {
+			return nil, p.errorf("expected start value, found %s", tok)
+		}
+		p.next()
+		n, err := strconv.Atoi(tok.text)
+		if err != nil || n < 1 || n > math.MaxInt32 {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("invalid start value %s", tok.text)}
+		}
+		stmt.Start = n
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseColumnDef() (ColumnDef, error) {
+	name, err := p.parseIdent("column name")
+	if err != nil {
+		return ColumnDef{}, err
+	}
+	// SERIAL is an auto-incrementing INT
+	serial := p.acceptKeyword("SERIAL")
+	colType := IntType
+	if !serial {
+		if colType, err = p.parseType(); err != nil {
+			return ColumnDef{}, err
+		}
+	}
+	col := ColumnDef{Name: name, Type: colType, IsPrimaryKey: name == "id", AutoIncrement: serial}
+	// Constraints: UNIQUE, PRIMARY KEY, AUTOINCREMENT, DEFAULT expr and
+	// NOT NULL or NULL, in any order
+	nullSpecified := false
+	for {
+		pos := p.peek().pos
+		switch {
+		case p.acceptKeyword("UNIQUE"):
+			col.IsUnique = true
+			continue
+		case p.acceptKeyword("PRIMARY"):
+			if err := p.expectKeyword("KEY"); err != nil {
+				return ColumnDef{}, err
+			}
+			if !col.IsPrimaryKey {
+				return ColumnDef{}, &SyntaxError{Pos: pos, Msg: fmt.Sprintf("column '%s' cannot be the primary key; the primary key is always 'id'", name)}
+			}
+			continue
+		case p.acceptKeyword("AUTOINCREMENT"), p.acceptKeyword("AUTO_INCREMENT"):
+			col.AutoIncrement = true
+			continue
+		case p.acceptKeyword("DEFAULT"):
+			if col.Default != "" {
+				return ColumnDef{}, &SyntaxError{Pos: pos, Msg: fmt.Sprintf("multiple default values specified for column '%s'", name)}
+			}
+			e, err := p.parseAdditive()
+			if err != nil {
+				return ColumnDef{}, err
+			}
+			col.Default = exprString(e)
+			continue
+		case p.acceptKeyword("NULL"):
+		case p.acceptKeyword("NOT"):
+			if err := p.expectKeyword("NULL"); err != nil {
+				return ColumnDef{}, err
+			}
+			col.NotNull = true
+		default:
+			return col, nil
+		}
+		if nullSpecified {
+			return ColumnDef{}, &SyntaxError{Pos: pos, Msg: fmt.Sprintf("conflicting NULL/NOT NULL declarations for column '%s'", name)}
+		}
+		nullSpecified = true
+	}
+}
+
+func (p *parser) parseType() (DbType, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		return 0, p.errorf("expected column type, found %s", tok)
+	}
+	var colType DbType
+	switch strings.ToUpper(tok.text) {
+	case "INT", "INTEGER":
+		colType = IntType
+	case "STRING", "TEXT", "VARCHAR", "CHAR":
+		colType = StringType
+	default:
+		return 0, p.errorf("unknown column type '%s'", tok.text)
+	}
+	p.next()
+
+	// Length modifiers such as VARCHAR(255) are accepted and ignored
+	if p.acceptSymbol("(") {
+		if p.peek().kind != tokNumber {
+			return 0, p.errorf("expected type length, found %s", p.peek())
+		}
+		p.next()
+		if err := p.expectSymbol(")"); err != nil {
+			return 0, err
+		}
+	}
+	return colType, nil
+}
+
+func (p *parser) parseDrop() (Statement, error) {
+	p.next() // DROP
+	if p.acceptKeyword("INDEX") {
+		name, err := p.parseIdent("index name")
+		if err != nil {
+			return nil, err
+		}
+		return &DropIndexStmt{Name: name}, nil
+	}
+	if p.acceptKeyword("SEQUENCE") {
+		name, err := p.parseIdent("sequence name")
+		if err != nil {
+			return nil, err
+		}
+		return &DropSequenceStmt{Name: name}, nil
+	}
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+	return &DropTableStmt{Name: name}, nil
+}
+
+func (p *parser) parseAlter() (Statement, error) {
+	p.next() // ALTER
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &AlterTableStmt{Name: name}
+	switch {
+	case p.acceptKeyword("ADD"):
+		p.acceptKeyword("COLUMN")
+		col, err := p.parseColumnDef()
+		if err != nil {
+			return nil, err
+		}
+		stmt.AddColumn = &col
+	case p.acceptKeyword("RENAME"):
+		if err := p.expectKeyword("TO"); err != nil {
+			return nil, err
+		}
+		if stmt.RenameTo, err = p.parseIdent("table name"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.errorf("expected ADD or RENAME, found %s", p.peek())
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseInsert() (Statement, error) {
+	p.next() // INSERT
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &InsertStmt{Table: name}
+	if p.acceptSymbol("(") {
+		for {
+			col, err := p.parseIdent("column name")
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, col)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.isKeyword("SELECT") {
+		query, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Query = query.(*SelectStmt)
+	} else {
+		if err := p.expectKeyword("VALUES"); err != nil {
+			return nil, err
+		}
+		for {
+			values, err := p.parseValueList()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Rows = append(stmt.Rows, values)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("ON") {
+		if stmt.OnConflict, err = p.parseOnConflict(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseReturning parses an optional "RETURNING item, ..." clause, whose items
+// are those of a select list.
+func (p *parser) parseReturning() ([]SelectItem, error) {
+	if !p.acceptKeyword("RETURNING") {
+		return nil, nil
+	}
+	var items []SelectItem
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if !p.acceptSymbol(",") {
+			return items, nil
+		}
+	}
+}
+
+func (p *parser) parseOnConflict() (*OnConflict, error) {
+	if err := p.expectKeyword("CONFLICT"); err != nil {
+		return nil, err
+	}
+	clause := &OnConflict{}
+	if p.acceptSymbol("(") {
+		for {
+			col, err := p.parseIdent("column name")
+			if err != nil {
+				return nil, err
+			}
+			clause.Columns = append(clause.Columns, col)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("DO"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("NOTHING") {
+		return clause, nil
+	}
+	pos := p.peek().pos
+	if err := p.expectKeyword("UPDATE"); err != nil {
+		return nil, err
+	}
+	if clause.Columns == nil {
+		return nil, &SyntaxError{Pos: pos, Msg: "ON CONFLICT DO UPDATE requires a list of conflict columns"}
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	var err error
+	if clause.Set, err = p.parseAssignments(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if clause.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return clause, nil
+}
+
+// parseValueList parses "(expr, expr, ...)", where DEFAULT may stand in for
+// any expression and is returned as nil.
+func (p *parser) parseValueList() ([]Expr, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var exprs []Expr
+	for {
+		var e Expr
+		if !p.acceptKeyword("DEFAULT") {
+			var err error
+			if e, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		exprs = append(exprs, e)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return exprs, nil
+}
+
+func (p *parser) parseSelect() (Statement, error) {
+	p.next() // SELECT
+	stmt := &SelectStmt{}
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Items = append(stmt.Items, item)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	// Without FROM the select list is evaluated once, and there is nothing to join
+	var err error
+	if p.acceptKeyword("FROM") {
+		if stmt.From, err = p.parseTableRef(); err != nil {
+			return nil, err
+		}
+	}
+
+	for stmt.From.Name != "" {
+		kind, ok, err := p.parseJoinKind()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		table, err := p.parseTableRef()
+		if err != nil {
+			return nil, err
+		}
+		join := JoinClause{Kind: kind, Table: table}
+		if kind != CrossJoin {
+			if err := p.expectKeyword("ON"); err != nil {
+				return nil, err
+			}
+			if join.On, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		stmt.Joins = append(stmt.Joins, join)
+	}
+
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, e)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("HAVING") {
+		if stmt.Having, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := OrderItem{Expr: e}
+			if p.acceptKeyword("DESC") {
+				item.Desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		if stmt.Limit, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("OFFSET") {
+		if stmt.Offset, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseSelectItem() (SelectItem, error) {
+	if p.acceptSymbol("*") {
+		return SelectItem{Star: true}, nil
+	}
+	// t.*
+	if tok := p.peek(); (tok.kind == tokIdent || tok.kind == tokQuotedIdent) && p.i+2 < len(p.toks) &&
+		p.toks[p.i+1].kind == tokSymbol && p.toks[p.i+1].text == "." &&
+		p.toks[p.i+2].kind == tokSymbol && p.toks[p.i+2].text == "*" {
+		p.i += 3
+		return SelectItem{Star: true, StarTable: tok.text}, nil
+	}
+
+	e, err := p.parseExpr()
+	if err != nil {
+		return SelectItem{}, err
+	}
+	item := SelectItem{Expr: e}
+	if p.acceptKeyword("AS") {
+		if item.Alias, err = p.parseIdent("alias"); err != nil {
+			return SelectItem{}, err
+		}
+	} else if tok := p.peek(); tok.kind == tokQuotedIdent || (tok.kind == tokIdent && !reservedWords[strings.ToUpper(tok.text)]) {
+		item.Alias = p.next().text
+	}
+	return item, nil
+}
+
+// parseTableRef parses "name [[AS] alias]".
+func (p *parser) parseTableRef() (TableRef, error) {
+	pos := p.peek().pos
+	name, err := p.parseIdent("table name")
+	if err != nil {
+		return TableRef{}, err
+	}
+	ref := TableRef{Name: name, Pos: pos}
+	if p.acceptKeyword("AS") {
+		if ref.Alias, err = p.parseIdent("table alias"); err != nil {
+			return TableRef{}, err
+		}
+	} else if tok := p.peek(); tok.kind == tokQuotedIdent || (tok.kind == tokIdent && !reservedWords[strings.ToUpper(tok.text)]) {
+		ref.Alias = p.next().text
+	}
+	return ref, nil
+}
+
+// parseJoinKind consumes a join operator if one follows: "," or
+// [INNER | LEFT [OUTER] | RIGHT [OUTER] | FULL [OUTER] | CROSS] JOIN.
+func (p *parser) parseJoinKind() (JoinKind, bool, error) {
+	if p.acceptSymbol(",") {
+		return CrossJoin, true, nil
+	}
+	kind := InnerJoin
+	switch {
+	case p.acceptKeyword("INNER"):
+	case p.acceptKeyword("LEFT"):
+		kind = LeftJoin
+		p.acceptKeyword("OUTER")
+	case p.acceptKeyword("RIGHT"):
+		kind = RightJoin
+		p.acceptKeyword("OUTER")
+	case p.acceptKeyword("FULL"):
+		kind = FullJoin
+		p.acceptKeyword("OUTER")
+	case p.acceptKeyword("CROSS"):
+		kind = CrossJoin
+	case p.isKeyword("JOIN"):
+	default:
+		return 0, false, nil
+	}
+	if err := p.expectKeyword("JOIN"); err != nil {
+		return 0, false, err
+	}
+	return kind, true, nil
+}
+
+func (p *parser) parseUpdate() (Statement, error) {
+	p.next() // UPDATE
+	name, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+
+	stmt := &UpdateStmt{Table: name}
+	if stmt.Set, err = p.parseAssignments(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseAssignments parses "col = expr, ...".
+func (p *parser) parseAssignments() ([]Assignment, error) {
+	var set []Assignment
+	for {
+		col, err := p.parseIdent("column name")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		val, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, Assignment{Column: col, Value: val})
+		if !p.acceptSymbol(",") {
+			return set, nil
+		}
+	}
+}
+
+func (p *parser) parseDelete() (Statement, error) {
+	p.next() // DELETE
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseIdent("table name")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &DeleteStmt{Table: name}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// --- Expressions (lowest to highest precedence) ---
+
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		pos := p.next().pos
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "OR", Left: left, Right: right, Pos: pos}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		pos := p.next().pos
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "AND", Left: left, Right: right, Pos: pos}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.isKeyword("NOT") {
+		pos := p.next().pos
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "NOT", Operand: operand, Pos: pos}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind == tokSymbol {
+		switch tok.text {
+		case "=", "<>", "!=", "<", "<=", ">", ">=":
+			p.next()
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			op := tok.text
+			if op == "!=" {
+				op = "<>"
+			}
+			return &BinaryExpr{Op: op, Left: left, Right: right, Pos: tok.pos}, nil
+		}
+	}
+	if p.isKeyword("BETWEEN") || (p.isKeyword("NOT") && p.toks[p.i+1].kind == tokIdent && strings.EqualFold(p.toks[p.i+1].text, "BETWEEN")) {
+		return p.parseBetween(left)
+	}
+	if p.isKeyword("IS") {
+		pos := p.next().pos
+		op := "IS NULL"
+		if p.acceptKeyword("NOT") {
+			op = "IS NOT NULL"
+		}
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: op, Operand: left, Pos: pos}, nil
+	}
+	return left, nil
+}
+
+// parseBetween parses "[NOT] BETWEEN low AND high" after left, as the
+// equivalent pair of comparisons.
+func (p *parser) parseBetween(left Expr) (Expr, error) {
+	not := p.acceptKeyword("NOT")
+	pos := p.next().pos
+	low, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AND"); err != nil {
+		return nil, err
+	}
+	high, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	var e Expr = &BinaryExpr{
+		Op:    "AND",
+		Left:  &BinaryExpr{Op: ">=", Left: left, Right: low, Pos: pos},
+		Right: &BinaryExpr{Op: "<=", Left: left, Right: high, Pos: pos},
+		Pos:   pos,
+	}
+	if not {
+		e = &UnaryExpr{Op: "NOT", Operand: e, Pos: pos}
+	}
+	return e, nil
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol("+") || p.isSymbol("-") || p.isSymbol("||") {
+		tok := p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: tok.text, Left: left, Right: right, Pos: tok.pos}
+	}
+	return left, nil
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol("*") || p.isSymbol("/") || p.isSymbol("%") {
+		tok := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: tok.text, Left: left, Right: right, Pos: tok.pos}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.isSymbol("-") || p.isSymbol("+") {
+		tok := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// Fold signs into numeric literals so "-5" stays a constant
+		if lit, ok := operand.(*Literal); ok && tok.text == "-" {
+			switch v := lit.Value.(type) {
+			case int:
+				return &Literal{Value: -v, Pos: tok.pos}, nil
+			case float64:
+				return &Literal{Value: -v, Pos: tok.pos}, nil
+			}
+		}
+		return &UnaryExpr{Op: tok.text, Operand: operand, Pos: tok.pos}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		if strings.Contains(tok.text, ".") {
+			f, err := strconv.ParseFloat(tok.text, 64)
+			if err != nil {
+				return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("invalid number %s", tok.text)}
+			}
+			return &Literal{Value: f, Pos: tok.pos}, nil
+		}
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("number %s out of range", tok.text)}
+		}
+		return &Literal{Value: n, Pos: tok.pos}, nil
+
+	case tokString:
+		p.next()
+		return &Literal{Value: tok.text, Pos: tok.pos}, nil
+
+	case tokSymbol:
+		if tok.text == "(" {
+			p.next()
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+
+	case tokIdent, tokQuotedIdent:
+		if tok.kind == tokIdent && strings.EqualFold(tok.text, "NULL") {
+			p.next()
+			return &Literal{Value: nil, Pos: tok.pos}, nil
+		}
+		name, err := p.parseIdent("expression")
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokIdent && p.isSymbol("(") {
+			return p.parseFuncCall(name, tok.pos)
+		}
+		if upper := strings.ToUpper(name); tok.kind == tokIdent && niladicFunctions[upper] {
+			return &FuncCall{Name: upper, Pos: tok.pos}, nil
+		}
+		if p.acceptSymbol(".") {
+			col, err := p.parseIdent("column name")
+			if err != nil {
+				return nil, err
+			}
+			return &ColumnRef{Table: name, Name: col, Pos: tok.pos}, nil
+		}
+		return &ColumnRef{Name: name, Pos: tok.pos}, nil
+	}
+	return nil, p.errorf("expected expression, found %s", tok)
+}
+
+// parseFuncCall parses the argument list of name(...), including the
+// aggregate forms COUNT(*) and FN(DISTINCT expr).
+func (p *parser) parseFuncCall(name string, pos Pos) (Expr, error) {
+	p.next() // (
+	call := &FuncCall{Name: strings.ToUpper(name), Pos: pos}
+	if p.acceptSymbol("*") {
+		call.Star = true
+	} else if !p.isSymbol(")") {
+		call.Distinct = p.acceptKeyword("DISTINCT")
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseExprPrecedence(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string // exprString parenthesises every compound operand
+	}{
+		{"1 + 2 * 3", "1 + (2 * 3)"},
+		{"(1 + 2) * 3", "(1 + 2) * 3"},
+		{"a - b - c", "(a - b) - c"},
+		{"a / b % c", "(a / b) % c"},
+		{"a = 1 OR b = 2 AND c = 3", "(a = 1) OR ((b = 2) AND (c = 3))"},
+		{"(a = 1 OR b = 2) AND c = 3", "((a = 1) OR (b = 2)) AND (c = 3)"},
+		{"NOT a = 1 AND b", "NOT (a = 1) AND b"},
+		{"NOT NOT a", "NOT NOT a"},
+		{"a + 1 < b * 2", "(a + 1) < (b * 2)"},
+		{"a || 'b' = 'xb'", "(a || 'b') = 'xb'"},
+		{"x != 1", "x <> 1"},
+		{"x BETWEEN 1 AND 2 + 3", "(x >= 1) AND (x <= (2 + 3))"},
+		{"x NOT BETWEEN 1 AND 2 OR y", "NOT ((x >= 1) AND (x <= 2)) OR y"},
+		{"a IS NOT NULL AND b IS NULL", "a IS NOT NULL AND b IS NULL"},
+		{"-5 - -x", "-5 - -x"},
+		{"-(1 + 2)", "-(1 + 2)"},
+		{"t.a * 1.50", "t.a * 1.5"},
+		{"`weird col` + 1", "weird col + 1"},
+		{"'it''s'", "'it''s'"},
+		{"null", "NULL"},
+		{"count(*) + sum(DISTINCT x)", "COUNT(*) + SUM(DISTINCT x)"},
+		{"lower(a, 'b')", "LOWER(a, 'b')"},
+	}
+	for _, tt := range tests {
+		e, err := parseExprText(tt.expr)
+		if err != nil {
+			t.Errorf("parsing %q: %v", tt.expr, err)
+			continue
+		}
+		if got := exprString(e); got != tt.want {
+			t.Errorf("parsing %q: got %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []string // Statement types
+	}{
+		{"CREATE TABLE t (id INT PRIMARY KEY, name TEXT)", []string{"CreateTableStmt"}},
+		{"drop table t", []string{"DropTableStmt"}},
+		{"INSERT INTO t VALUES (1, 'a');; SELECT * FROM t;", []string{"InsertStmt", "SelectStmt"}},
+		{"UPDATE t SET a = a + 1, b = 'x' WHERE id = 1", []string{"UpdateStmt"}},
+		{"DELETE FROM t", []string{"DeleteStmt"}},
+		{"BEGIN; SAVEPOINT s; ROLLBACK TO s; RELEASE s; COMMIT", []string{
+			"BeginStmt", "SavepointStmt", "RollbackStmt", "ReleaseStmt", "CommitStmt"}},
+		{"  ; -- nothing\n", nil},
+	}
+	for _, tt := range tests {
+		stmts, err := Parse(tt.sql)
+		if err != nil {
+			t.Errorf("parsing %q: %v", tt.sql, err)
+			continue
+		}
+		var got []string
+		for _, stmt := range stmts {
+			got = append(got, fmt.Sprintf("%T", stmt)[len("*engine."):])
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parsing %q: got %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestParseSelect(t *testing.T) {
+	stmts, err := Parse("SELECT u.name AS n, age, * FROM users u WHERE age > 30 ORDER BY age DESC, n LIMIT 10 OFFSET 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stmts[0].(*SelectStmt)
+	if len(s.Items) != 3 || exprString(s.Items[0].Expr) != "u.name" || s.Items[0].Alias != "n" ||
+		exprString(s.Items[1].Expr) != "age" || s.Items[1].Alias != "" || !s.Items[2].Star {
+		t.Errorf("select list: got %+v", s.Items)
+	}
+	if s.From.Name != "users" || s.From.Alias != "u" || s.From.Pos != (Pos{1, 33}) {
+		t.Errorf("FROM: got %+v", s.From)
+	}
+	if exprString(s.Where) != "age > 30" {
+		t.Errorf("WHERE: got %s", exprString(s.Where))
+	}
+	if len(s.OrderBy) != 2 || !s.OrderBy[0].Desc || s.OrderBy[1].Desc || exprString(s.OrderBy[1].Expr) != "n" {
+		t.Errorf("ORDER BY: got %+v", s.OrderBy)
+	}
+	if exprString(s.Limit) != "10" || exprString(s.Offset) != "5" {
+		t.Errorf("LIMIT/OFFSET: got %s, %s", exprString(s.Limit), exprString(s.Offset))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELEC * FROM t", "line 1, col 1: unknown command 'SELEC'"},
+		{"42", "line 1, col 1: expected statement, found number 42"},
+		{"SELECT * FROM", "line 1, col 14: expected table name, found end of input"},
+		{"SELECT * FROM select", "line 1, col 15: expected table name, found 'select'"},
+		{"SELECT a FROM t WHERE", "line 1, col 22: expected expression, found end of input"},
+		{"SELECT (1 + 2 FROM t", "line 1, col 15: expected ')', found 'FROM'"},
+		{"SELECT 1 +", "line 1, col 11: expected expression, found end of input"},
+		{"SELECT * FROM t x y", "line 1, col 19: expected ';' or end of input, found 'y'"},
+		{"SELECT 1;\nINSERT INTO t VALUES (1,", "line 2, col 25: expected expression, found end of input"},
+		{"SELECT 99999999999999999999", "line 1, col 8: number 99999999999999999999 out of range"},
+		{"UPDATE t a = 1", "line 1, col 10: expected SET, found 'a'"},
+		{"SELECT 'abc", "line 1, col 8: unterminated string literal"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.sql)
+		if _, ok := err.(*SyntaxError); !ok || err.Error() != tt.want {
+			t.Errorf("parsing %q: got error %v, want %q", tt.sql, err, tt.want)
+		}
+	}
+}
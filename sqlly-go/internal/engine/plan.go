@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// valueRange is what comparisons with constants ANDed together leave of a
+// column's values.
+type valueRange struct {
+	lo, hi         interface{} // Int or string bounds; nil when unbounded
+	loOpen, hiOpen bool        // Whether the bound itself is excluded
+	mixed          bool        // Compared with constants of more than one type, or neither int nor string
+}
+
+func (r *valueRange) add(op string, v interface{}) {
+	switch v.(type) {
+	case int, string:
+	default:
+		r.mixed = true
+		return
+	}
+	for _, bound := range []interface{}{r.lo, r.hi} {
+		if bound != nil {
+			if _, err := compareValues(bound, v); err != nil {
+				r.mixed = true
+				return
+			}
+		}
+	}
+	switch op {
+	case "=":
+		r.raiseLo(v, false)
+		r.lowerHi(v, false)
+	case ">":
+		r.raiseLo(v, true)
+	case ">=":
+		r.raiseLo(v, false)
+	case "<":
+		r.lowerHi(v, true)
+	case "<=":
+		r.lowerHi(v, false)
+	}
+}
+
+func (r *valueRange) raiseLo(v interface{}, open bool) {
+	if c, _ := compareValues(v, r.lo); r.lo == nil || c > 0 || (c == 0 && open) {
+		r.lo, r.loOpen = v, open
+	}
+}
+
+func (r *valueRange) lowerHi(v interface{}, open bool) {
+	if c, _ := compareValues(v, r.hi); r.hi == nil || c < 0 || (c == 0 && open) {
+		r.hi, r.hiOpen = v, open
+	}
+}
+
+// point reports whether the range holds a single value.
+func (r *valueRange) point() bool {
+	if r.lo == nil || r.hi == nil || r.loOpen || r.hiOpen {
+		return false
+	}
+	c, _ := compareValues(r.lo, r.hi)
+	return c == 0
+}
+
+// fits reports whether the bounds have col's type, so that its index orders
+// them like the comparisons do.
+func (r *valueRange) fits(col ColumnDef) bool {
+	if r.mixed {
+		return false
+	}
+	bound := r.lo
+	if bound == nil {
+		bound = r.hi
+	}
+	_, isInt := bound.(int)
+	return isInt == (col.Type == IntType)
+}
+
+// int32Bounds drops the bounds of an int range that every 32-bit value
+// satisfies, and reports false if a bound left is not a 32-bit value, which
+// the index cannot encode.
+func (r *valueRange) int32Bounds() (valueRange, bool) {
+	b := *r
+	if n, ok := b.lo.(int); ok && n < math.MinInt32 {
+		b.lo = nil
+	}
+	if n, ok := b.hi.(int); ok && n > math.MaxInt32 {
+		b.hi = nil
+	}
+	for _, bound := range []interface{}{b.lo, b.hi} {
+		if n, ok := bound.(int); ok && int(int32(n)) != n {
+			return b, false
+		}
+	}
+	return b, true
+}
+
+// columnRanges returns, by lower-case column name, the ranges that
+// "column <op> constant" conditions (in either operand order, with op one of
+// =, <, <=, > and >=) ANDed together in where leave the columns.
+func columnRanges(where Expr) map[string]*valueRange {
+	ranges := make(map[string]*valueRange)
+	for _, c := range conjuncts(where) {
+		cond, ok := c.(*BinaryExpr)
+		if !ok {
+			continue
+		}
+		op, left, right := cond.Op, cond.Left, cond.Right
+		if _, ok := right.(*ColumnRef); ok {
+			left, right = right, left
+			if flipped, ok := flippedComparisons[op]; ok {
+				op = flipped
+			}
+		}
+		ref, ok := left.(*ColumnRef)
+		if !ok || (op != "=" && flippedComparisons[op] == "") || volatile(right) {
+			continue
+		}
+		v, err := evalConst(right)
+		if err != nil {
+			continue
+		}
+		name := strings.ToLower(ref.Name)
+		if ranges[name] == nil {
+			ranges[name] = &valueRange{}
+		}
+		ranges[name].add(op, v)
+	}
+	return ranges
+}
+
+// flippedComparisons gives the operator that compares the same way with its
+// operands swapped.
+var flippedComparisons = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// idRange returns the bounds that comparisons of id with integer constants
+// ANDed together in where put on id, and whether there are any.
+func idRange(where Expr) (lo, hi int, ok bool) {
+	r := columnRanges(where)["id"]
+	if r == nil || !r.fits(ColumnDef{Type: IntType}) {
+		return 0, 0, false
+	}
+	// Ids are 32-bit; keep the bounds from overflowing when opened up
+	clamp := func(n int) int { return max(min(n, math.MaxInt32+1), math.MinInt32-1) }
+	lo, hi = math.MinInt, math.MaxInt
+	if r.lo != nil {
+		if lo = clamp(r.lo.(int)); r.loOpen {
+			lo++
+		}
+	}
+	if r.hi != nil {
+		if hi = clamp(r.hi.(int)); r.hiOpen {
+			hi--
+		}
+	}
+	return lo, hi, true
+}
+
+// indexPlan is the part of a secondary index to read instead of a whole
+// table: the keys starting with prefix, the encoded values of the leading
+// columns compared for equality, whose next column lies between lo and hi.
+type indexPlan struct {
+	index          *secondaryIndex
+	prefix         []byte
+	lo, hi         []byte // Encoded bounds of the next column; nil when unbounded, though lo excludes NULLs if hi is set
+	loOpen, hiOpen bool
+	conds          []string // The conditions the part stands for, for EXPLAIN
+}
+
+// planIndex picks the secondary index of t that narrows a scan for where the
+// most, preferring equality on more leading columns and then a range on the
+// next one. It returns nil if no index helps.
+func planIndex(t *Table, where Expr) *indexPlan {
+	ranges := columnRanges(where)
+	var best *indexPlan
+	bestScore := 0
+	for i := range t.indexes {
+		p := &indexPlan{index: &t.indexes[i]}
+		score := 0
+		for _, col := range p.index.columns {
+			r := ranges[strings.ToLower(col.Name)]
+			if r == nil || !r.fits(col) {
+				break
+			}
+			if col.Type == IntType {
+				b, ok := r.int32Bounds()
+				if !ok {
+					break
+				}
+				r = &b
+			}
+			if r.lo == nil && r.hi == nil {
+				break
+			}
+			if r.point() {
+				p.prefix = appendKey(p.prefix, col, r.lo)
+				p.conds = append(p.conds, col.Name+" = "+exprString(&Literal{Value: r.lo}))
+				score += 2
+				continue
+			}
+			// NULLs sort first and satisfy no comparison
+			p.lo = []byte{keyNotNull}
+			if r.lo != nil {
+				p.lo, p.loOpen = appendKey(nil, col, r.lo), r.loOpen
+				op := " >= "
+				if r.loOpen {
+					op = " > "
+				}
+				p.conds = append(p.conds, col.Name+op+exprString(&Literal{Value: r.lo}))
+			}
+			if r.hi != nil {
+				p.hi, p.hiOpen = appendKey(nil, col, r.hi), r.hiOpen
+				op := " <= "
+				if r.hiOpen {
+					op = " < "
+				}
+				p.conds = append(p.conds, col.Name+op+exprString(&Literal{Value: r.hi}))
+			}
+			score++
+			break
+		}
+		if score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+// estimate guesses how many of count rows the part of the index holds:
+// one for equality on every column of a unique index, a tenth for equality
+// on fewer, and a third for a range.
+func (p *indexPlan) estimate(count int) int {
+	switch {
+	case p.lo != nil || p.hi != nil:
+		return fraction(count, 3)
+	case p.index.unique && len(p.conds) == len(p.index.columns):
+		return min(count, 1)
+	}
+	return fraction(count, 10)
+}
+
+// start returns the first key that can be in range.
+func (p *indexPlan) start() []byte {
+	return append(append([]byte(nil), p.prefix...), p.lo...)
+}
+
+// check reports whether key is in range, and whether keys after it can be.
+// The encoding of a value is never a prefix of another, so a key holds the
+// bound itself exactly when it starts with the bound.
+func (p *indexPlan) check(key []byte) (in, more bool) {
+	if !bytes.HasPrefix(key, p.prefix) {
+		return false, false
+	}
+	rest := key[len(p.prefix):]
+	if p.lo != nil && (bytes.Compare(rest, p.lo) < 0 || (p.loOpen && bytes.HasPrefix(rest, p.lo))) {
+		return false, true
+	}
+	if p.hi != nil {
+		if bytes.HasPrefix(rest, p.hi) {
+			return !p.hiOpen, !p.hiOpen
+		}
+		if bytes.Compare(rest, p.hi) > 0 {
+			return false, false
+		}
+	}
+	return true, true
+}
+
+// idRangeString renders the bounds returned by idRange as conditions on id.
+func idRangeString(lo, hi int) string {
+	if lo == hi {
+		return fmt.Sprintf("id = %d", lo)
+	}
+	var conds []string
+	if lo > math.MinInt32 || hi == math.MaxInt32 {
+		conds = append(conds, fmt.Sprintf("id >= %d", lo))
+	}
+	if hi < math.MaxInt32 {
+		conds = append(conds, fmt.Sprintf("id <= %d", hi))
+	}
+	return strings.Join(conds, " AND ")
+}
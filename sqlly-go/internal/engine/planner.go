@@ -0,0 +1,488 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// planNode is one operator of a query plan. Opening a node opens its inputs;
+// the rows it returns are then pulled from it one at a time, and it pulls
+// rows from its inputs only as it needs them.
+type planNode interface {
+	// describe returns the node's line in EXPLAIN output and the details
+	// listed under it
+	describe() (string, []string)
+	inputs() []planNode
+	stats() *nodeStats
+	open(snap *snapshot) (rowIter, error)
+}
+
+// rowIter returns the rows of an open node.
+type rowIter interface {
+	// next returns the next row, or nil once there are no more
+	next() ([]interface{}, error)
+	// close releases the node and its inputs
+	close()
+}
+
+// funcIter is a rowIter made of functions.
+type funcIter struct {
+	nextRow func() ([]interface{}, error)
+	done    func()
+}
+
+func (it *funcIter) next() ([]interface{}, error) { return it.nextRow() }
+
+func (it *funcIter) close() {
+	if it.done != nil {
+		it.done()
+	}
+}
+
+// sliceIter returns rows already produced.
+func sliceIter(rows [][]interface{}) rowIter {
+	return &funcIter{nextRow: func() ([]interface{}, error) {
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	}}
+}
+
+// drain reads the remaining rows of it.
+func drain(it rowIter) ([][]interface{}, error) {
+	var rows [][]interface{}
+	for {
+		row, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+// nodeStats holds how many rows the planner expects a node to return each
+// time it runs, and what it actually did, for EXPLAIN ANALYZE.
+type nodeStats struct {
+	estimate int
+	loops    int // Times the node ran; an index lookup runs once per probe
+	rows     int // Rows returned over all loops
+	elapsed  time.Duration
+	timed    bool // Whether to measure elapsed, which costs a clock reading per row
+}
+
+func (s *nodeStats) stats() *nodeStats { return s }
+
+// openNode opens n, counting its loops and the rows it returns and, if timed,
+// the time spent in it.
+func openNode(n planNode, snap *snapshot) (rowIter, error) {
+	s := n.stats()
+	var start time.Time
+	if s.timed {
+		start = time.Now()
+	}
+	it, err := n.open(snap)
+	if s.timed {
+		s.elapsed += time.Since(start)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.loops++
+	return &countedIter{rowIter: it, s: s}, nil
+}
+
+type countedIter struct {
+	rowIter
+	s *nodeStats
+}
+
+func (it *countedIter) next() ([]interface{}, error) {
+	var start time.Time
+	if it.s.timed {
+		start = time.Now()
+	}
+	row, err := it.rowIter.next()
+	if it.s.timed {
+		it.s.elapsed += time.Since(start)
+	}
+	if row != nil {
+		it.s.rows++
+	}
+	return row, err
+}
+
+// timeNodes has n and the nodes under it measure their time.
+func timeNodes(n planNode) {
+	n.stats().timed = true
+	for _, in := range n.inputs() {
+		timeNodes(in)
+	}
+}
+
+// fraction is count/d, but at least one row of a table that has any.
+func fraction(count, d int) int {
+	return min(count, max(count/d, 1))
+}
+
+// selectPlan is a SELECT turned into a tree of operators.
+type selectPlan struct {
+	root    planNode
+	project *projectNode // Holds the result columns
+}
+
+// condition is one conjunct of a WHERE or ON clause, with its column
+// references qualified by table, and the FROM positions of the tables it
+// refers to.
+type condition struct {
+	expr   Expr
+	tables []int
+	placed bool // Already given to a scan or join
+}
+
+// planSelect turns a SELECT into an operator tree. Every expression is
+// compiled here, so that errors surface before any row is read.
+//
+// Conditions on a single table are pushed down into its scan, where they can
+// pick an index (see newScan), unless an outer join NULL-pads the table's
+// rows. A FROM clause of inner and cross joins only is reordered by
+// joinOrder, each condition going to the first join that sees all its
+// tables; otherwise tables are joined as written. Unless the query selects
+// *, scans only return the columns it names. Without FROM the query reads
+// a single row with no columns.
+func (db *Database) planSelect(stmt *SelectStmt) (*selectPlan, error) {
+	if stmt.From.Name == "" {
+		return planWithoutFrom(stmt)
+	}
+	refs := []TableRef{stmt.From}
+	for _, j := range stmt.Joins {
+		refs = append(refs, j.Table)
+	}
+	db.mu.RLock()
+	inputs := make([]joinInput, len(refs))
+	for i, ref := range refs {
+		inputs[i] = joinInput{ref: ref, table: db.Tables[ref.Name]}
+	}
+	db.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, in := range inputs {
+		if in.table == nil {
+			return nil, fmt.Errorf("%w: %s", ErrTableNotFound, in.ref.Name)
+		}
+		name := strings.ToLower(in.ref.RefName())
+		if seen[name] {
+			return nil, errorf(CodeDuplicateAlias, "table name '%s' specified more than once; use an alias", in.ref.RefName())
+		}
+		seen[name] = true
+	}
+
+	// Check each condition against the tables in scope where it is written
+	// before moving it anywhere
+	full := &scope{joined: len(inputs) > 1}
+	ends := make([]int, len(inputs))
+	for i, in := range inputs {
+		full.columns = append(full.columns, tableScope(in.table, in.ref.RefName()).columns...)
+		ends[i] = len(full.columns)
+	}
+	tableOf := func(slot int) int {
+		return sort.SearchInts(ends, slot+1)
+	}
+	on := make([][]*condition, len(stmt.Joins))
+	for i, join := range stmt.Joins {
+		sc := &scope{columns: full.columns[:ends[i+1]], joined: true}
+		if _, err := compilePredicate(join.On, sc); err != nil {
+			return nil, fmt.Errorf("%s ON: %w", join.Kind, err)
+		}
+		on[i] = splitConditions(join.On, sc, tableOf)
+	}
+	if _, err := compilePredicate(stmt.Where, full); err != nil {
+		return nil, err
+	}
+	where := splitConditions(stmt.Where, full, tableOf)
+
+	innerOnly := true
+	nullable := make([]bool, len(inputs))
+	for i, join := range stmt.Joins {
+		if join.Kind == LeftJoin || join.Kind == FullJoin {
+			nullable[i+1] = true
+		}
+		if join.Kind == RightJoin || join.Kind == FullJoin {
+			for k := 0; k <= i; k++ {
+				nullable[k] = true
+			}
+		}
+		if join.Kind != InnerJoin && join.Kind != CrossJoin {
+			innerOnly = false
+		}
+	}
+	preserved := -1
+	for i := len(inputs) - 1; i >= 0; i-- {
+		if !nullable[i] {
+			preserved = i
+		}
+	}
+
+	// Inner joins make ON and WHERE conditions interchangeable. Conditions
+	// on no table at all hold or fail for every row alike, so any table
+	// whose rows all take part will do.
+	pushed := make([][]Expr, len(inputs))
+	push := func(c *condition, i int) {
+		pushed[i] = append(pushed[i], c.expr)
+		c.placed = true
+	}
+	pool := where
+	if innerOnly {
+		for _, conds := range on {
+			pool = append(pool, conds...)
+		}
+	}
+	for _, c := range pool {
+		switch {
+		case len(c.tables) == 0 && preserved >= 0:
+			push(c, preserved)
+		case len(c.tables) == 1 && !nullable[c.tables[0]]:
+			push(c, c.tables[0])
+		}
+	}
+	if !innerOnly {
+		// Rows of the joined table an ON condition rejects are not joined,
+		// whether or not the join keeps the left rows unmatched
+		for i, conds := range on {
+			if kind := stmt.Joins[i].Kind; kind == InnerJoin || kind == LeftJoin {
+				for _, c := range conds {
+					if len(c.tables) == 1 && c.tables[0] == i+1 {
+						push(c, i+1)
+					}
+				}
+			}
+		}
+	}
+
+	needed := referencedColumns(stmt)
+	scans := make([]*scanNode, len(inputs))
+	for i, in := range inputs {
+		var err error
+		if scans[i], err = newScan(in.table, in.ref, andAll(pushed[i]), needed); err != nil {
+			return nil, err
+		}
+		scans[i].estimateRows()
+	}
+
+	order := make([]int, len(inputs))
+	for i := range order {
+		order[i] = i
+	}
+	if innerOnly {
+		order = joinOrder(scans, pool)
+	}
+
+	var root planNode = scans[order[0]]
+	sc := scans[order[0]].sc
+	placed := make([]bool, len(inputs))
+	placed[order[0]] = true
+	for _, i := range order[1:] {
+		placed[i] = true
+		kind := InnerJoin
+		var conds []Expr
+		if innerOnly {
+			for _, c := range pool {
+				if !c.placed && allPlaced(c.tables, placed) {
+					conds = append(conds, c.expr)
+					c.placed = true
+				}
+			}
+		} else {
+			kind = stmt.Joins[i-1].Kind
+			for _, c := range on[i-1] {
+				if !c.placed {
+					conds = append(conds, c.expr)
+				}
+			}
+		}
+		join, err := newJoin(kind, root, sc, scans[i], andAll(conds))
+		if err != nil {
+			return nil, err
+		}
+		root, sc = join, join.sc
+	}
+	if join, ok := root.(*joinNode); ok && !sort.IntsAreSorted(order) {
+		sc = join.restoreOrder(scans, order)
+	}
+
+	var rest []Expr
+	for _, c := range where {
+		if !c.placed {
+			rest = append(rest, c.expr)
+		}
+	}
+	if len(rest) > 0 {
+		filter, err := newFilter(root, sc, andAll(rest))
+		if err != nil {
+			return nil, err
+		}
+		root = filter
+	}
+	return planOutput(stmt, root, sc)
+}
+
+// splitConditions breaks e into its conjuncts, resolving their column
+// references in sc, whose slots tableOf maps to FROM positions.
+func splitConditions(e Expr, sc *scope, tableOf func(slot int) int) []*condition {
+	var conds []*condition
+	for _, c := range conjuncts(e) {
+		cond := &condition{}
+		cond.expr = qualify(c, sc, func(slot int) {
+			t := tableOf(slot)
+			for _, seen := range cond.tables {
+				if seen == t {
+					return
+				}
+			}
+			cond.tables = append(cond.tables, t)
+		})
+		sort.Ints(cond.tables)
+		conds = append(conds, cond)
+	}
+	return conds
+}
+
+// qualify returns a copy of e in which every column reference names its
+// table, so that it resolves the same way in any scope holding the table.
+// It calls found with the slot of each reference in sc.
+func qualify(e Expr, sc *scope, found func(slot int)) Expr {
+	switch e := e.(type) {
+	case *ColumnRef:
+		idx, err := sc.resolve(e)
+		if err != nil {
+			return e
+		}
+		found(idx)
+		return &ColumnRef{Table: sc.columns[idx].Table, Name: sc.columns[idx].Name, Pos: e.Pos}
+	case *BinaryExpr:
+		c := *e
+		c.Left, c.Right = qualify(e.Left, sc, found), qualify(e.Right, sc, found)
+		return &c
+	case *UnaryExpr:
+		c := *e
+		c.Operand = qualify(e.Operand, sc, found)
+		return &c
+	case *FuncCall:
+		c := *e
+		c.Args = make([]Expr, len(e.Args))
+		for i, a := range e.Args {
+			c.Args[i] = qualify(a, sc, found)
+		}
+		return &c
+	}
+	return e
+}
+
+// andAll joins conditions with AND; it returns nil for none.
+func andAll(conds []Expr) Expr {
+	var e Expr
+	for _, c := range conds {
+		if e == nil {
+			e = c
+		} else {
+			e = &BinaryExpr{Op: "AND", Left: e, Right: c}
+		}
+	}
+	return e
+}
+
+func allPlaced(tables []int, placed []bool) bool {
+	for _, t := range tables {
+		if !placed[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// joinOrder orders the tables of an inner join: first the scan expected to
+// return the fewest rows, then each time the smallest of the tables an
+// equality links to those already joined, or of all the rest if none is
+// linked. Ties keep the FROM order.
+func joinOrder(scans []*scanNode, conds []*condition) []int {
+	placed := make([]bool, len(scans))
+	order := make([]int, 0, len(scans))
+	for len(order) < len(scans) {
+		best, bestLinked := -1, false
+		for i, s := range scans {
+			if placed[i] {
+				continue
+			}
+			linked := len(order) > 0 && linksTo(conds, placed, i)
+			if best < 0 || (linked && !bestLinked) || (linked == bestLinked && s.estimate < scans[best].estimate) {
+				best, bestLinked = i, linked
+			}
+		}
+		placed[best] = true
+		order = append(order, best)
+	}
+	return order
+}
+
+// linksTo reports whether an equality condition relates table i to tables
+// already placed and to no others.
+func linksTo(conds []*condition, placed []bool, i int) bool {
+	for _, c := range conds {
+		if cmp, ok := c.expr.(*BinaryExpr); !ok || cmp.Op != "=" || len(c.tables) < 2 {
+			continue
+		}
+		has, others := false, true
+		for _, t := range c.tables {
+			if t == i {
+				has = true
+			} else if !placed[t] {
+				others = false
+			}
+		}
+		if has && others {
+			return true
+		}
+	}
+	return false
+}
+
+// referencedColumns returns the lower-case names of the columns stmt refers
+// to anywhere, or nil if it selects * and so needs every column. Keeping
+// every column of a name keeps unqualified references ambiguous when they
+// were.
+func referencedColumns(stmt *SelectStmt) map[string]bool {
+	names := make(map[string]bool)
+	var walk func(Expr)
+	walk = func(e Expr) {
+		switch e := e.(type) {
+		case *ColumnRef:
+			names[strings.ToLower(e.Name)] = true
+		case *BinaryExpr:
+			walk(e.Left)
+			walk(e.Right)
+		case *UnaryExpr:
+			walk(e.Operand)
+		case *FuncCall:
+			for _, a := range e.Args {
+				walk(a)
+			}
+		}
+	}
+
+	for _, item := range stmt.Items {
+		if item.Star {
+			return nil
+		}
+	}
+	for _, e := range stmt.exprs() {
+		walk(e)
+	}
+	return names
+}
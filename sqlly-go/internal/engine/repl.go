@@ -2,6 +2,8 @@ package engine
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -18,7 +20,12 @@ func (r *Repl) Start() {
 		fmt.Println("\n=================================")
 		fmt.Println("   SQLly REPL READY (Go Version)")
 		fmt.Println(`   Try: INSERT INTO users VALUES (1, "Admin", 99)`)
-		fmt.Println("=================================\n")
+		fmt.Println("=================================")
+		fmt.Println()
+
+		// One session for the whole REPL, so transactions span input lines
+		session := r.Db.NewSession()
+		defer session.Close()
 
 		scanner := bufio.NewScanner(os.Stdin)
 		fmt.Print("SQLly> ")
@@ -33,9 +40,52 @@ func (r *Repl) Start() {
 				break
 			}
 
-			result := r.Db.ExecuteSql(input)
-			fmt.Println(result)
+			// Print query rows as they are produced
+			err := session.ExecuteStream(input, func(res *Result, rows *RowStream) error {
+				if rows == nil {
+					fmt.Println(res.Message)
+					return nil
+				}
+				for rows.Next() {
+					fmt.Println(formatRow(res.Columns, rows.Values()))
+				}
+				// A write with RETURNING still reports what it did
+				if res.Message != "" {
+					fmt.Println(res.Message)
+				}
+				fmt.Println()
+				return nil
+			})
+			if err != nil {
+				fmt.Println(formatError(err))
+			}
 			fmt.Print("SQLly> ")
 		}
 	}()
-}
\ No newline at end of file
+}
+
+// formatRow renders a query row as a JSON object with keys in column order.
+func formatRow(columns []Column, row []interface{}) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, col := range columns {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		k, _ := json.Marshal(col.Name)
+		v, _ := json.Marshal(row[i])
+		sb.Write(k)
+		sb.WriteByte(':')
+		sb.Write(v)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatError(err error) string {
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Sprintf("Syntax error: %s", err.Error())
+	}
+	return fmt.Sprintf("Error: %s", err.Error())
+}
@@ -0,0 +1,63 @@
+package engine
+
+// Column describes one output column of a query.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "int", "float", "string" or "boolean"; empty when unknown (all NULL)
+}
+
+// Result is the outcome of executing one statement. Queries fill Columns and
+// Rows, with each row holding one value per column (int, float64, string,
+// bool or nil for NULL); other statements report a short status Message.
+type Result struct {
+	Columns      []Column        `json:"columns,omitempty"`
+	Rows         [][]interface{} `json:"rows,omitempty"`
+	RowsAffected int             `json:"rowsAffected"`
+	LastInsertId int             `json:"lastInsertId,omitempty"`
+	Message      string          `json:"message,omitempty"`
+	stream       *RowStream      // Rows of a query or RETURNING clause yet to be read
+}
+
+// IsQuery reports whether the result carries a row set.
+func (r *Result) IsQuery() bool {
+	return r.Columns != nil
+}
+
+// RowStream returns the rows of a query one at a time, as its plan produces
+// them (see Session.ExecuteStream).
+type RowStream struct {
+	iter   rowIter
+	row    []interface{}
+	err    error
+	closed bool
+}
+
+// Next moves to the next row. It returns false once the rows run out or
+// producing one fails; Err tells which.
+func (r *RowStream) Next() bool {
+	if r.closed || r.err != nil {
+		return false
+	}
+	r.row, r.err = r.iter.next()
+	return r.row != nil
+}
+
+// Values returns the row Next moved to, one value per column.
+func (r *RowStream) Values() []interface{} {
+	return r.row
+}
+
+// Err returns the error that stopped the query, if any.
+func (r *RowStream) Err() error {
+	return r.err
+}
+
+// Close stops the query; the rows not read yet are never produced.
+func (r *RowStream) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.row = nil
+		r.iter.close()
+	}
+	return nil
+}
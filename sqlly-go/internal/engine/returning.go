@@ -0,0 +1,56 @@
+package engine
+
+// returning is a compiled RETURNING clause. It collects one output row per
+// row a write leaves behind (the old row for DELETE) as the write goes, so
+// that the statement answers with those rows as a query would.
+type returning struct {
+	table   *Table
+	columns []Column
+	exprs   []evalFunc
+	rows    [][]interface{}
+}
+
+// compileReturning compiles items against table, which statements name
+// their target by. It returns nil when there is no RETURNING clause.
+func compileReturning(items []SelectItem, t *Table, table string) (*returning, error) {
+	if items == nil {
+		return nil, nil
+	}
+	sc := tableScope(t, table)
+	cols, exprs, err := compileProjection(items, sc, func(e Expr) (evalFunc, error) {
+		return compileExpr(e, sc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &returning{table: t, columns: cols, exprs: exprs}, nil
+}
+
+// add evaluates the clause for row.
+func (r *returning) add(row *Row) error {
+	if r == nil {
+		return nil
+	}
+	values := rowValues(r.table, row)
+	out := make([]interface{}, len(r.exprs))
+	for i, eval := range r.exprs {
+		v, err := eval(values)
+		if err != nil {
+			return err
+		}
+		out[i] = v
+	}
+	r.rows = append(r.rows, out)
+	return nil
+}
+
+// result returns res with the rows collected, or res itself without a
+// RETURNING clause.
+func (r *returning) result(res *Result) *Result {
+	if r == nil {
+		return res
+	}
+	res.Columns = r.columns
+	res.stream = &RowStream{iter: sliceIter(r.rows)}
+	return res
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReturning(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	mustExec(t, s, "CREATE TABLE t (id SERIAL, name TEXT, n INT DEFAULT 0)")
+
+	res := mustExec(t, s, "INSERT INTO t (name) VALUES ('a'), ('b') RETURNING id, name AS nm, n + 1")[0]
+	wantCols := []Column{{Name: "id", Type: "int"}, {Name: "nm", Type: "string"}, {Name: "n + 1"}} // Computed columns have no declared type
+	if !reflect.DeepEqual(res.Columns, wantCols) {
+		t.Errorf("columns: got %v, want %v", res.Columns, wantCols)
+	}
+	if want := [][]interface{}{{1, "a", 1}, {2, "b", 1}}; !reflect.DeepEqual(res.Rows, want) {
+		t.Errorf("rows: got %v, want %v", res.Rows, want)
+	}
+	if res.RowsAffected != 2 || res.LastInsertId != 2 {
+		t.Errorf("rowsAffected %d, lastInsertId %d: want 2, 2", res.RowsAffected, res.LastInsertId)
+	}
+
+	// UPDATE returns the new values, DELETE the old ones
+	checkRows(t, s, "UPDATE t SET n = n + 5 WHERE id = 2 RETURNING *", [][]interface{}{{2, "b", 5}})
+	checkRows(t, s, "DELETE FROM t WHERE id = 1 RETURNING t.name, n", [][]interface{}{{"a", 0}})
+	checkRows(t, s, "INSERT INTO t (id, name) VALUES (2, 'x') ON CONFLICT (id) DO UPDATE SET n = 9 RETURNING id, name, n",
+		[][]interface{}{{2, "b", 9}})
+	checkRows(t, s, "DELETE FROM t WHERE id = 99 RETURNING id", [][]interface{}{})
+
+	execFails(t, s, "UPDATE t SET n = 1 RETURNING nope", CodeUndefinedColumn)
+	// A RETURNING expression that fails undoes the statement
+	execFails(t, s, "UPDATE t SET n = 1 RETURNING 1 / (id - 2)", CodeDivisionByZero)
+	checkRows(t, s, "SELECT id, name, n FROM t", [][]interface{}{{2, "b", 9}})
+}
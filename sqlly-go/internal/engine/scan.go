@@ -0,0 +1,231 @@
+package engine
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// scanNode reads the rows of a table that satisfy the conditions pushed down
+// to it. Conditions comparing id with integers, such as "id = 5" or
+// "id BETWEEN 100 AND 200", limit the read to a range of the primary key
+// index; failing those, conditions comparing indexed columns with constants
+// limit it to the part of the secondary index that narrows it most (see
+// planIndex). When a join looks the table's rows up through an index
+// instead, lookup takes the place of run.
+type scanNode struct {
+	nodeStats
+	table   *Table
+	ref     TableRef
+	filter  Expr // nil when every row matches
+	pred    func(row []interface{}) (bool, error)
+	byId    bool // Read the ids from lo to hi through the primary key index
+	lo, hi  int
+	index   *indexPlan // Read part of a secondary index instead
+	columns []int      // Schema positions of the columns returned
+	sc      *scope     // Lays out the columns returned
+	probe   *equiKey   // Set when a join looks rows up by this key
+}
+
+// newScan plans reading the rows of t, named as ref, that satisfy filter.
+// Only the columns whose lower-case names are in needed are returned, or all
+// of them when needed is nil.
+func newScan(t *Table, ref TableRef, filter Expr, needed map[string]bool) (*scanNode, error) {
+	name := ref.RefName()
+	pred, err := compilePredicate(filter, tableScope(t, name))
+	if err != nil {
+		return nil, err
+	}
+	s := &scanNode{table: t, ref: ref, filter: filter, pred: pred, sc: &scope{}}
+	for i, col := range t.Schema.Columns {
+		if needed == nil || needed[strings.ToLower(col.Name)] {
+			s.columns = append(s.columns, i)
+			s.sc.columns = append(s.sc.columns, scopeColumn{Table: name, Name: col.Name, Type: col.Type})
+		}
+	}
+
+	if lo, hi, ok := idRange(filter); ok {
+		s.byId, s.lo, s.hi = true, max(lo, math.MinInt32), min(hi, math.MaxInt32)
+	} else {
+		s.index = planIndex(t, filter)
+	}
+	return s, nil
+}
+
+// estimateRows guesses how many rows the scan returns. Conditions other than
+// those the index read covers are taken to keep a third of the rows.
+func (s *scanNode) estimateRows() {
+	count := s.table.Count()
+	switch {
+	case s.byId:
+		s.estimate = min(count, max(s.hi-s.lo+1, 0))
+		if s.lo == math.MinInt32 || s.hi == math.MaxInt32 {
+			s.estimate = min(s.estimate, fraction(count, 3))
+		}
+	case s.index != nil:
+		s.estimate = s.index.estimate(count)
+	case s.filter != nil:
+		s.estimate = fraction(count, 3)
+	default:
+		s.estimate = count
+	}
+}
+
+func (s *scanNode) describe() (string, []string) {
+	target := s.table.Schema.Name
+	if s.ref.Alias != "" {
+		target += " " + s.ref.Alias
+	}
+	var line string
+	var details []string
+	switch {
+	case s.probe != nil && s.probe.rightPK:
+		line = "Index Lookup on " + target + " using primary key"
+		details = append(details, "Index Cond: "+exprString(s.probe.cond))
+	case s.probe != nil:
+		line = "Index Lookup on " + target + " using " + s.table.indexOn(s.probe.rightColumn).label()
+		details = append(details, "Index Cond: "+exprString(s.probe.cond))
+	case s.byId:
+		line = "Index Scan on " + target + " using primary key"
+		details = append(details, "Index Cond: "+idRangeString(s.lo, s.hi))
+	case s.index != nil:
+		line = "Index Scan on " + target + " using " + s.index.index.label()
+		details = append(details, "Index Cond: "+strings.Join(s.index.conds, " AND "))
+	default:
+		line = "Seq Scan on " + target
+	}
+	if s.filter != nil {
+		details = append(details, "Filter: "+exprString(s.filter))
+	}
+	if len(s.columns) < len(s.table.Schema.Columns) {
+		names := "none"
+		for i, c := range s.sc.columns {
+			if i == 0 {
+				names = c.Name
+			} else {
+				names += ", " + c.Name
+			}
+		}
+		details = append(details, "Columns: "+names)
+	}
+	return line, details
+}
+
+func (s *scanNode) inputs() []planNode { return nil }
+
+func (s *scanNode) open(snap *snapshot) (rowIter, error) {
+	c := s.cursor(snap)
+	next := func() ([]interface{}, error) {
+		row, err := s.nextMatch(c)
+		if row == nil {
+			return nil, err
+		}
+		return s.value(row), nil
+	}
+	return &funcIter{nextRow: next, done: func() { c.Close() }}, nil
+}
+
+// cursor returns a cursor over the rows the scan reads.
+func (s *scanNode) cursor(snap *snapshot) *Cursor {
+	switch {
+	case s.byId:
+		return s.table.scanRange(snap, s.lo, s.hi)
+	case s.index != nil:
+		return s.table.scanIndexed(snap, s.index)
+	}
+	return s.table.scanRange(snap, math.MinInt32, math.MaxInt32)
+}
+
+// nextMatch moves c to the next row that satisfies the filter and returns
+// it, or nil once there are no more.
+func (s *scanNode) nextMatch(c *Cursor) (*Row, error) {
+	for c.Next() {
+		ok, err := s.pred(rowValues(s.table, c.Row()))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return c.Row(), nil
+		}
+	}
+	return nil, c.Err()
+}
+
+func (s *scanNode) filterRows(candidates []*Row) ([]*Row, error) {
+	var rows []*Row
+	for _, row := range candidates {
+		ok, err := s.pred(rowValues(s.table, row))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// value lays row out by s.sc.
+func (s *scanNode) value(row *Row) []interface{} {
+	vals := make([]interface{}, len(s.columns))
+	for k, c := range s.columns {
+		vals[k] = row.Data[s.table.Schema.Columns[c].Name]
+	}
+	return vals
+}
+
+// lookupBy turns the scan into lookups of the rows matching probe, each
+// expected to return perProbe rows.
+func (s *scanNode) lookupBy(probe *equiKey, perProbe int) {
+	s.probe = probe
+	s.estimate = min(s.table.Count(), max(perProbe, 1))
+}
+
+// lookup returns, for each left row, the rows whose key equals the left side
+// of s.probe for it, looked up through the index on the key's column and
+// then filtered and laid out as run would.
+func (s *scanNode) lookup(snap *snapshot, left [][]interface{}) ([][][]interface{}, error) {
+	var start time.Time
+	if s.timed {
+		start = time.Now()
+	}
+	found, err := probeIndex(left, s.table, *s.probe, snap)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([][][]interface{}, len(left))
+	for i, rows := range found {
+		if rows, err = s.filterRows(rows); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			matches[i] = append(matches[i], s.value(row))
+		}
+		s.rows += len(rows)
+	}
+	s.loops += len(left)
+	if s.timed {
+		s.elapsed += time.Since(start)
+	}
+	return matches, nil
+}
+
+// matchingRows returns the rows of table visible to snap that satisfy where
+// (all of them when nil), read the way a scan reads them (see scanNode). They
+// are all read before any is changed.
+func (db *Database) matchingRows(table *Table, name string, where Expr, snap *snapshot) ([]*Row, error) {
+	s, err := newScan(table, TableRef{Name: name}, where, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := s.cursor(snap)
+	defer c.Close()
+	var rows []*Row
+	for {
+		row, err := s.nextMatch(c)
+		if row == nil {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
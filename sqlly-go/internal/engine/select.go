@@ -0,0 +1,535 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// handleSelect starts a query against the rows visible to snap. Its rows are
+// produced as the result's stream is read.
+func (db *Database) handleSelect(stmt *SelectStmt, snap *snapshot) (*Result, error) {
+	plan, err := db.planSelect(stmt)
+	if err != nil {
+		return nil, err
+	}
+	it, err := openNode(plan.root, snap)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Columns: plan.project.columns, stream: &RowStream{iter: it}}, nil
+}
+
+// planOutput adds to root, whose rows sc lays out, the operators that turn
+// them into the result: grouping for aggregate queries, the select list with
+// HAVING, then ORDER BY, LIMIT and OFFSET.
+func planOutput(stmt *SelectStmt, root planNode, sc *scope) (*selectPlan, error) {
+	compile := func(e Expr) (evalFunc, error) { return compileExpr(e, sc) }
+
+	var groups *groupContext
+	if isAggregateQuery(stmt) {
+		for _, item := range stmt.Items {
+			if item.Star {
+				return nil, errorf(CodeGrouping, "SELECT * cannot be combined with GROUP BY or aggregate functions")
+			}
+		}
+		var err error
+		if groups, err = newGroupContext(sc, stmt.GroupBy); err != nil {
+			return nil, err
+		}
+		compile = groups.compile
+	}
+
+	cols, exprs, err := compileProjection(stmt.Items, sc, compile)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.Name
+	}
+	having := func([]interface{}) (bool, error) { return true, nil }
+	if stmt.Having != nil {
+		eval, err := compile(stmt.Having)
+		if err != nil {
+			return nil, err
+		}
+		having = asPredicate(eval)
+	}
+	orderKeys, err := compileOrderBy(stmt.OrderBy, names, compile)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset, err := limitOffset(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	if groups != nil {
+		root = newAggregate(root, groups)
+	}
+	project := &projectNode{input: root, columns: cols, exprs: exprs, havingExpr: stmt.Having, having: having, orderKeys: orderKeys}
+	project.estimate = root.stats().estimate
+	if stmt.Having != nil {
+		project.estimate = fraction(project.estimate, 3)
+	}
+	root = project
+	if len(orderKeys) > 0 {
+		root = &sortNode{nodeStats: nodeStats{estimate: project.estimate}, input: root, order: stmt.OrderBy, width: len(cols)}
+	}
+	if stmt.Limit != nil || stmt.Offset != nil {
+		n := &limitNode{input: root, limit: limit, offset: offset}
+		n.estimate = max(root.stats().estimate-offset, 0)
+		if limit >= 0 {
+			n.estimate = min(n.estimate, limit)
+		}
+		root = n
+	}
+	return &selectPlan{root: root, project: project}, nil
+}
+
+// filterNode keeps the rows of its input that satisfy a condition.
+type filterNode struct {
+	nodeStats
+	input planNode
+	cond  Expr
+	pred  func(row []interface{}) (bool, error)
+}
+
+// planWithoutFrom plans a SELECT that has no FROM clause.
+func planWithoutFrom(stmt *SelectStmt) (*selectPlan, error) {
+	for _, item := range stmt.Items {
+		if item.Star {
+			return nil, errorf(CodeSyntax, "SELECT * needs a FROM clause")
+		}
+	}
+	var root planNode = &resultNode{nodeStats: nodeStats{estimate: 1}}
+	sc := &scope{}
+	if stmt.Where != nil {
+		filter, err := newFilter(root, sc, stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		root = filter
+	}
+	return planOutput(stmt, root, sc)
+}
+
+// resultNode is the source of a SELECT without FROM: one row with no columns.
+type resultNode struct {
+	nodeStats
+}
+
+func (n *resultNode) describe() (string, []string) { return "Result", nil }
+
+func (n *resultNode) inputs() []planNode { return nil }
+
+func (n *resultNode) open(snap *snapshot) (rowIter, error) {
+	return sliceIter([][]interface{}{{}}), nil
+}
+
+// newFilter plans keeping the rows of input, laid out by sc, that satisfy
+// cond.
+func newFilter(input planNode, sc *scope, cond Expr) (*filterNode, error) {
+	pred, err := compilePredicate(cond, sc)
+	if err != nil {
+		return nil, err
+	}
+	n := &filterNode{input: input, cond: cond, pred: pred}
+	n.estimate = fraction(input.stats().estimate, 3)
+	return n, nil
+}
+
+func (n *filterNode) describe() (string, []string) {
+	return "Filter", []string{"Filter: " + exprString(n.cond)}
+}
+
+func (n *filterNode) inputs() []planNode { return []planNode{n.input} }
+
+func (n *filterNode) open(snap *snapshot) (rowIter, error) {
+	input, err := openNode(n.input, snap)
+	if err != nil {
+		return nil, err
+	}
+	next := func() ([]interface{}, error) {
+		for {
+			row, err := input.next()
+			if row == nil || err != nil {
+				return nil, err
+			}
+			if ok, err := n.pred(row); err != nil || ok {
+				return row, err
+			}
+		}
+	}
+	return &funcIter{nextRow: next, done: input.close}, nil
+}
+
+// projectNode evaluates the select list over the rows of its input that
+// HAVING keeps. When the result is sorted, each output row carries its
+// ORDER BY keys after the select list. Columns whose type is not known from
+// the schema take the type of their first non-NULL value: opening the node
+// reads ahead until each has one or the rows run out, so that the columns
+// are known before any row is returned.
+type projectNode struct {
+	nodeStats
+	input      planNode
+	columns    []Column
+	exprs      []evalFunc
+	havingExpr Expr // nil without HAVING
+	having     func(row []interface{}) (bool, error)
+	orderKeys  []orderKey
+}
+
+func (n *projectNode) describe() (string, []string) {
+	names := make([]string, len(n.columns))
+	for i, col := range n.columns {
+		names[i] = col.Name
+	}
+	details := []string{"Output: " + strings.Join(names, ", ")}
+	if n.havingExpr != nil {
+		details = append(details, "Having: "+exprString(n.havingExpr))
+	}
+	return "Project", details
+}
+
+func (n *projectNode) inputs() []planNode { return []planNode{n.input} }
+
+func (n *projectNode) open(snap *snapshot) (rowIter, error) {
+	input, err := openNode(n.input, snap)
+	if err != nil {
+		return nil, err
+	}
+	project := func() ([]interface{}, error) {
+		for {
+			vals, err := input.next()
+			if vals == nil || err != nil {
+				return nil, err
+			}
+			if ok, err := n.having(vals); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+			row := make([]interface{}, len(n.exprs), len(n.exprs)+len(n.orderKeys))
+			for i, eval := range n.exprs {
+				if row[i], err = eval(vals); err != nil {
+					return nil, err
+				}
+			}
+			projected := row[:len(n.exprs)]
+			for _, key := range n.orderKeys {
+				v, err := key(vals, projected)
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, v)
+			}
+			return row, nil
+		}
+	}
+
+	var ahead [][]interface{}
+	for n.untyped() {
+		row, err := project()
+		if err != nil {
+			input.close()
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		for i, col := range n.columns {
+			if col.Type == "" && row[i] != nil {
+				n.columns[i].Type = typeName(row[i])
+			}
+		}
+		ahead = append(ahead, row)
+	}
+	next := func() ([]interface{}, error) {
+		if len(ahead) > 0 {
+			row := ahead[0]
+			ahead = ahead[1:]
+			return row, nil
+		}
+		return project()
+	}
+	return &funcIter{nextRow: next, done: input.close}, nil
+}
+
+// untyped reports whether a column still has no type.
+func (n *projectNode) untyped() bool {
+	for _, col := range n.columns {
+		if col.Type == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// sortNode orders its input rows by the ORDER BY keys the projection put
+// after the select list, then drops the keys.
+type sortNode struct {
+	nodeStats
+	input planNode
+	order []OrderItem
+	width int // Length of the select list
+}
+
+func (n *sortNode) describe() (string, []string) {
+	keys := make([]string, len(n.order))
+	for i, item := range n.order {
+		keys[i] = exprString(item.Expr)
+		if item.Desc {
+			keys[i] += " DESC"
+		}
+	}
+	return "Sort", []string{"Sort Key: " + strings.Join(keys, ", ")}
+}
+
+func (n *sortNode) inputs() []planNode { return []planNode{n.input} }
+
+func (n *sortNode) open(snap *snapshot) (rowIter, error) {
+	input, err := openNode(n.input, snap)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := drain(input)
+	input.close()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		rows[i], keys[i] = row[:n.width], row[n.width:]
+	}
+	if err := sortRows(rows, keys, n.order); err != nil {
+		return nil, err
+	}
+	return sliceIter(rows), nil
+}
+
+// limitNode applies LIMIT and OFFSET.
+type limitNode struct {
+	nodeStats
+	input  planNode
+	limit  int // -1 when absent
+	offset int
+}
+
+func (n *limitNode) describe() (string, []string) {
+	var details []string
+	if n.limit >= 0 {
+		details = append(details, fmt.Sprintf("Limit: %d", n.limit))
+	}
+	if n.offset > 0 {
+		details = append(details, fmt.Sprintf("Offset: %d", n.offset))
+	}
+	return "Limit", details
+}
+
+func (n *limitNode) inputs() []planNode { return []planNode{n.input} }
+
+func (n *limitNode) open(snap *snapshot) (rowIter, error) {
+	input, err := openNode(n.input, snap)
+	if err != nil {
+		return nil, err
+	}
+	skip, left := n.offset, n.limit
+	next := func() ([]interface{}, error) {
+		for ; skip > 0; skip-- {
+			if row, err := input.next(); row == nil || err != nil {
+				return nil, err
+			}
+		}
+		if left == 0 {
+			return nil, nil
+		}
+		left--
+		return input.next()
+	}
+	return &funcIter{nextRow: next, done: input.close}, nil
+}
+
+// compileProjection expands the select list against sc, returning the output
+// columns and one evaluator per output column. "*" and "t.*" expand in scope
+// (schema) order; other items go through compile. Only columns taken straight
+// from the schema get a type here.
+func compileProjection(items []SelectItem, sc *scope, compile func(Expr) (evalFunc, error)) ([]Column, []evalFunc, error) {
+	var cols []Column
+	var exprs []evalFunc
+	for _, item := range items {
+		if item.Star {
+			found := false
+			for i, c := range sc.columns {
+				if item.StarTable != "" && !strings.EqualFold(c.Table, item.StarTable) {
+					continue
+				}
+				idx := i
+				cols = append(cols, Column{Name: sc.outputName(i), Type: c.Type.String()})
+				exprs = append(exprs, func(row []interface{}) (interface{}, error) { return row[idx], nil })
+				found = true
+			}
+			if !found && item.StarTable != "" {
+				return nil, nil, errorf(CodeUndefinedTable, "unknown table '%s' in %s.*", item.StarTable, item.StarTable)
+			}
+			continue
+		}
+
+		eval, err := compile(item.Expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		col := Column{Name: outputName(item, sc)}
+		if ref, ok := item.Expr.(*ColumnRef); ok {
+			if idx, err := sc.resolve(ref); err == nil {
+				col.Type = sc.columns[idx].Type.String()
+			}
+		}
+		cols = append(cols, col)
+		exprs = append(exprs, eval)
+	}
+	return cols, exprs, nil
+}
+
+// outputName is the alias of a select item, or the column name for plain
+// column references (qualified when several tables are in scope), or the
+// expression text otherwise.
+func outputName(item SelectItem, sc *scope) string {
+	if item.Alias != "" {
+		return item.Alias
+	}
+	if ref, ok := item.Expr.(*ColumnRef); ok {
+		if idx, err := sc.resolve(ref); err == nil {
+			return sc.outputName(idx)
+		}
+		return ref.Name
+	}
+	return exprString(item.Expr)
+}
+
+// orderKey evaluates one ORDER BY term for a row, given both the source row
+// and its projected output.
+type orderKey func(src, out []interface{}) (interface{}, error)
+
+// compileOrderBy resolves ORDER BY terms. A positive integer literal selects
+// an output column by position, an unqualified name matching exactly one
+// output column (e.g. an alias) selects that column, and anything else is
+// compiled against the source (or group) row.
+func compileOrderBy(items []OrderItem, names []string, compile func(Expr) (evalFunc, error)) ([]orderKey, error) {
+	keys := make([]orderKey, 0, len(items))
+	for _, item := range items {
+		if lit, ok := item.Expr.(*Literal); ok {
+			pos, isInt := lit.Value.(int)
+			if !isInt || pos < 1 || pos > len(names) {
+				return nil, errorf(CodeInvalidColumnRef, "ORDER BY position %v is not in the select list", lit.Value)
+			}
+			idx := pos - 1
+			keys = append(keys, func(_, out []interface{}) (interface{}, error) { return out[idx], nil })
+			continue
+		}
+
+		if ref, ok := item.Expr.(*ColumnRef); ok && ref.Table == "" {
+			idx := -1
+			matches := 0
+			for i, name := range names {
+				if strings.EqualFold(name, ref.Name) {
+					idx = i
+					matches++
+				}
+			}
+			if matches == 1 {
+				keys = append(keys, func(_, out []interface{}) (interface{}, error) { return out[idx], nil })
+				continue
+			}
+		}
+
+		eval, err := compile(item.Expr)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, func(src, _ []interface{}) (interface{}, error) { return eval(src) })
+	}
+	return keys, nil
+}
+
+// sortRows stably sorts rows by their precomputed ORDER BY keys, comparing
+// numbers numerically and strings lexically; NULLs sort first.
+func sortRows(rows, keys [][]interface{}, order []OrderItem) error {
+	idx := make([]int, len(rows))
+	for i := range idx {
+		idx[i] = i
+	}
+
+	var sortErr error
+	sort.SliceStable(idx, func(a, b int) bool {
+		ka, kb := keys[idx[a]], keys[idx[b]]
+		for i, item := range order {
+			c, err := compareNullsFirst(ka[i], kb[i])
+			if err != nil {
+				if sortErr == nil {
+					sortErr = fmt.Errorf("ORDER BY %s: %w", exprString(item.Expr), err)
+				}
+				return false
+			}
+			if c != 0 {
+				if item.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		return false
+	})
+	if sortErr != nil {
+		return sortErr
+	}
+
+	sorted := make([][]interface{}, len(rows))
+	for i, j := range idx {
+		sorted[i] = rows[j]
+	}
+	copy(rows, sorted)
+	return nil
+}
+
+// compareNullsFirst is compareValues with NULL ordered before any value.
+func compareNullsFirst(a, b interface{}) (int, error) {
+	switch {
+	case a == nil && b == nil:
+		return 0, nil
+	case a == nil:
+		return -1, nil
+	case b == nil:
+		return 1, nil
+	}
+	return compareValues(a, b)
+}
+
+// limitOffset evaluates the LIMIT and OFFSET clauses; limit is -1 when absent.
+func limitOffset(stmt *SelectStmt) (limit, offset int, err error) {
+	limit = -1
+	if stmt.Limit != nil {
+		if limit, err = nonNegativeInt("LIMIT", stmt.Limit); err != nil {
+			return 0, 0, err
+		}
+	}
+	if stmt.Offset != nil {
+		if offset, err = nonNegativeInt("OFFSET", stmt.Offset); err != nil {
+			return 0, 0, err
+		}
+	}
+	return limit, offset, nil
+}
+
+func nonNegativeInt(clause string, e Expr) (int, error) {
+	v, err := evalConst(e)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", clause, err)
+	}
+	n, ok := v.(int)
+	if !ok || n < 0 {
+		return 0, errorf(CodeInvalidParameter, "%s must be a non-negative integer", clause)
+	}
+	return n, nil
+}
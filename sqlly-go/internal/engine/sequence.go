@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+)
+
+// seqCache is how many values a sequence reserves in the catalog at a time,
+// so that it is not rewritten on every call. Values reserved but not handed
+// out before a crash are skipped after it.
+const seqCache = 32
+
+// sequence hands out increasing integers: the values of a CREATE SEQUENCE,
+// or the ids of a table whose id column is AUTOINCREMENT. Every value handed
+// out, or skipped past, is below saved, the value the catalog records for
+// the sequence, so none is handed out twice across restarts.
+type sequence struct {
+	db    *Database
+	next  int
+	saved int
+}
+
+func newSequence(db *Database, start int) *sequence {
+	return &sequence{db: db, next: start, saved: start}
+}
+
+// nextValue returns the next value of the sequence.
+func (s *sequence) nextValue() (interface{}, error) {
+	s.db.seqMu.Lock()
+	defer s.db.seqMu.Unlock()
+	v := s.next
+	if v > math.MaxInt32 {
+		return nil, errorf(CodeSequenceLimit, "sequence reached its maximum value %d", math.MaxInt32)
+	}
+	if err := s.reserve(v); err != nil {
+		return nil, err
+	}
+	s.next = v + 1
+	return v, nil
+}
+
+// skipPast makes the sequence continue after v, which was used without it
+// (an explicit id given for an AUTOINCREMENT column).
+func (s *sequence) skipPast(v int) error {
+	s.db.seqMu.Lock()
+	defer s.db.seqMu.Unlock()
+	if v < s.next {
+		return nil
+	}
+	if err := s.reserve(v); err != nil {
+		return err
+	}
+	s.next = v + 1
+	return nil
+}
+
+// reserve saves the catalog with room past v if v is not below saved yet.
+// The caller must hold db.seqMu.
+func (s *sequence) reserve(v int) error {
+	if v < s.saved {
+		return nil
+	}
+	prev := s.saved
+	s.saved = v + seqCache
+	s.db.mu.RLock()
+	err := s.db.saveCatalog()
+	s.db.mu.RUnlock()
+	if err != nil {
+		s.saved = prev
+		return &Error{Code: CodeIO, Msg: "saving the sequence failed", Err: err}
+	}
+	return nil
+}
+
+// bindSequences points the NEXTVAL calls in stmt at the sequences they name,
+// which must exist.
+func (db *Database) bindSequences(stmt Statement) error {
+	var exprs []Expr
+	switch s := stmt.(type) {
+	case *SelectStmt:
+		exprs = s.exprs()
+	case *ExplainStmt:
+		exprs = s.Query.exprs()
+	case *InsertStmt:
+		for _, values := range s.Rows {
+			exprs = append(exprs, values...)
+		}
+		if s.Query != nil {
+			exprs = append(exprs, s.Query.exprs()...)
+		}
+		if s.OnConflict != nil {
+			exprs = append(exprs, s.OnConflict.Where)
+			for _, set := range s.OnConflict.Set {
+				exprs = append(exprs, set.Value)
+			}
+		}
+		exprs = append(exprs, itemExprs(s.Returning)...)
+	case *UpdateStmt:
+		exprs = append(exprs, s.Where)
+		for _, set := range s.Set {
+			exprs = append(exprs, set.Value)
+		}
+		exprs = append(exprs, itemExprs(s.Returning)...)
+	case *DeleteStmt:
+		exprs = append(exprs, s.Where)
+		exprs = append(exprs, itemExprs(s.Returning)...)
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.bindExprs(exprs)
+}
+
+// bindExprs binds the NEXTVAL calls in exprs. The caller must hold db.mu.
+func (db *Database) bindExprs(exprs []Expr) error {
+	var err error
+	var walk func(Expr)
+	walk = func(e Expr) {
+		switch e := e.(type) {
+		case *BinaryExpr:
+			walk(e.Left)
+			walk(e.Right)
+		case *UnaryExpr:
+			walk(e.Operand)
+		case *FuncCall:
+			for _, a := range e.Args {
+				walk(a)
+			}
+			if e.Name != "NEXTVAL" || len(e.Args) != 1 || err != nil {
+				return
+			}
+			if lit, ok := e.Args[0].(*Literal); ok {
+				if name, ok := lit.Value.(string); ok {
+					if e.seq = db.sequences[name]; e.seq == nil {
+						err = errorf(CodeUndefinedTable, "sequence '%s' does not exist", name)
+					}
+					return
+				}
+			}
+			err = errorf(CodeTypeMismatch, "argument of NEXTVAL must be a sequence name in quotes")
+		}
+	}
+	for _, e := range exprs {
+		walk(e)
+	}
+	return err
+}
+
+func (db *Database) handleCreateSequence(stmt *CreateSequenceStmt) (*Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.sequences[stmt.Name]; exists {
+		return nil, errorf(CodeDuplicateTable, "sequence '%s' already exists", stmt.Name)
+	}
+	db.sequences[stmt.Name] = newSequence(db, stmt.Start)
+	if err := db.saveCatalog(); err != nil {
+		delete(db.sequences, stmt.Name)
+		return nil, &Error{Code: CodeIO, Msg: "sequence not saved", Err: err}
+	}
+	return &Result{Message: fmt.Sprintf("Sequence '%s' created.", stmt.Name)}, nil
+}
+
+func (db *Database) handleDropSequence(stmt *DropSequenceStmt) (*Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.sequences[stmt.Name]; !exists {
+		return nil, errorf(CodeUndefinedTable, "sequence '%s' does not exist", stmt.Name)
+	}
+	delete(db.sequences, stmt.Name)
+	if err := db.saveCatalog(); err != nil {
+		return nil, &Error{Code: CodeIO, Msg: "sequence dropped but catalog not saved", Err: err}
+	}
+	return &Result{Message: fmt.Sprintf("Sequence '%s' dropped.", stmt.Name)}, nil
+}
+
+// saveSequences records where each sequence stands exactly, so that values
+// reserved but not handed out are not skipped after a clean shutdown. The
+// caller must hold db.txMu exclusively.
+func (db *Database) saveSequences() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	seqs := make([]*sequence, 0, len(db.sequences))
+	for _, s := range db.sequences {
+		seqs = append(seqs, s)
+	}
+	for _, t := range db.Tables {
+		if t.autoId != nil {
+			seqs = append(seqs, t.autoId)
+		}
+	}
+	moved := false
+	for _, s := range seqs {
+		if s.saved != s.next {
+			s.saved = s.next
+			moved = true
+		}
+	}
+	if !moved {
+		return nil
+	}
+	return db.saveCatalog()
+}
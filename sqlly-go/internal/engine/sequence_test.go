@@ -0,0 +1,78 @@
+package engine
+
+import "testing"
+
+func TestDefaultsAndSerialIds(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, testOptions())
+	s := db.NewSession()
+	mustExec(t, s, "CREATE TABLE t (id SERIAL, name TEXT DEFAULT 'anon', n INT DEFAULT 1 + 1)")
+
+	res := mustExec(t, s, "INSERT INTO t (name) VALUES ('x')")
+	if res[0].LastInsertId != 1 {
+		t.Errorf("lastInsertId: got %d, want 1", res[0].LastInsertId)
+	}
+	mustExec(t, s, `INSERT INTO t (id, name) VALUES (NULL, DEFAULT);
+		INSERT INTO t (id, name) VALUES (10, 'ten');
+		INSERT INTO t (name) VALUES ('y')`)
+	// An explicit id moves the counter past it
+	checkRows(t, s, "SELECT id, name, n FROM t ORDER BY id",
+		[][]interface{}{{1, "x", 2}, {2, "anon", 2}, {10, "ten", 2}, {11, "y", 2}})
+	mustExec(t, s, "ALTER TABLE t ADD COLUMN m INT DEFAULT 7")
+	checkRows(t, s, "SELECT COUNT(*) FROM t WHERE m = 7", [][]interface{}{{4}})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ids are never handed out twice, even after a crash
+	db = openTestDB(t, dir, testOptions())
+	s = db.NewSession()
+	mustExec(t, s, "INSERT INTO t (name) VALUES ('z')")
+	crash(db)
+	db = openTestDB(t, dir, testOptions())
+	defer db.Close()
+	s = db.NewSession()
+	res = mustExec(t, s, "INSERT INTO t (name) VALUES ('w')")
+	if id := res[0].LastInsertId; id <= 12 {
+		t.Errorf("lastInsertId after a crash: got %d, want more than 12", id)
+	}
+	checkRows(t, s, "SELECT COUNT(*) FROM t", [][]interface{}{{6}})
+}
+
+func TestSequences(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, testOptions())
+	s := db.NewSession()
+	mustExec(t, s, "CREATE SEQUENCE s START WITH 5")
+	checkRows(t, s, "SELECT nextval('s'), nextval('s')", [][]interface{}{{5, 6}})
+	mustExec(t, s, `CREATE TABLE u (id INT PRIMARY KEY DEFAULT nextval('s'), v INT);
+		INSERT INTO u (v) VALUES (1), (2)`)
+	checkRows(t, s, "SELECT id, v FROM u ORDER BY id", [][]interface{}{{7, 1}, {8, 2}})
+	execFails(t, s, "CREATE SEQUENCE s", CodeDuplicateTable)
+	execFails(t, s, "SELECT nextval('nope')", CodeUndefinedTable)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestDB(t, dir, testOptions())
+	defer db.Close()
+	s = db.NewSession()
+	checkRows(t, s, "SELECT nextval('s')", [][]interface{}{{9}})
+	mustExec(t, s, "DROP SEQUENCE s")
+	execFails(t, s, "SELECT nextval('s')", CodeUndefinedTable)
+}
+
+func TestSelectWithoutFrom(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	checkRows(t, s, "SELECT 1 + 2 AS x, 'a' || 'b'", [][]interface{}{{3, "ab"}})
+	checkRows(t, s, "SELECT 1 WHERE 1 = 0", [][]interface{}{})
+	checkRows(t, s, "SELECT COUNT(*)", [][]interface{}{{1}})
+	res := mustExec(t, s, "SELECT 1 AS x")
+	if len(res[0].Columns) != 1 || res[0].Columns[0].Name != "x" {
+		t.Errorf("columns: got %v", res[0].Columns)
+	}
+	execFails(t, s, "SELECT *", CodeSyntax)
+	execFails(t, s, "SELECT name", CodeUndefinedColumn)
+}
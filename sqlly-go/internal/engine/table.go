@@ -1,299 +1,1373 @@
 package engine
 
 import (
+	"bytes"
 	"encoding/binary"
 	// "errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 )
 
+// Table stores the versions of its rows in a paged data file (see page.go)
+// whose pages are cached in a buffer pool. Every record starts with a deleted
+// flag. The rows committed as of the last checkpoint are found through the
+// B+trees of the table's index file (see index.go); the rows changed since
+// have their versions kept in memory until the next checkpoint folds them into
+// the index. Which versions a reader sees is decided by its snapshot (see
+// rowVersion).
 type Table struct {
-	Schema        TableSchema
-	filePath      string
-	PrimaryKeyIdx map[int]int64
-	UniqueIndexes map[string]map[string]struct{}
-	mu            sync.RWMutex // Mutex for thread safety
+	Schema         TableSchema
+	filePath       string
+	file           *pageFile
+	index          *indexFile
+	used           int64                               // Bytes held by records in the data file, live or dead
+	indexes        []secondaryIndex                    // In index file order
+	versions       map[int][]*rowVersion               // Versions of the rows changed since the last checkpoint, oldest first
+	uniqueVersions map[string]map[string][]*rowVersion // Versions in versions holding each key of a unique index, by tree
+	changedGen     uint64                              // Counts the times an id joined or left versions
+	txns           *txManager                          // Nil for a table used outside a database
+	wal            *wal                                // Log recording changes to the data file; nil outside a database
+	autoId         *sequence                           // Hands out ids when id is AUTOINCREMENT
+	renamedFrom    string                              // Old name while a rename may not have moved the files
+	mu             sync.RWMutex                        // Guards the versions and the pages of both files
 }
 
-func NewTable(dbName, tableName string, columns []ColumnDef) *Table {
-	safeDbName := strings.NewReplacer("..", "", "/", "", "\\", "").Replace(dbName)
-	safeTableName := strings.NewReplacer("..", "", "/", "", "\\", "").Replace(tableName)
-
+// NewTable opens (or creates) the data and index files for a table inside the
+// database directory dbDir, caching their pages in pool; a nil pool gives the
+// table one of its own.
+func NewTable(dbDir string, schema TableSchema, pool *BufferPool) (*Table, error) {
 	t := &Table{
-		Schema:        TableSchema{Name: tableName, Columns: columns},
-		filePath:      fmt.Sprintf("%s_%s.db", safeDbName, safeTableName),
-		PrimaryKeyIdx: make(map[int]int64),
-		UniqueIndexes: make(map[string]map[string]struct{}),
+		Schema:   schema,
+		filePath: tableFilePath(dbDir, schema.Name),
+	}
+	t.initIndexes()
+
+	if pool == nil {
+		pool = NewBufferPool(DefaultBufferPoolPages)
+	}
+	var err error
+	if t.file, err = openPageFile(t.filePath, schema.Columns, pool); err != nil {
+		return nil, err
 	}
+	if err := t.openIndex(); err != nil {
+		t.file.discard()
+		return nil, err
+	}
+	return t, nil
+}
+
+// safeName strips path components from user-supplied names used in file names
+func safeName(name string) string {
+	return strings.NewReplacer("..", "", "/", "", "\\", "").Replace(name)
+}
+
+// checkTableName reports whether a table can be created as, or renamed to,
+// name in dbDir: its files must be named after it exactly, so that no two
+// tables share them, and must not exist yet, so that a table never takes over
+// files left behind by another.
+func checkTableName(dbDir, name string) error {
+	if safeName(name) != name {
+		return errorf(CodeInvalidName, "table name '%s' cannot contain '..', '/' or '\\'", name)
+	}
+	for _, path := range []string{tableFilePath(dbDir, name), indexFilePath(dbDir, name)} {
+		if _, err := os.Stat(path); err == nil {
+			return errorf(CodeDuplicateTable, "data file %s already exists", path)
+		} else if !os.IsNotExist(err) {
+			return &Error{Code: CodeIO, Msg: "checking the table files failed", Err: err}
+		}
+	}
+	return nil
+}
+
+func tableFilePath(dbDir, tableName string) string {
+	return filepath.Join(dbDir, safeName(tableName)+".db")
+}
+
+func (t *Table) indexPath() string {
+	return indexFilePath(filepath.Dir(t.filePath), t.Schema.Name)
+}
 
-	// Initialize Unique Sets
+// initIndexes sets up the secondary indexes the schema calls for, the UNIQUE
+// columns other than id first, and forgets every version held in memory.
+func (t *Table) initIndexes() {
+	t.indexes = nil
 	for _, col := range t.Schema.Columns {
 		if col.IsUnique && col.Name != "id" {
-			t.UniqueIndexes[col.Name] = make(map[string]struct{})
+			t.indexes = append(t.indexes, secondaryIndex{tree: "column " + col.Name, columns: []ColumnDef{col}, unique: true})
+		}
+	}
+	for _, def := range t.Schema.Indexes {
+		ix := secondaryIndex{tree: "index " + def.Name, name: def.Name, unique: def.Unique}
+		for _, name := range def.Columns {
+			col, _ := t.column(name)
+			ix.columns = append(ix.columns, col)
 		}
+		t.indexes = append(t.indexes, ix)
 	}
 
-	if _, err := os.Stat(t.filePath); os.IsNotExist(err) {
-		t.initFile()
+	t.versions = make(map[int][]*rowVersion)
+	t.changedGen++
+	t.uniqueVersions = make(map[string]map[string][]*rowVersion)
+	for _, ix := range t.indexes {
+		if ix.unique {
+			t.uniqueVersions[ix.tree] = make(map[string][]*rowVersion)
+		}
 	}
-	t.loadIndex()
-	return t
 }
 
-func (t *Table) initFile() {
-	f, _ := os.Create(t.filePath)
-	f.Close()
+func (t *Table) treeNames() []string {
+	names := make([]string, len(t.indexes))
+	for i, ix := range t.indexes {
+		names[i] = ix.tree
+	}
+	return names
 }
 
 func (t *Table) Drop() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	t.file.discard()
+	t.index.discard()
 	os.Remove(t.filePath)
+	os.Remove(t.indexPath())
 }
 
-// loadIndex reads the entire file to build memory indexes
-func (t *Table) loadIndex() {
+// close writes the table's cached pages back and closes its files.
+func (t *Table) close() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-
-	// Clear indexes
-	t.PrimaryKeyIdx = make(map[int]int64)
-	for k := range t.UniqueIndexes {
-		t.UniqueIndexes[k] = make(map[string]struct{})
+	err := t.file.close()
+	t.index.used = t.used
+	if closeErr := t.index.close(); err == nil {
+		err = closeErr
 	}
+	return err
+}
 
-	f, err := os.Open(t.filePath)
+// openIndex opens the index file, rebuilding it from the data file if it was
+// not closed cleanly.
+func (t *Table) openIndex() error {
+	idx, ok, err := openIndexFile(t.indexPath(), t.treeNames(), t.file.pool)
 	if err != nil {
-		return
+		return err
 	}
-	defer f.Close()
-
-	for {
-		// Get current position
-		pos, _ := f.Seek(0, io.SeekCurrent)
+	t.index = idx
+	if ok {
+		t.used = idx.used
+		return nil
+	}
+	if err := t.rebuildIndex(); err != nil {
+		idx.discard()
+		return err
+	}
+	return nil
+}
 
-		var isDeleted bool
-		if err := binary.Read(f, binary.LittleEndian, &isDeleted); err != nil {
-			break // EOF
-		}
+// rebuildIndex refills the index file, with the indexes the schema now calls
+// for, from the live records of the data file and forgets the versions held
+// in memory; the caller must hold t.mu. It fails if the rows break a unique
+// index.
+func (t *Table) rebuildIndex() error {
+	t.initIndexes()
+	if err := t.index.reset(t.treeNames()); err != nil {
+		return err
+	}
+	t.used = 0
 
-		var id int32
-		if err := binary.Read(f, binary.LittleEndian, &id); err != nil {
-			break
+	for n := uint32(1); n < t.file.pages; n++ {
+		fr, err := t.file.pin(n)
+		if err != nil {
+			return err
 		}
-
-		rowValues := make(map[string]string)
-
-		for _, col := range t.Schema.Columns {
-			if col.Name == "id" {
+		for i := 0; i < fr.data.slotCount(); i++ {
+			record := fr.data.record(i)
+			if record == nil {
 				continue
 			}
-			if col.Type == IntType {
-				var val int32
-				binary.Read(f, binary.LittleEndian, &val)
-				rowValues[col.Name] = fmt.Sprintf("%d", val)
-			} else {
-				val, _ := readString(f)
-				rowValues[col.Name] = val
+			row, isDeleted, err := readRecord(bytes.NewReader(record), t.Schema.Columns)
+			if err != nil {
+				log.Printf("table %s: skipping unreadable record %d on page %d", t.Schema.Name, i, n)
+				continue
 			}
-		}
+			t.used += int64(len(record))
 
-		if !isDeleted {
-			t.PrimaryKeyIdx[int(id)] = pos
-			for k, v := range t.UniqueIndexes {
-				if val, ok := rowValues[k]; ok {
-					v[val] = struct{}{}
+			if !isDeleted {
+				pos, _ := fr.data.slot(i)
+				v := &rowVersion{id: row.Id, offset: int64(n)*pageSize + int64(pos), size: int64(len(record)), keys: t.indexKeys(row)}
+				err := t.checkUnique(v, row)
+				if err == nil {
+					err = t.addToIndex(v)
+				}
+				if err != nil {
+					t.file.unpin(fr, false)
+					return err
 				}
 			}
 		}
+		t.file.unpin(fr, false)
 	}
+	return t.syncLocked()
 }
 
-func (t *Table) Insert(row *Row) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// addToIndex records v, a version every transaction sees, in the index file.
+func (t *Table) addToIndex(v *rowVersion) error {
+	if err := t.index.pk.put(pkKey(v.id), v.offset); err != nil {
+		return err
+	}
+	for tree, key := range v.keys {
+		if err := t.index.secondary[tree].put([]byte(key), int64(v.id)); err != nil {
+			return err
+		}
+	}
+	t.index.rows++
+	t.index.live += v.size
+	return nil
+}
 
-	if _, exists := t.PrimaryKeyIdx[row.Id]; exists {
-		return fmt.Errorf("duplicate Primary Key: %d", row.Id)
+// removeFromIndex forgets v, a version loaded from the index file. A key of a
+// unique index is only removed if no other row has taken it over already.
+func (t *Table) removeFromIndex(v *rowVersion) error {
+	if err := t.index.pk.delete(pkKey(v.id)); err != nil {
+		return err
 	}
+	for tree, key := range v.keys {
+		id, ok, err := t.index.secondary[tree].get([]byte(key))
+		if err == nil && ok && int(id) == v.id {
+			err = t.index.secondary[tree].delete([]byte(key))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	t.index.rows--
+	t.index.live -= v.size
+	return nil
+}
 
-	// Check Unique Constraints
-	for _, col := range t.Schema.Columns {
-		if col.IsUnique && col.Name != "id" {
-			val := fmt.Sprintf("%v", row.Data[col.Name])
-			if idx, ok := t.UniqueIndexes[col.Name]; ok {
-				if _, exists := idx[val]; exists {
-					return fmt.Errorf("violation of UNIQUE constraint on column '%s'. Value '%s' already exists", col.Name, val)
-				}
+// checkUnique reports whether a unique index already holds a key of v, a
+// version of row, when rebuilding the index file.
+func (t *Table) checkUnique(v *rowVersion, row *Row) error {
+	for _, ix := range t.indexes {
+		if !ix.unique {
+			continue
+		}
+		if _, ok, err := t.index.secondary[ix.tree].get([]byte(v.keys[ix.tree])); err != nil || ok {
+			if err == nil {
+				err = ix.duplicate(row)
 			}
+			return err
+		}
+	}
+	return nil
+}
+
+// indexKeys returns row's keys in the secondary indexes, by tree.
+func (t *Table) indexKeys(row *Row) map[string]string {
+	if len(t.indexes) == 0 {
+		return nil
+	}
+	keys := make(map[string]string, len(t.indexes))
+	for _, ix := range t.indexes {
+		keys[ix.tree] = ix.key(row)
+	}
+	return keys
+}
+
+func (t *Table) addVersion(v *rowVersion) {
+	if len(t.versions[v.id]) == 0 {
+		t.changedGen++
+	}
+	t.versions[v.id] = append(t.versions[v.id], v)
+	for tree, key := range v.keys {
+		if holders, ok := t.uniqueVersions[tree]; ok {
+			holders[key] = append(holders[key], v)
+		}
+	}
+}
+
+func (t *Table) removeVersion(v *rowVersion) {
+	if chain := removeFrom(t.versions[v.id], v); len(chain) > 0 {
+		t.versions[v.id] = chain
+	} else {
+		delete(t.versions, v.id)
+		t.changedGen++
+	}
+	for tree, key := range v.keys {
+		byKey, ok := t.uniqueVersions[tree]
+		if !ok {
+			continue
+		}
+		if holders := removeFrom(byKey[key], v); len(holders) > 0 {
+			byKey[key] = holders
+		} else {
+			delete(byKey, key)
 		}
 	}
+}
 
-	f, err := os.OpenFile(t.filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+func removeFrom(vs []*rowVersion, v *rowVersion) []*rowVersion {
+	for i, x := range vs {
+		if x == v {
+			return append(vs[:i:i], vs[i+1:]...)
+		}
+	}
+	return vs
+}
+
+// chain returns the versions of row id, first loading its committed version
+// from the index file if it has none in memory. The caller must hold t.mu
+// exclusively.
+func (t *Table) chain(id int) ([]*rowVersion, error) {
+	if chain, ok := t.versions[id]; ok {
+		return chain, nil
+	}
+	offset, ok, err := t.index.pk.get(pkKey(id))
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	fr, err := t.file.pin(uint32(offset / pageSize))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
+	defer t.file.unpin(fr, false)
+	r := bytes.NewReader(fr.data[offset%pageSize:])
+	row, _, err := readRecord(r, t.Schema.Columns)
+	if err != nil {
+		return nil, fmt.Errorf("reading row %d: %w", id, err)
+	}
+	size := int64(len(fr.data)) - offset%pageSize - int64(r.Len())
+	t.addVersion(&rowVersion{id: id, offset: offset, size: size, keys: t.indexKeys(row), base: true})
+	return t.versions[id], nil
+}
 
-	info, _ := f.Stat()
-	pos := info.Size()
+// visibleVersion returns the version of row id that snap sees, if any. The
+// version of a row with none in memory comes from the primary key index and
+// only gives the record's place; the caller must hold t.mu.
+func (t *Table) visibleVersion(snap *snapshot, id int) (*rowVersion, error) {
+	chain, ok := t.versions[id]
+	if !ok {
+		offset, ok, err := t.index.pk.get(pkKey(id))
+		if err != nil {
+			return nil, fmt.Errorf("looking up row %d: %w", id, err)
+		}
+		if !ok {
+			return nil, nil
+		}
+		return &rowVersion{id: id, offset: offset, base: true}, nil
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if snap.visible(chain[i]) {
+			return chain[i], nil
+		}
+	}
+	return nil, nil
+}
 
-	binary.Write(f, binary.LittleEndian, false) // IsDeleted
-	binary.Write(f, binary.LittleEndian, int32(row.Id))
+// checkWrite reports whether the existing version v stops transaction own
+// from creating another version with the same key. A version deleted by own
+// or by a committed transaction is free; one that is live or being deleted
+// by another running transaction is a conflict.
+func (t *Table) checkWrite(v *rowVersion, own uint64, duplicate error) error {
+	if v.xmax != 0 {
+		if v.xmax == own || !t.txns.isActive(v.xmax) {
+			return nil
+		}
+		return errSerialization
+	}
+	if v.xmin != 0 && v.xmin != own && t.txns.isActive(v.xmin) {
+		return errSerialization
+	}
+	return duplicate
+}
+
+var errSerialization = errorf(CodeSerialization, "could not serialize access due to concurrent update")
 
+func (t *Table) Insert(row *Row) error {
+	return t.insert(0, row, nil)
+}
+
+// insert adds a version of row created by transaction own (0 for an
+// immediately visible row) to the last page, or a new one if it is full. Once
+// the constraints hold, journal (if not nil) is given the version, its slot
+// and the encoded record before the page is changed; an error from it aborts
+// the insert.
+func (t *Table) insert(own uint64, row *Row, journal func(v *rowVersion, slot int, record []byte) error) error {
 	for _, col := range t.Schema.Columns {
-		if col.Name == "id" {
+		if !col.nullable() && row.Data[col.Name] == nil {
+			return errorf(CodeNotNullViolation, "null value in column '%s' violates NOT NULL constraint", col.Name)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	chain, err := t.chain(row.Id)
+	if err != nil {
+		return err
+	}
+	for _, v := range chain {
+		if err := t.checkWrite(v, own, errorf(CodeUniqueViolation, "duplicate Primary Key: %d", row.Id)); err != nil {
+			return err
+		}
+	}
+
+	// Check Unique Constraints
+	keys := t.indexKeys(row)
+	for _, ix := range t.indexes {
+		key := keys[ix.tree]
+		if len(key) > maxKeySize {
+			return errKeyTooLong
+		}
+		if !ix.unique {
 			continue
 		}
-		if col.Type == IntType {
-			val, _ := row.Data[col.Name].(int)
-			binary.Write(f, binary.LittleEndian, int32(val))
-		} else {
-			val, _ := row.Data[col.Name].(string)
-			writeString(f, val)
+		// A committed row holding the key joins the versions checked below
+		if id, ok, err := t.index.secondary[ix.tree].get([]byte(key)); err != nil {
+			return err
+		} else if ok {
+			if _, err := t.chain(int(id)); err != nil {
+				return err
+			}
+		}
+		for _, v := range t.uniqueVersions[ix.tree][key] {
+			if err := t.checkWrite(v, own, ix.duplicate(row)); err != nil {
+				return err
+			}
 		}
 	}
 
-	t.PrimaryKeyIdx[row.Id] = pos
-	for _, col := range t.Schema.Columns {
-		if col.IsUnique && col.Name != "id" {
-			val := fmt.Sprintf("%v", row.Data[col.Name])
-			t.UniqueIndexes[col.Name][val] = struct{}{}
+	var buf bytes.Buffer
+	writeRecord(&buf, t.Schema.Columns, row)
+	record := buf.Bytes()
+	if len(record) > maxRecordSize {
+		return errRowTooBig(len(record))
+	}
+	if err := t.index.markDirty(); err != nil {
+		return err
+	}
+
+	fr, err := t.pageWithRoom(len(record))
+	if err != nil {
+		return err
+	}
+	page := fr.key.page
+	slot, pos := fr.data.slotCount(), fr.data.freeEnd()-len(record)
+	v := &rowVersion{id: row.Id, offset: int64(page)*pageSize + int64(pos), size: int64(len(record)), xmin: own, keys: keys}
+	if journal != nil {
+		if err := journal(v, slot, record); err != nil {
+			t.file.unpin(fr, false)
+			return err
 		}
 	}
+	fr.data.put(slot, pos, record)
+	t.file.unpinLogged(fr, t.wal)
 
+	if page == t.file.pages {
+		t.file.pages++
+	}
+	t.used += v.size
+	t.addVersion(v)
 	return nil
 }
 
+// pageWithRoom pins the last page if a record of size bytes fits in it, or a
+// new page at the end of the file otherwise.
+func (t *Table) pageWithRoom(size int) (*frame, error) {
+	if last := t.file.pages - 1; last > 0 {
+		fr, err := t.file.pin(last)
+		if err != nil {
+			return nil, err
+		}
+		if fr.data.freeSpace() >= size+slotSize {
+			return fr, nil
+		}
+		t.file.unpin(fr, false)
+	}
+	return t.file.pin(t.file.pages)
+}
+
 func (t *Table) Delete(id int) error {
+	return t.delete(t.txns.snapshot(), id, nil)
+}
+
+// delete ends the version of row id that snap sees, on behalf of snap's
+// transaction; outside a transaction the version is dropped at once. journal
+// (if not nil) is given the version before the file is changed; an error from
+// it aborts the delete.
+func (t *Table) delete(snap *snapshot, id int, journal func(v *rowVersion) error) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	offset, exists := t.PrimaryKeyIdx[id]
-	if !exists {
-		return fmt.Errorf("record with ID %d not found", id)
+	if _, err := t.chain(id); err != nil {
+		return err
 	}
-
-	// Remove from Unique Indexes (requires fetching row first, simplified here by skipping purely for brevity,
-	// but logically needed for robustness. In a real rewrite, we fetch, remove from memory, then mark deleted)
-	// We'll proceed to mark file as deleted.
-
-	f, err := os.OpenFile(t.filePath, os.O_RDWR, 0644)
+	v, err := t.visibleVersion(snap, id)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	if v == nil {
+		return errorf(CodeInternal, "record with ID %d not found", id)
+	}
+	if v.xmax != 0 {
+		// Deleted or updated by a transaction this snapshot does not see
+		return errSerialization
+	}
+	if err := t.index.markDirty(); err != nil {
+		return err
+	}
+	if journal != nil {
+		if err := journal(v); err != nil {
+			return err
+		}
+	}
 
-	f.Seek(offset, 0)
-	binary.Write(f, binary.LittleEndian, true) // Mark deleted
+	if err := t.writeFlag(v.offset, true); err != nil { // Mark deleted
+		return err
+	}
+	if snap.own == 0 {
+		t.removeVersion(v)
+		if v.base {
+			return t.removeFromIndex(v)
+		}
+	} else {
+		v.xmax = snap.own
+	}
+	return nil
+}
 
-	delete(t.PrimaryKeyIdx, id)
+// writeFlag sets the deleted flag of the record at offset.
+func (t *Table) writeFlag(offset int64, deleted bool) error {
+	if err := t.index.markDirty(); err != nil {
+		return err
+	}
+	fr, err := t.file.pin(uint32(offset / pageSize))
+	if err != nil {
+		return err
+	}
+	fr.data[offset%pageSize] = 0
+	if deleted {
+		fr.data[offset%pageSize] = 1
+	}
+	t.file.unpinLogged(fr, t.wal)
 	return nil
 }
 
 func (t *Table) Update(row *Row) error {
 	// Simple implementation: Delete then Insert
-	// Note: In a real DB, this needs a transaction to prevent data loss if insert fails.
+	// Note: this loses the row if the insert fails; the engine updates rows
+	// through a transaction, which restores it on rollback.
 	if err := t.Delete(row.Id); err != nil {
 		return err
 	}
 	return t.Insert(row)
 }
 
-func (t *Table) SelectById(id int) *Row {
+// undoInsert discards a version created by a transaction that rolls back.
+func (t *Table) undoInsert(v *rowVersion) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.writeFlag(v.offset, true); err != nil {
+		return err
+	}
+	t.removeVersion(v)
+	return nil
+}
+
+// undoDelete makes a version deleted by a transaction that rolls back live
+// again.
+func (t *Table) undoDelete(v *rowVersion) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.writeFlag(v.offset, false); err != nil {
+		return err
+	}
+	v.xmax = 0
+	return nil
+}
+
+// SelectById returns the latest committed version of row id, or nil if there
+// is none.
+func (t *Table) SelectById(id int) (*Row, error) {
+	return t.selectById(t.txns.snapshot(), id)
+}
+
+func (t *Table) selectById(snap *snapshot, id int) (*Row, error) {
+	rows, err := t.selectByIds(snap, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+// SelectAll returns the latest committed version of every row, holding them
+// all in memory; Scan reads them a batch at a time instead. It fails, rather
+// than return some of the rows, if any of them cannot be read.
+func (t *Table) SelectAll() ([]*Row, error) {
+	var rows []*Row
+	c := t.Scan()
+	defer c.Close()
+	for c.Next() {
+		rows = append(rows, c.Row())
+	}
+	if err := c.Err(); err != nil {
+		return nil, fmt.Errorf("table %s: %w", t.Schema.Name, err)
+	}
+	return rows, nil
+}
+
+// Scan returns a cursor over the latest committed version of every row, in
+// id order. Rows changed while the cursor is open may be seen either way.
+func (t *Table) Scan() *Cursor {
+	return t.scanRange(t.txns.snapshot(), math.MinInt32, math.MaxInt32)
+}
+
+// scanRange returns a cursor over the rows snap sees whose ids lie between lo
+// and hi inclusive.
+func (t *Table) scanRange(snap *snapshot, lo, hi int) *Cursor {
+	lo, hi = max(lo, math.MinInt32), min(hi, math.MaxInt32)
+	return &Cursor{t: t, snap: snap, next: lo, hi: hi, done: lo > hi}
+}
+
+// readRange reads the rows snap sees whose ids lie between lo and hi
+// inclusive, in id order, merging a scan of the primary key index with the
+// rows changed since the last checkpoint, whose ids cache keeps in order
+// across calls. It stops after limit ids and returns the id to carry on from,
+// which is past hi once every id is read.
+func (t *Table) readRange(snap *snapshot, lo, hi, limit int, cache *changedIds) (rows []*Row, next int, err error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	offset, exists := t.PrimaryKeyIdx[id]
-	if !exists {
-		return nil
+	if !cache.valid || cache.gen != t.changedGen {
+		cache.ids = cache.ids[:0]
+		for id := range t.versions {
+			if id >= lo && id <= hi {
+				cache.ids = append(cache.ids, id)
+			}
+		}
+		sort.Ints(cache.ids)
+		cache.gen, cache.valid = t.changedGen, true
 	}
+	changed := cache.ids[sort.SearchInts(cache.ids, lo):]
 
-	f, err := os.Open(t.filePath)
+	var versions []*rowVersion
+	next = hi + 1
+	full := func(id int) bool {
+		if limit == 0 {
+			next = id
+			return true
+		}
+		limit--
+		return false
+	}
+	var readErr error
+	addVisible := func(id int) bool {
+		v, err := t.visibleVersion(snap, id)
+		if err != nil {
+			readErr = err
+			return false
+		}
+		if v != nil {
+			versions = append(versions, v)
+		}
+		return true
+	}
+	stopped := false
+	err = t.index.pk.scan(pkKey(lo), func(key []byte, offset int64) bool {
+		id := pkId(key)
+		if id > hi {
+			return false
+		}
+		for len(changed) > 0 && changed[0] < id {
+			if stopped = full(changed[0]); stopped || !addVisible(changed[0]) {
+				return false
+			}
+			changed = changed[1:]
+		}
+		if stopped = full(id); stopped {
+			return false
+		}
+		if len(changed) > 0 && changed[0] == id {
+			if !addVisible(id) {
+				return false
+			}
+			changed = changed[1:]
+		} else {
+			versions = append(versions, &rowVersion{id: id, offset: offset, base: true})
+		}
+		return true
+	})
 	if err != nil {
-		return nil
+		return nil, next, fmt.Errorf("scanning primary key index: %w", err)
+	}
+	for _, id := range changed {
+		if stopped || full(id) || !addVisible(id) {
+			break
+		}
+	}
+	if readErr != nil {
+		return nil, next, readErr
 	}
-	defer f.Close()
 
-	f.Seek(offset, 0)
+	if rows, err = t.readVersions(versions); err != nil {
+		return nil, next, err
+	}
+	return rows, next, nil
+}
 
-	var isDeleted bool
-	binary.Read(f, binary.LittleEndian, &isDeleted)
-	if isDeleted {
-		return nil
+// scanIndexed returns a cursor over the rows snap sees that may have a key in
+// the part of a secondary index given by plan: those whose committed key is
+// there, and those with a version changed since the last checkpoint whose key
+// is. The caller filters out the rows whose latest values lie elsewhere.
+func (t *Table) scanIndexed(snap *snapshot, plan *indexPlan) *Cursor {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var ids []int
+	err := t.index.secondary[plan.index.tree].scan(plan.start(), func(key []byte, id int64) bool {
+		in, more := plan.check(key)
+		if in {
+			ids = append(ids, int(id))
+		}
+		return more
+	})
+	if err != nil {
+		err = fmt.Errorf("scanning index %s: %w", plan.index.tree, err)
+	}
+	for id, chain := range t.versions {
+		for _, v := range chain {
+			if in, _ := plan.check([]byte(v.keys[plan.index.tree])); in {
+				ids = append(ids, id)
+				break
+			}
+		}
 	}
+	ids = sortedIds(ids)
+	return &Cursor{t: t, snap: snap, ids: ids, byIds: true, done: len(ids) == 0, err: err}
+}
 
-	row := NewRow()
-	var rId int32
-	binary.Read(f, binary.LittleEndian, &rId)
-	row.Id = int(rId)
-	row.Data["id"] = row.Id
+// lookupKeys reads, for each of values, the rows snap sees that may have that
+// value in the leading column of ix, in id order. The result is aligned with
+// values; nil values match no rows. As with scanIndexed, the caller filters
+// out the rows whose latest values differ.
+func (t *Table) lookupKeys(snap *snapshot, ix *secondaryIndex, values []interface{}) ([][]*Row, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 
-	for _, col := range t.Schema.Columns {
-		if col.Name == "id" {
+	// Group the rows changed since the last checkpoint by the values of
+	// their versions, once for all lookups
+	col := ix.columns[0]
+	changed := make(map[string][]int)
+	for id, chain := range t.versions {
+		seen := make(map[string]bool, len(chain))
+		for _, v := range chain {
+			key := v.keys[ix.tree]
+			value := key[:leadingKeyLen(col, key)]
+			if !seen[value] {
+				seen[value] = true
+				changed[value] = append(changed[value], id)
+			}
+		}
+	}
+
+	tree := t.index.secondary[ix.tree]
+	result := make([][]*Row, len(values))
+	for i, v := range values {
+		if v == nil {
 			continue
 		}
-		if col.Type == IntType {
-			var val int32
-			binary.Read(f, binary.LittleEndian, &val)
-			row.Data[col.Name] = int(val)
-		} else {
-			val, _ := readString(f)
-			row.Data[col.Name] = val
+		prefix := appendKey(nil, col, v)
+		var ids []int
+		err := tree.scan(prefix, func(key []byte, id int64) bool {
+			if !bytes.HasPrefix(key, prefix) {
+				return false
+			}
+			ids = append(ids, int(id))
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scanning index %s: %w", ix.tree, err)
+		}
+		ids = append(ids, changed[string(prefix)]...)
+		if result[i], err = t.readVisible(snap, sortedIds(ids)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// readVisible reads the versions of rows ids that snap sees, skipping the
+// rows it sees none of. The caller must hold t.mu.
+func (t *Table) readVisible(snap *snapshot, ids []int) ([]*Row, error) {
+	versions := make([]*rowVersion, 0, len(ids))
+	for _, id := range ids {
+		v, err := t.visibleVersion(snap, id)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			versions = append(versions, v)
 		}
 	}
+	return t.readVersions(versions)
+}
 
-	return row
+// sortedIds sorts ids and removes duplicates.
+func sortedIds(ids []int) []int {
+	sort.Ints(ids)
+	out := ids[:0]
+	for _, id := range ids {
+		if len(out) == 0 || id != out[len(out)-1] {
+			out = append(out, id)
+		}
+	}
+	return out
 }
 
-func (t *Table) SelectAll() []*Row {
+// arbiter finds the unique constraint on exactly the columns cols, in any
+// order, that ON CONFLICT (cols) refers to: the primary key, reported as pk,
+// or the unique index whose tree is returned.
+func (t *Table) arbiter(cols []string) (pk bool, tree string, ok bool) {
+	if len(cols) == 1 && cols[0] == "id" {
+		return true, "", true
+	}
+	for _, ix := range t.indexes {
+		if !ix.unique || len(ix.columns) != len(cols) {
+			continue
+		}
+		match := true
+		for _, col := range ix.columns {
+			match = match && slices.Contains(cols, col.Name)
+		}
+		if match {
+			return false, ix.tree, true
+		}
+	}
+	return false, "", false
+}
+
+// uniqueTrees returns the trees of the table's unique indexes.
+func (t *Table) uniqueTrees() []string {
+	var trees []string
+	for _, ix := range t.indexes {
+		if ix.unique {
+			trees = append(trees, ix.tree)
+		}
+	}
+	return trees
+}
+
+// conflict returns the id of a row snap sees that holds the primary key of
+// row, if pk, or the key of row in one of the unique index trees, and
+// whether there is one.
+func (t *Table) conflict(snap *snapshot, row *Row, pk bool, trees []string) (int, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pk {
+		chain, err := t.chain(row.Id)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, v := range chain {
+			if snap.visible(v) {
+				return row.Id, true, nil
+			}
+		}
+	}
+	keys := t.indexKeys(row)
+	for _, tree := range trees {
+		key := keys[tree]
+		// A committed row holding the key joins the versions checked below
+		if id, ok, err := t.index.secondary[tree].get([]byte(key)); err != nil {
+			return 0, false, err
+		} else if ok {
+			if _, err := t.chain(int(id)); err != nil {
+				return 0, false, err
+			}
+		}
+		for _, v := range t.uniqueVersions[tree][key] {
+			if snap.visible(v) {
+				return v.id, true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// uniqueColumn reports whether a unique index covers col alone.
+func (t *Table) uniqueColumn(col string) bool {
+	for _, ix := range t.indexes {
+		if ix.unique && len(ix.columns) == 1 && strings.EqualFold(ix.columns[0].Name, col) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexOn returns the secondary index whose leading column is col, if any.
+func (t *Table) indexOn(col string) *secondaryIndex {
+	for i := range t.indexes {
+		if strings.EqualFold(t.indexes[i].columns[0].Name, col) {
+			return &t.indexes[i]
+		}
+	}
+	return nil
+}
+
+// SelectByIds looks up the latest committed versions of several rows through
+// the primary key index. The result is aligned with ids; missing rows are
+// nil.
+func (t *Table) SelectByIds(ids []int) ([]*Row, error) {
+	return t.selectByIds(t.txns.snapshot(), ids)
+}
+
+func (t *Table) selectByIds(snap *snapshot, ids []int) ([]*Row, error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	var rows []*Row
-	f, err := os.Open(t.filePath)
-	if err != nil {
-		return rows
+	versions := make([]*rowVersion, len(ids))
+	for i, id := range ids {
+		var err error
+		if versions[i], err = t.visibleVersion(snap, id); err != nil {
+			return nil, err
+		}
 	}
-	defer f.Close()
+	return t.readVersions(versions)
+}
 
-	for {
-		var isDeleted bool
-		if err := binary.Read(f, binary.LittleEndian, &isDeleted); err != nil {
-			break
+// readVersions decodes the records of versions, keeping a page pinned while
+// consecutive versions lie on it. The result is aligned with versions; nil
+// versions give nil rows, and a record that cannot be read fails the call.
+// The caller must hold t.mu.
+func (t *Table) readVersions(versions []*rowVersion) ([]*Row, error) {
+	rows := make([]*Row, len(versions))
+	var fr *frame
+	defer func() {
+		if fr != nil {
+			t.file.unpin(fr, false)
+		}
+	}()
+	for i, v := range versions {
+		if v == nil {
+			continue
+		}
+		page := uint32(v.offset / pageSize)
+		if fr == nil || fr.key.page != page {
+			if fr != nil {
+				t.file.unpin(fr, false)
+				fr = nil
+			}
+			var err error
+			if fr, err = t.file.pin(page); err != nil {
+				return nil, fmt.Errorf("reading row %d: %w", v.id, err)
+			}
+		}
+		row, _, err := readRecord(bytes.NewReader(fr.data[v.offset%pageSize:]), t.Schema.Columns)
+		if err != nil {
+			return nil, fmt.Errorf("reading row %d: %w", v.id, err)
 		}
+		rows[i] = row
+	}
+	return rows, nil
+}
 
-		var id int32
-		if err := binary.Read(f, binary.LittleEndian, &id); err != nil {
-			break
+// sync writes the table's dirty pages back and flushes the data file to
+// stable storage, then does the same for the index file and marks it clean
+// once every version is folded into it.
+func (t *Table) sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.syncLocked()
+}
+
+func (t *Table) syncLocked() error {
+	if t.index.clean {
+		// Neither file has changed since the last sync
+		return nil
+	}
+	if err := t.file.sync(); err != nil {
+		return err
+	}
+	if len(t.versions) > 0 {
+		// The index lacks the versions open transactions still need, so it
+		// stays dirty and is rebuilt if the database is not closed cleanly
+		return nil
+	}
+	t.index.used = t.used
+	return t.index.sync()
+}
+
+// Count returns the number of rows with at least one version; it is exact
+// only when no transaction is running.
+func (t *Table) Count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	count := int(t.index.rows)
+	for _, chain := range t.versions {
+		if !chain[0].base {
+			count++
 		}
+	}
+	return count
+}
 
-		row := NewRow()
-		row.Id = int(id)
-		row.Data["id"] = row.Id
+// vacuum forgets the versions deleted by transactions older than horizon,
+// which no running transaction can see, and marks the versions created by them
+// as visible to all. Rows left with a single such version are folded into the
+// index file and dropped from memory. It returns how many bytes of the data
+// file hold the remaining versions and how many hold records no version refers
+// to.
+func (t *Table) vacuum(horizon uint64) (live, garbage int64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-		for _, col := range t.Schema.Columns {
-			if col.Name == "id" {
+	// Going through the rows in id order keeps the index pages touched in turn
+	ids := make([]int, 0, len(t.versions))
+	for id := range t.versions {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		for _, v := range t.versions[id] {
+			if v.xmax != 0 && v.xmax < horizon {
+				t.removeVersion(v)
+				if v.base {
+					if err := t.removeFromIndex(v); err != nil {
+						return 0, 0, err
+					}
+				}
 				continue
 			}
-			if col.Type == IntType {
-				var val int32
-				binary.Read(f, binary.LittleEndian, &val)
-				row.Data[col.Name] = int(val)
-			} else {
-				val, _ := readString(f)
-				row.Data[col.Name] = val
+			if v.xmin < horizon {
+				v.xmin = 0
+			}
+		}
+		if chain := t.versions[id]; len(chain) == 1 && chain[0].xmin == 0 && chain[0].xmax == 0 {
+			if !chain[0].base {
+				if err := t.addToIndex(chain[0]); err != nil {
+					return 0, 0, err
+				}
+			}
+			t.removeVersion(chain[0])
+		}
+	}
+
+	live = t.index.live
+	for _, chain := range t.versions {
+		for _, v := range chain {
+			if !v.base {
+				live += v.size
+			}
+		}
+	}
+	return live, t.used - live, nil
+}
+
+// compact rewrites the data file without its deleted records. No transaction
+// may be running and the write-ahead log must be empty, as the records move.
+func (t *Table) compact() error {
+	rows, err := t.SelectAll()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.rewrite(t.Schema.Columns, rows); err != nil {
+		return err
+	}
+	return t.rebuildIndex()
+}
+
+// rewrite replaces the data file with one holding rows in the given column
+// layout and reopens it; the caller must hold t.mu and rebuild the index.
+func (t *Table) rewrite(columns []ColumnDef, rows []*Row) error {
+	// The index is only valid for the old file
+	if err := t.index.markDirty(); err != nil {
+		return err
+	}
+	if err := writePageFile(t.filePath, columns, rows); err != nil {
+		return err
+	}
+	t.file.discard()
+	file, err := openPageFile(t.filePath, columns, t.file.pool)
+	if err != nil {
+		return err
+	}
+	t.file = file
+	return nil
+}
+
+// Rename renames the table and moves its data and index files to match.
+// save records the new name in the catalog before the files move, so that
+// they are moved on restart if a crash stops them (see recoverTableFiles).
+func (t *Table) Rename(newName string, save func() error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dir := filepath.Dir(t.filePath)
+	if err := checkTableName(dir, newName); err != nil {
+		return err
+	}
+	oldName, oldPath, oldIndexPath := t.Schema.Name, t.filePath, t.indexPath()
+	t.Schema.Name, t.renamedFrom = newName, oldName
+	if err := save(); err != nil {
+		t.Schema.Name, t.renamedFrom = oldName, ""
+		return err
+	}
+
+	newPath := tableFilePath(dir, newName)
+	err := os.Rename(oldPath, newPath)
+	if err == nil {
+		if err = os.Rename(oldIndexPath, indexFilePath(dir, newName)); err != nil {
+			os.Rename(newPath, oldPath)
+		}
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		// Put the old name back; the catalog already has the new one if
+		// saving again fails, and the files then move on restart
+		t.Schema.Name, t.renamedFrom = oldName, ""
+		save()
+		return err
+	}
+	t.filePath = newPath
+	t.renamedFrom = ""
+	return nil
+}
+
+// AddColumn rewrites the data file with col appended to every row, holding
+// the value fill returns in each existing row. The new file is written next
+// to the old one and indexed, which fails if the new values break a unique
+// index; only then does save record the new schema in the catalog and the
+// new file replace the old, so that a crash leaves the file the catalog
+// describes (see recoverTableFiles).
+func (t *Table) AddColumn(col ColumnDef, fill func() (interface{}, error), save func() error) error {
+	rows, err := t.SelectAll()
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		v, err := fill()
+		if err != nil {
+			return err
+		}
+		if v == nil && !col.nullable() {
+			return errorf(CodeNotNullViolation, "cannot add NOT NULL column '%s' without a default to a table with rows", col.Name)
+		}
+		row.Data[col.Name] = v
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	columns := append(append([]ColumnDef{}, t.Schema.Columns...), col)
+	// The index is only valid for the old file
+	if err := t.index.markDirty(); err != nil {
+		return err
+	}
+	pending := t.filePath + ".new"
+	if err := writePageFile(pending, columns, rows); err != nil {
+		return err
+	}
+	pendingFile, err := openPageFile(pending, columns, t.file.pool)
+	if err != nil {
+		os.Remove(pending)
+		return err
+	}
+	oldFile, oldColumns := t.file, t.Schema.Columns
+	// restore goes back to the old file and schema, leaving the index to be
+	// rebuilt on open if that fails too
+	restore := func() {
+		pendingFile.discard()
+		t.file, t.Schema.Columns = oldFile, oldColumns
+		t.rebuildIndex()
+	}
+
+	t.file, t.Schema.Columns = pendingFile, columns
+	err = t.rebuildIndex()
+	if err == nil {
+		err = save()
+	}
+	if err != nil {
+		restore()
+		os.Remove(pending)
+		return err
+	}
+
+	// The index refers to records by offset, which the rename keeps
+	if err := os.Rename(pending, t.filePath); err != nil {
+		// Keep reading the old file; if saving the old schema fails too, the
+		// new file replaces it on restart
+		restore()
+		if save() == nil {
+			os.Remove(pending)
+		}
+		return err
+	}
+	pendingFile.discard()
+	oldFile.discard()
+	file, err := openPageFile(t.filePath, columns, t.file.pool)
+	if err != nil {
+		return err
+	}
+	t.file = file
+	return syncDir(filepath.Dir(t.filePath))
+}
+
+// CreateIndex adds a secondary index and fills it from the table's rows. No
+// transaction may be running.
+func (t *Table) CreateIndex(def IndexDef) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	indexes := t.Schema.Indexes
+	t.Schema.Indexes = append(indexes[:len(indexes):len(indexes)], def)
+	if err := t.rebuildIndex(); err != nil {
+		t.Schema.Indexes = indexes
+		if rebuildErr := t.rebuildIndex(); rebuildErr != nil {
+			return rebuildErr
+		}
+		return err
+	}
+	return nil
+}
+
+// DropIndex removes the secondary index created as name. No transaction may
+// be running.
+func (t *Table) DropIndex(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var indexes []IndexDef
+	for _, def := range t.Schema.Indexes {
+		if def.Name != name {
+			indexes = append(indexes, def)
+		}
+	}
+	t.Schema.Indexes = indexes
+	return t.rebuildIndex()
+}
+
+// readRecord reads one record written by writeRecord
+func readRecord(r io.Reader, columns []ColumnDef) (*Row, bool, error) {
+	return decodeRecord(r, columns, true)
+}
+
+// decodeRecord reads one record, with a null bitmap after the id unless the
+// record predates them and holds a value for every column. NULL columns are
+// set to nil in the row's data.
+func decodeRecord(r io.Reader, columns []ColumnDef, hasNulls bool) (*Row, bool, error) {
+	var isDeleted bool
+	if err := binary.Read(r, binary.LittleEndian, &isDeleted); err != nil {
+		return nil, false, err
+	}
+	var id int32
+	if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+		return nil, false, err
+	}
+	nulls := make([]byte, nullBitmapSize(columns))
+	if hasNulls {
+		if _, err := io.ReadFull(r, nulls); err != nil {
+			return nil, false, err
+		}
+	}
+
+	row := NewRow()
+	row.Id = int(id)
+	row.Data["id"] = row.Id
+	bit := 0
+	for _, col := range columns {
+		if col.Name == "id" {
+			continue
+		}
+		isNull := nulls[bit/8]&(1<<(bit%8)) != 0
+		bit++
+		if isNull {
+			row.Data[col.Name] = nil
+			continue
+		}
+		if col.Type == IntType {
+			var val int32
+			if err := binary.Read(r, binary.LittleEndian, &val); err != nil {
+				return nil, false, err
+			}
+			row.Data[col.Name] = int(val)
+		} else {
+			val, err := readString(r)
+			if err != nil {
+				return nil, false, err
 			}
+			row.Data[col.Name] = val
 		}
+	}
+	return row, isDeleted, nil
+}
 
-		if !isDeleted {
-			rows = append(rows, row)
+// writeRecord serialises a live row in the on-disk record format:
+//
+//	deleted flag byte | id int32 | null bitmap | values...
+//
+// The bitmap has one bit per column other than id, set when the row holds
+// NULL there (including when the column is missing from row.Data), and only
+// the other columns have their value written.
+func writeRecord(w io.Writer, columns []ColumnDef, row *Row) {
+	binary.Write(w, binary.LittleEndian, false) // IsDeleted
+	binary.Write(w, binary.LittleEndian, int32(row.Id))
+
+	nulls := make([]byte, nullBitmapSize(columns))
+	bit := 0
+	for _, col := range columns {
+		if col.Name == "id" {
+			continue
+		}
+		if row.Data[col.Name] == nil {
+			nulls[bit/8] |= 1 << (bit % 8)
 		}
+		bit++
 	}
-	return rows
+	w.Write(nulls)
+
+	for _, col := range columns {
+		if col.Name == "id" {
+			continue
+		}
+		if row.Data[col.Name] == nil {
+			continue
+		}
+		if col.Type == IntType {
+			val, _ := row.Data[col.Name].(int)
+			binary.Write(w, binary.LittleEndian, int32(val))
+		} else {
+			val, _ := row.Data[col.Name].(string)
+			writeString(w, val)
+		}
+	}
+}
+
+// nullBitmapSize is the number of bytes of a record's null bitmap.
+func nullBitmapSize(columns []ColumnDef) int {
+	n := 0
+	for _, col := range columns {
+		if col.Name != "id" {
+			n++
+		}
+	}
+	return (n + 7) / 8
 }
 
 // Helpers for string I/O (Length-prefixed)
@@ -313,4 +1387,12 @@ func readString(r io.Reader) (string, error) {
 		return "", err
 	}
 	return string(buf), nil
-}
\ No newline at end of file
+}
+func (t *Table) column(name string) (ColumnDef, bool) {
+	for _, col := range t.Schema.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return ColumnDef{}, false
+}
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// openUnreadable returns a database whose table t holds rows 1 to n, none of
+// them in memory, and a function that makes reading the pages of t's data
+// file fail until it is called again.
+func openUnreadable(t *testing.T, n int) (*Database, func()) {
+	t.Helper()
+	dir := t.TempDir()
+	db := openTestDB(t, dir, testOptions())
+	s := db.NewSession()
+	mustExec(t, s, "CREATE TABLE t (id INT PRIMARY KEY, v TEXT)")
+	for i := 1; i <= n; i++ {
+		mustExec(t, s, fmt.Sprintf("INSERT INTO t (id, v) VALUES (%d, 'row')", i))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openTestDB(t, dir, testOptions())
+
+	tbl := db.Tables["t"]
+	closed, err := os.Open(tbl.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	good := tbl.file.f
+	tbl.file.f = closed
+	toggle := func() {
+		tbl.file.f, good = good, tbl.file.f
+	}
+	return db, toggle
+}
+
+func TestUnreadablePageFailsReads(t *testing.T) {
+	db, toggle := openUnreadable(t, 50)
+	s := db.NewSession()
+	for _, sql := range []string{
+		"SELECT COUNT(*) FROM t",
+		"SELECT v FROM t WHERE id = 3",
+		"SELECT v FROM t WHERE id BETWEEN 10 AND 20",
+		"DELETE FROM t WHERE id = 4",
+	} {
+		if _, err := s.Execute(sql); err == nil {
+			t.Errorf("%s: succeeded reading an unreadable page", sql)
+		}
+	}
+	if _, err := db.Tables["t"].SelectById(5); err == nil {
+		t.Error("SelectById succeeded reading an unreadable page")
+	}
+
+	toggle()
+	defer db.Close()
+	checkRows(t, s, "SELECT COUNT(*) FROM t", [][]interface{}{{50}})
+}
+
+func TestUnreadablePageStopsRewrites(t *testing.T) {
+	db, toggle := openUnreadable(t, 50)
+	s := db.NewSession()
+	tbl := db.Tables["t"]
+	if _, err := tbl.SelectAll(); err == nil {
+		t.Error("SelectAll succeeded reading an unreadable page")
+	}
+	db.txMu.Lock()
+	err := tbl.compact()
+	db.txMu.Unlock()
+	if err == nil {
+		t.Error("compact succeeded reading an unreadable page")
+	}
+	if _, err := s.Execute("ALTER TABLE t ADD COLUMN n INT DEFAULT 0"); err == nil {
+		t.Error("ADD COLUMN succeeded reading an unreadable page")
+	}
+
+	// Neither rewrote the data file
+	toggle()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openTestDB(t, db.dir, testOptions())
+	defer db.Close()
+	s = db.NewSession()
+	checkRows(t, s, "SELECT COUNT(*) FROM t", [][]interface{}{{50}})
+	if got := len(db.Tables["t"].Schema.Columns); got != 2 {
+		t.Errorf("table has %d columns, want 2", got)
+	}
+}
+
+func TestAddColumnBreakingUniqueIndex(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, testOptions())
+	s := db.NewSession()
+	mustExec(t, s, "CREATE TABLE t (id INT PRIMARY KEY, v TEXT); INSERT INTO t VALUES (1, 'a'), (2, 'b'), (3, 'c')")
+	want := [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}}
+
+	execFails(t, s, "ALTER TABLE t ADD COLUMN c INT UNIQUE DEFAULT 0", CodeUniqueViolation)
+	checkRows(t, s, "SELECT * FROM t ORDER BY id", want)
+	checkRows(t, s, "SELECT v FROM t WHERE id = 2", [][]interface{}{{"b"}})
+	if _, err := os.Stat(tableFilePath(dir, "t") + ".new"); !os.IsNotExist(err) {
+		t.Errorf("rewritten file left behind: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestDB(t, dir, testOptions())
+	defer db.Close()
+	s = db.NewSession()
+	checkRows(t, s, "SELECT * FROM t ORDER BY id", want)
+	// NULLs never clash
+	mustExec(t, s, "ALTER TABLE t ADD COLUMN c INT UNIQUE")
+	mustExec(t, s, "UPDATE t SET c = id")
+	execFails(t, s, "UPDATE t SET c = 1 WHERE id = 2", CodeUniqueViolation)
+}
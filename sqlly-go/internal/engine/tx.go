@@ -0,0 +1,459 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// Session runs statements for one client and carries its open transaction
+// from one Execute call to the next.
+//
+// Sessions of a database run concurrently under snapshot isolation: a
+// transaction reads the rows committed when it began, plus its own changes.
+// Changing a row that another transaction has changed since then, or is
+// changing, fails with CodeSerialization instead of waiting; the statement is
+// undone and the transaction can be retried. Schema changes wait for every
+// open transaction to end, failing with CodeLockNotAvailable after
+// Options.LockTimeout, and run alone; other statements go on meanwhile. Every
+// statement is atomic; inside a transaction a failed statement is undone on
+// its own and the transaction stays open.
+//
+// Changes are written to the data files as they happen and undone from an
+// in-memory log on rollback. Both are recorded in the database's write-ahead
+// log first, so after a crash recovery keeps exactly the committed
+// transactions.
+type Session struct {
+	db *Database
+	mu sync.Mutex   // Held while the session runs statements or is closed
+	tx *transaction // Open explicit transaction, nil outside one
+}
+
+func (db *Database) NewSession() *Session {
+	return &Session{db: db}
+}
+
+// InTransaction reports whether the session has an open transaction.
+func (s *Session) InTransaction() bool {
+	return s.tx != nil
+}
+
+// Close rolls back the session's open transaction, if any. Closing the
+// database does so for every session.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return nil
+	}
+	s.db.txMu.RLock()
+	defer s.db.txMu.RUnlock()
+	_, err := s.rollback()
+	return err
+}
+
+// endSession closes a session that ran the statements of a single call,
+// which ended with err. The changes of a transaction the statements left open
+// are lost, so the call fails even if every statement succeeded, rather than
+// report writes that were rolled back.
+func (s *Session) endSession(err error) error {
+	if !s.InTransaction() {
+		return err
+	}
+	closeErr := s.Close()
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w (the open transaction was rolled back)", err)
+	case closeErr != nil:
+		return closeErr
+	}
+	return errorf(CodeActiveTransaction, "transaction left open at the end of the statements was rolled back; end it with COMMIT")
+}
+
+// Execute runs every statement in sql and returns one Result per statement.
+// Execution stops at the first failing statement; the results of the
+// statements before it are returned together with the error.
+func (s *Session) Execute(sql string) ([]*Result, error) {
+	var results []*Result
+	err := s.ExecuteStream(sql, func(res *Result, rows *RowStream) error {
+		if rows != nil {
+			res.Rows = [][]interface{}{}
+			for rows.Next() {
+				res.Rows = append(res.Rows, rows.Values())
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+		results = append(results, res)
+		return nil
+	})
+	return results, err
+}
+
+// ExecuteStream runs every statement in sql like Execute, but hands each
+// result to fn as soon as its statement has run instead of collecting them.
+// The result of a query comes without rows: fn reads them from rows, which
+// is nil for other statements, as the query produces them. The query holds
+// its snapshot, and keeps schema changes waiting, until fn returns; rows not
+// read by then are never produced. fn must not use the session. Execution
+// stops at the first failing statement, or when fn returns an error, and
+// that error is returned.
+func (s *Session) ExecuteStream(sql string, fn func(res *Result, rows *RowStream) error) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic executing %q: %v\n%s", sql, r, debug.Stack())
+			err = errorf(CodeInternal, "internal error: %v", r)
+		}
+	}()
+
+	stmts, err := Parse(sql)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range stmts {
+		res, err := s.execute(stmt, fn)
+		if err != nil {
+			return err
+		}
+		if res.stream == nil {
+			if err := fn(res, nil); err != nil {
+				return err
+			}
+		}
+		s.db.maybeCheckpoint()
+	}
+	return nil
+}
+
+// execute runs stmt. A query's rows are handed to fn before the statement
+// ends.
+func (s *Session) execute(stmt Statement, fn func(res *Result, rows *RowStream) error) (res *Result, err error) {
+	// Inside a transaction, statements that run alone are refused below
+	if runsAlone(stmt) && s.tx == nil {
+		if err := s.db.lockAlone(); err != nil {
+			return nil, err
+		}
+		defer s.db.txMu.Unlock()
+	} else {
+		s.db.txMu.RLock()
+		defer s.db.txMu.RUnlock()
+	}
+
+	switch st := stmt.(type) {
+	case *BeginStmt:
+		return s.begin()
+	case *CommitStmt:
+		return s.commit()
+	case *RollbackStmt:
+		if st.Savepoint != "" {
+			return s.rollbackToSavepoint(st.Savepoint)
+		}
+		if s.tx == nil {
+			return nil, errorf(CodeNoActiveTransaction, "there is no transaction in progress")
+		}
+		return s.rollback()
+	case *SavepointStmt:
+		return s.savepoint(st.Name)
+	case *ReleaseStmt:
+		return s.release(st.Name)
+	case *VacuumStmt:
+		if s.tx != nil {
+			return nil, errorf(CodeActiveTransaction, "VACUUM cannot run inside a transaction")
+		}
+		return s.db.handleVacuum(st)
+	case *CreateTableStmt, *DropTableStmt, *AlterTableStmt, *CreateIndexStmt, *DropIndexStmt:
+		if s.tx != nil {
+			return nil, errorf(CodeActiveTransaction, "schema changes cannot run inside a transaction")
+		}
+		// Schema changes move and rewrite table files, which the log
+		// refers to by offset, so start them from an empty log
+		if err := s.db.checkpoint(); err != nil {
+			return nil, &Error{Code: CodeIO, Msg: "checkpoint failed", Err: err}
+		}
+	case *CreateSequenceStmt, *DropSequenceStmt:
+		if s.tx != nil {
+			return nil, errorf(CodeActiveTransaction, "schema changes cannot run inside a transaction")
+		}
+	}
+
+	// Undo whatever a failing statement changed, even if it panics. Outside
+	// a transaction the statement commits on its own.
+	implicit := s.tx == nil
+	tx := s.tx
+	if implicit {
+		tx = s.db.newTransaction()
+	}
+	mark := len(tx.undo)
+	done := false
+	defer func() {
+		if done {
+			return
+		}
+		rbErr := tx.rollbackTo(mark)
+		if rbErr == nil && implicit {
+			rbErr = tx.abort()
+		}
+		if rbErr != nil {
+			err = &Error{Code: CodeIO, Msg: "statement failed and could not be undone", Err: rbErr}
+		}
+	}()
+
+	res, err = s.db.execute(stmt, tx)
+	if err == nil && res.stream != nil {
+		rows := res.stream
+		err = fn(res, rows)
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	if err == nil && implicit {
+		err = tx.commit()
+	}
+	done = err == nil
+	return res, err
+}
+
+func (s *Session) begin() (*Result, error) {
+	if s.tx != nil {
+		return nil, errorf(CodeActiveTransaction, "there is already a transaction in progress")
+	}
+	// Register the transaction so that closing the database can end it
+	s.db.sessMu.Lock()
+	defer s.db.sessMu.Unlock()
+	if s.db.closed {
+		return nil, errorf(CodeIO, "database '%s' is closed", s.db.Name)
+	}
+	s.tx = s.db.newTransaction()
+	s.db.open[s] = true
+	return &Result{Message: "Transaction started."}, nil
+}
+
+func (s *Session) commit() (*Result, error) {
+	if s.tx == nil {
+		return nil, errorf(CodeNoActiveTransaction, "there is no transaction in progress")
+	}
+	if err := s.tx.commit(); err != nil {
+		if _, rbErr := s.rollback(); rbErr != nil {
+			return nil, rbErr
+		}
+		return nil, &Error{Code: CodeIO, Msg: "commit failed; transaction rolled back", Err: err}
+	}
+	s.end()
+	return &Result{Message: "Transaction committed."}, nil
+}
+
+// rollback undoes and ends the open transaction.
+func (s *Session) rollback() (*Result, error) {
+	err := s.tx.rollbackTo(0)
+	if err == nil {
+		err = s.tx.abort()
+	}
+	s.end()
+	if err != nil {
+		return nil, &Error{Code: CodeIO, Msg: "rollback failed", Err: err}
+	}
+	return &Result{Message: "Transaction rolled back."}, nil
+}
+
+// end forgets the session's transaction once it is committed or undone, and
+// wakes the statements waiting to run alone if it was the last one open.
+func (s *Session) end() {
+	s.db.sessMu.Lock()
+	delete(s.db.open, s)
+	if len(s.db.open) == 0 && s.db.idle != nil {
+		close(s.db.idle)
+		s.db.idle = nil
+	}
+	s.db.sessMu.Unlock()
+	s.tx = nil
+}
+
+func (s *Session) savepoint(name string) (*Result, error) {
+	if s.tx == nil {
+		return nil, errorf(CodeNoActiveTransaction, "SAVEPOINT can only be used in transaction blocks")
+	}
+	s.tx.savepoints = append(s.tx.savepoints, savepoint{name: name, undoLen: len(s.tx.undo)})
+	return &Result{Message: fmt.Sprintf("Savepoint '%s' created.", name)}, nil
+}
+
+// rollbackToSavepoint undoes the changes made since the savepoint and forgets
+// the savepoints created after it; the savepoint itself stays usable.
+func (s *Session) rollbackToSavepoint(name string) (*Result, error) {
+	if s.tx == nil {
+		return nil, errorf(CodeNoActiveTransaction, "ROLLBACK TO SAVEPOINT can only be used in transaction blocks")
+	}
+	i, err := s.tx.findSavepoint(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tx.rollbackTo(s.tx.savepoints[i].undoLen); err != nil {
+		return nil, &Error{Code: CodeIO, Msg: "rollback failed", Err: err}
+	}
+	s.tx.savepoints = s.tx.savepoints[:i+1]
+	return &Result{Message: fmt.Sprintf("Rolled back to savepoint '%s'.", name)}, nil
+}
+
+// release forgets the savepoint and those created after it, keeping their
+// changes.
+func (s *Session) release(name string) (*Result, error) {
+	if s.tx == nil {
+		return nil, errorf(CodeNoActiveTransaction, "RELEASE SAVEPOINT can only be used in transaction blocks")
+	}
+	i, err := s.tx.findSavepoint(name)
+	if err != nil {
+		return nil, err
+	}
+	s.tx.savepoints = s.tx.savepoints[:i]
+	return &Result{Message: fmt.Sprintf("Savepoint '%s' released.", name)}, nil
+}
+
+// runsAlone reports whether stmt waits for every open transaction to end and
+// keeps other statements from running while it runs.
+func runsAlone(stmt Statement) bool {
+	switch stmt.(type) {
+	case *CreateTableStmt, *DropTableStmt, *AlterTableStmt, *CreateIndexStmt, *DropIndexStmt, *VacuumStmt,
+		*CreateSequenceStmt, *DropSequenceStmt:
+		return true
+	}
+	return false
+}
+
+// transaction is the snapshot a transaction reads and the undo log of the
+// changes made so far, newest last.
+type transaction struct {
+	id         uint64
+	snap       *snapshot
+	txns       *txManager
+	wal        *wal
+	logged     bool // Something was written to the write-ahead log
+	undo       []undoEntry
+	savepoints []savepoint
+}
+
+func (db *Database) newTransaction() *transaction {
+	id, snap := db.txns.begin()
+	return &transaction{id: id, snap: snap, txns: db.txns, wal: db.wal}
+}
+
+type undoEntry struct {
+	table    *Table
+	inserted bool // The change was an insert (otherwise a delete)
+	v        *rowVersion
+}
+
+type savepoint struct {
+	name    string
+	undoLen int // Length of the undo log when the savepoint was created
+}
+
+// findSavepoint returns the index of the most recent savepoint called name.
+func (tx *transaction) findSavepoint(name string) (int, error) {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+	return -1, errorf(CodeUndefinedSavepoint, "savepoint '%s' does not exist", name)
+}
+
+// log appends rec to the write-ahead log on behalf of the transaction.
+func (tx *transaction) log(rec walRecord) error {
+	rec.txId = tx.id
+	if err := tx.wal.append(rec); err != nil {
+		return &Error{Code: CodeIO, Msg: "writing the log failed", Err: err}
+	}
+	tx.logged = true
+	return nil
+}
+
+// insert logs and records the undo entry before the table writes its file, so
+// a failed write is rolled back like any other change.
+func (tx *transaction) insert(t *Table, row *Row) error {
+	return t.insert(tx.id, row, func(v *rowVersion, slot int, record []byte) error {
+		if err := tx.log(walRecord{op: walInsert, table: t.Schema.Name, offset: v.offset, slot: uint16(slot), data: record}); err != nil {
+			return err
+		}
+		tx.undo = append(tx.undo, undoEntry{table: t, inserted: true, v: v})
+		return nil
+	})
+}
+
+func (tx *transaction) delete(t *Table, id int) error {
+	return t.delete(tx.snap, id, func(v *rowVersion) error {
+		if err := tx.log(walRecord{op: walDelete, table: t.Schema.Name, offset: v.offset}); err != nil {
+			return err
+		}
+		tx.undo = append(tx.undo, undoEntry{table: t, v: v})
+		return nil
+	})
+}
+
+// update replaces a row as a delete followed by an insert; if the insert
+// fails, rolling back restores the deleted row.
+func (tx *transaction) update(t *Table, row *Row) error {
+	if err := tx.delete(t, row.Id); err != nil {
+		return err
+	}
+	return tx.insert(t, row)
+}
+
+// rollbackTo undoes the changes after the first n entries of the log, newest
+// first.
+func (tx *transaction) rollbackTo(n int) error {
+	for len(tx.undo) > n {
+		e := tx.undo[len(tx.undo)-1]
+		rec := walRecord{op: walUndelete, table: e.table.Schema.Name, offset: e.v.offset}
+		if e.inserted {
+			rec.op = walDiscard
+		}
+		if err := tx.log(rec); err != nil {
+			return err
+		}
+
+		var err error
+		if e.inserted {
+			err = e.table.undoInsert(e.v)
+		} else {
+			err = e.table.undoDelete(e.v)
+		}
+		if err != nil {
+			return err
+		}
+		tx.undo = tx.undo[:len(tx.undo)-1]
+	}
+	return nil
+}
+
+// commit makes the transaction's changes durable (subject to the sync
+// policy), then visible to transactions that begin afterwards. Transactions
+// that changed nothing leave no trace in the log.
+func (tx *transaction) commit() error {
+	if tx.logged {
+		if err := tx.wal.append(walRecord{op: walCommit, txId: tx.id}); err != nil {
+			return err
+		}
+		tx.logged = false
+	}
+	tx.txns.end(tx.id)
+	return nil
+}
+
+// abort records that the transaction's changes have all been undone and ends
+// it.
+func (tx *transaction) abort() error {
+	defer tx.txns.end(tx.id)
+	if !tx.logged {
+		return nil
+	}
+	if err := tx.wal.append(walRecord{op: walAbort, txId: tx.id}); err != nil {
+		return err
+	}
+	tx.logged = false
+	return nil
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatementAtomicity(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	mustExec(t, s, `CREATE TABLE t (id INT PRIMARY KEY, n INT NOT NULL);
+		INSERT INTO t VALUES (1, 10), (2, 20)`)
+	want := [][]interface{}{{1, 10}, {2, 20}}
+
+	// A failing row undoes the rows of the statement before it
+	execFails(t, s, "INSERT INTO t VALUES (3, 30), (1, 40)", CodeUniqueViolation)
+	checkRows(t, s, "SELECT id, n FROM t ORDER BY id", want)
+	execFails(t, s, "UPDATE t SET n = n / (id - 2)", CodeDivisionByZero)
+	checkRows(t, s, "SELECT id, n FROM t ORDER BY id", want)
+	execFails(t, s, "DELETE FROM t WHERE 10 / (id - 2) > 0", CodeDivisionByZero)
+	checkRows(t, s, "SELECT id, n FROM t ORDER BY id", want)
+
+	// Inside a transaction only the failed statement is undone
+	mustExec(t, s, "BEGIN; INSERT INTO t VALUES (4, 40)")
+	execFails(t, s, "INSERT INTO t VALUES (5, 50), (6, NULL)", CodeNotNullViolation)
+	if !s.InTransaction() {
+		t.Fatal("failed statement ended the transaction")
+	}
+	mustExec(t, s, "COMMIT")
+	checkRows(t, s, "SELECT id FROM t ORDER BY id", [][]interface{}{{1}, {2}, {4}})
+}
+
+func TestExecuteRollsBackOpenTransaction(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	if _, err := db.Execute("CREATE TABLE t (id INT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Execute("BEGIN; INSERT INTO t VALUES (1)")
+	if CodeOf(err) != CodeActiveTransaction {
+		t.Fatalf("got error %v, want code %q", err, CodeActiveTransaction)
+	}
+	if _, err := db.Execute("BEGIN; INSERT INTO t VALUES (2); COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, db.NewSession(), "SELECT id FROM t", [][]interface{}{{2}})
+}
+
+func TestSchemaChangeWaitsForOpenTransactions(t *testing.T) {
+	opts := testOptions()
+	opts.LockTimeout = 100 * time.Millisecond
+	db := openTestDB(t, t.TempDir(), opts)
+	defer db.Close()
+	s1, s2 := db.NewSession(), db.NewSession()
+	mustExec(t, s1, "CREATE TABLE t (id INT PRIMARY KEY, v INT); INSERT INTO t VALUES (1, 1)")
+	mustExec(t, s1, "BEGIN; UPDATE t SET v = 2 WHERE id = 1")
+
+	alter := func() chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := db.Execute("ALTER TABLE t ADD COLUMN w INT")
+			done <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+		return done
+	}
+
+	// Other sessions go on while the schema change waits, until it gives up
+	done := alter()
+	mustExec(t, s2, "INSERT INTO t VALUES (2, 1)")
+	checkRows(t, s2, "SELECT id, v FROM t ORDER BY id", [][]interface{}{{1, 1}, {2, 1}})
+	if err := <-done; CodeOf(err) != CodeLockNotAvailable {
+		t.Fatalf("schema change: got error %v, want code %q", err, CodeLockNotAvailable)
+	}
+
+	// It goes ahead once the transaction ends in time
+	db.opts.LockTimeout = 5 * time.Second
+	done = alter()
+	mustExec(t, s1, "COMMIT")
+	if err := <-done; err != nil {
+		t.Fatalf("schema change: %v", err)
+	}
+	checkRows(t, s2, "SELECT * FROM t ORDER BY id", [][]interface{}{{1, 2, nil}, {2, 1, nil}})
+}
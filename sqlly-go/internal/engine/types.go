@@ -1,5 +1,7 @@
 package engine
 
+import "fmt"
+
 type DbType int
 
 const (
@@ -7,16 +9,63 @@ const (
 	StringType
 )
 
+func (t DbType) String() string {
+	switch t {
+	case IntType:
+		return "int"
+	case StringType:
+		return "string"
+	}
+	return fmt.Sprintf("DbType(%d)", int(t))
+}
+
+// MarshalText stores types by name in the catalog
+func (t DbType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *DbType) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "int":
+		*t = IntType
+	case "string":
+		*t = StringType
+	default:
+		return fmt.Errorf("unknown column type %q", b)
+	}
+	return nil
+}
+
 type ColumnDef struct {
-	Name         string
-	Type         DbType
-	IsPrimaryKey bool
-	IsUnique     bool
+	Name         string `json:"name"`
+	Type         DbType `json:"type"`
+	IsPrimaryKey bool   `json:"primaryKey,omitempty"`
+	IsUnique     bool   `json:"unique,omitempty"`
+	NotNull      bool   `json:"notNull,omitempty"`
+	// Default is the SQL text of the DEFAULT expression, if any
+	Default       string `json:"default,omitempty"`
+	AutoIncrement bool   `json:"autoIncrement,omitempty"`
+}
+
+// nullable reports whether the column may hold NULL; the primary key never
+// does.
+func (c ColumnDef) nullable() bool {
+	return !c.NotNull && !c.IsPrimaryKey
 }
 
 type TableSchema struct {
-	Name    string
-	Columns []ColumnDef
+	Name    string      `json:"name"`
+	Columns []ColumnDef `json:"columns"`
+	Indexes []IndexDef  `json:"indexes,omitempty"`
+	// NextId is the first id not reserved yet when id is AUTOINCREMENT
+	NextId int `json:"nextId,omitempty"`
+}
+
+// IndexDef is a secondary index created with CREATE INDEX.
+type IndexDef struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
 }
 
 type Row struct {
@@ -29,4 +78,4 @@ func NewRow() *Row {
 	return &Row{
 		Data: make(map[string]interface{}),
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,88 @@
+package engine
+
+import "fmt"
+
+// autoVacuumMinGarbage is how many bytes of deleted records a table file must
+// hold before auto-vacuum rewrites it, however large their share.
+const autoVacuumMinGarbage = 64 << 10
+
+// handleVacuum rewrites the named table, or every table, without the records
+// of deleted and updated rows. The caller must hold db.txMu exclusively.
+func (db *Database) handleVacuum(stmt *VacuumStmt) (*Result, error) {
+	all := db.tableList()
+	var target *Table
+	if stmt.Table != "" {
+		db.mu.RLock()
+		t, ok := db.Tables[stmt.Table]
+		db.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrTableNotFound, stmt.Table)
+		}
+		target = t
+	}
+
+	space, err := db.vacuumTables(all)
+	if err == nil {
+		err = db.flush(all)
+	}
+	if err != nil {
+		return nil, &Error{Code: CodeIO, Msg: "checkpoint failed", Err: err}
+	}
+	var reclaimed int64
+	compacted := 0
+	for i, t := range all {
+		if (target != nil && t != target) || space[i].garbage == 0 {
+			continue
+		}
+		if err := t.compact(); err != nil {
+			return nil, &Error{Code: CodeIO, Msg: fmt.Sprintf("vacuuming table '%s' failed", t.Schema.Name), Err: err}
+		}
+		reclaimed += space[i].garbage
+		compacted++
+	}
+
+	msg := fmt.Sprintf("Vacuumed %d table(s), reclaimed %d bytes.", compacted, reclaimed)
+	if stmt.Table != "" {
+		msg = fmt.Sprintf("Table '%s' vacuumed, reclaimed %d bytes.", stmt.Table, reclaimed)
+	}
+	return &Result{Message: msg}, nil
+}
+
+// tableSpace is how many bytes of a table file hold records some transaction
+// may still read, and how many hold records none will.
+type tableSpace struct {
+	live, garbage int64
+}
+
+// vacuumTables forgets the row versions no transaction can see any more,
+// folding the rows left with one version into the tables' indexes. The result
+// is aligned with tables.
+func (db *Database) vacuumTables(tables []*Table) ([]tableSpace, error) {
+	horizon := db.txns.horizon()
+	space := make([]tableSpace, len(tables))
+	for i, t := range tables {
+		live, garbage, err := t.vacuum(horizon)
+		if err != nil {
+			return nil, fmt.Errorf("vacuuming table %s: %w", t.Schema.Name, err)
+		}
+		space[i] = tableSpace{live, garbage}
+	}
+	return space, nil
+}
+
+// autoVacuum compacts the tables whose deleted records reach
+// opts.AutoVacuumRatio of their file, given the space returned by
+// vacuumTables. The write-ahead log must be empty.
+func (db *Database) autoVacuum(tables []*Table, space []tableSpace) error {
+	for i, t := range tables {
+		live, garbage := space[i].live, space[i].garbage
+		if db.opts.AutoVacuumRatio <= 0 || garbage < autoVacuumMinGarbage ||
+			float64(garbage) < db.opts.AutoVacuumRatio*float64(live+garbage) {
+			continue
+		}
+		if err := t.compact(); err != nil {
+			return fmt.Errorf("compacting table %s: %w", t.Schema.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestVacuum(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), testOptions())
+	defer db.Close()
+	s := db.NewSession()
+	mustExec(t, s, `CREATE TABLE a (id INT PRIMARY KEY, v TEXT);
+		CREATE TABLE b (id INT PRIMARY KEY, v TEXT);
+		INSERT INTO b VALUES (1, 'b')`)
+	for i := 0; i < 200; i++ {
+		mustExec(t, s, "INSERT INTO a VALUES (1, 'a row long enough to take some room'); DELETE FROM a")
+	}
+	mustExec(t, s, "INSERT INTO a VALUES (2, 'kept')")
+
+	// Only the table holding deleted records is rewritten
+	res := mustExec(t, s, "VACUUM")
+	var tables, reclaimed int
+	if _, err := fmt.Sscanf(res[0].Message, "Vacuumed %d table(s), reclaimed %d bytes.", &tables, &reclaimed); err != nil {
+		t.Fatalf("message %q: %v", res[0].Message, err)
+	}
+	if tables != 1 || reclaimed == 0 {
+		t.Errorf("message: got %q, want 1 table and some bytes reclaimed", res[0].Message)
+	}
+	checkRows(t, s, "SELECT id, v FROM a", [][]interface{}{{2, "kept"}})
+	checkRows(t, s, "SELECT id, v FROM b", [][]interface{}{{1, "b"}})
+
+	res = mustExec(t, s, "VACUUM")
+	if got, want := res[0].Message, "Vacuumed 0 table(s), reclaimed 0 bytes."; got != want {
+		t.Errorf("message: got %q, want %q", got, want)
+	}
+	execFails(t, s, "VACUUM nope", CodeUndefinedTable)
+}
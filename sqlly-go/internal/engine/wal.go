@@ -0,0 +1,482 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when the write-ahead log is flushed to stable storage.
+// Every policy survives the process being killed; only SyncAlways and
+// SyncCommit also survive the machine losing power.
+type SyncPolicy int
+
+const (
+	SyncCommit SyncPolicy = iota // fsync when a transaction commits
+	SyncAlways                   // fsync after every log record
+	SyncOff                      // Leave flushing to the operating system
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncCommit:
+		return "commit"
+	case SyncAlways:
+		return "always"
+	case SyncOff:
+		return "off"
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for _, p := range []SyncPolicy{SyncCommit, SyncAlways, SyncOff} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync policy %q: use commit, always or off", s)
+}
+
+// Options configures how databases persist changes.
+type Options struct {
+	Sync               SyncPolicy
+	CheckpointInterval time.Duration // Checkpoint this often; 0 disables periodic checkpoints
+	CheckpointSize     int64         // Checkpoint after a commit once the log is this large; 0 disables
+	AutoVacuumRatio    float64       // Compact a table at checkpoint once deleted records are this fraction of its file; 0 disables
+	BufferPool         *BufferPool   // Page cache, shared by the databases given the same pool; nil gives each its own
+	LockTimeout        time.Duration // How long a schema change waits for open transactions to end before failing
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Sync:               SyncCommit,
+		CheckpointInterval: time.Minute,
+		CheckpointSize:     16 << 20,
+		AutoVacuumRatio:    0.5,
+		LockTimeout:        10 * time.Second,
+	}
+}
+
+// The write-ahead log records every change to a table file before the file
+// is touched. Each record is framed as
+//
+//	length uint32 | CRC-32C of payload uint32 | payload
+//
+// so a record torn by a crash is detected and ignored. Rolling back is logged
+// as compensating records (walDiscard, walUndelete), and every transaction
+// that changed something ends with walCommit or walAbort. The log is emptied
+// by a checkpoint once the table files have been flushed, but for the records
+// of transactions still running.
+type walOp byte
+
+const (
+	walInsert   walOp = iota + 1 // Put data at offset, as the given slot of its page
+	walDelete                    // Set the deleted flag of the record at offset
+	walUndelete                  // Clear it again (undoes walDelete)
+	walDiscard                   // Set the deleted flag of an inserted record (undoes walInsert)
+	walCommit
+	walAbort
+)
+
+type walRecord struct {
+	op     walOp
+	txId   uint64
+	table  string
+	offset int64  // Position of the record in the table file
+	slot   uint16 // Slot of the record in its page, for walInsert
+	data   []byte // Encoded row for walInsert
+}
+
+var walCRC = crc32.MakeTable(crc32.Castagnoli)
+
+func walPath(dbDir string) string {
+	return filepath.Join(dbDir, "wal.log")
+}
+
+// Positions in the log (LSNs) count the bytes appended since it was opened,
+// so they keep growing when a checkpoint empties it.
+type wal struct {
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	lsn    int64 // Position of the end of the log
+	synced int64 // The log is on stable storage up to this position
+	policy SyncPolicy
+}
+
+func openWAL(path string, policy SyncPolicy) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &wal{f: f, size: info.Size(), policy: policy}, nil
+}
+
+// append writes rec as a single write, flushing it according to the sync
+// policy. A failed write is cut off again so later records stay readable.
+func (w *wal) append(rec walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	frame := encodeWALFrame(rec)
+	if _, err := w.f.Write(frame); err != nil {
+		w.f.Truncate(w.size)
+		return err
+	}
+	w.size += int64(len(frame))
+	w.lsn += int64(len(frame))
+
+	if w.policy == SyncAlways || (w.policy == SyncCommit && rec.op == walCommit) {
+		return w.syncLocked()
+	}
+	return nil
+}
+
+func (w *wal) syncLocked() error {
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.synced = w.lsn
+	return nil
+}
+
+// end returns the position of the end of the log.
+func (w *wal) end() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lsn
+}
+
+// syncTo makes sure the log is on stable storage up to position lsn, before
+// a page changed by the records there is written back. SyncOff syncs nothing:
+// the records were written before the page, which is all a killed process
+// needs.
+func (w *wal) syncTo(lsn int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.policy == SyncOff || lsn <= w.synced {
+		return nil
+	}
+	return w.syncLocked()
+}
+
+func (w *wal) len() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+// reset empties the log.
+func (w *wal) reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.syncLocked()
+}
+
+// truncate empties the log but for the records of the transactions running
+// reports, which recovery needs to undo them should they never commit. The
+// table files must have been flushed, so that replaying the records kept
+// changes nothing they do not already hold.
+func (w *wal) truncate(running func(txId uint64) bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	path := w.f.Name()
+	records, err := readWAL(path)
+	if err != nil {
+		return err
+	}
+	var kept []byte
+	for _, rec := range records {
+		if running(rec.txId) {
+			kept = append(kept, encodeWALFrame(rec)...)
+		}
+	}
+	if len(kept) == 0 {
+		if err := w.f.Truncate(0); err != nil {
+			return err
+		}
+		w.size = 0
+		return w.syncLocked()
+	}
+
+	// Swap in a log of the records kept, so that a crash leaves one log or
+	// the other
+	tmp := path + ".new"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(kept); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if f, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	w.f.Close()
+	w.f, w.size = f, int64(len(kept))
+	w.synced = w.lsn
+	return nil
+}
+
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// encodeWALFrame encodes rec as it is written to the log.
+func encodeWALFrame(rec walRecord) []byte {
+	payload := encodeWALRecord(rec)
+	frame := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:], crc32.Checksum(payload, walCRC))
+	return append(frame, payload...)
+}
+
+func encodeWALRecord(rec walRecord) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(rec.op))
+	binary.Write(&buf, binary.LittleEndian, rec.txId)
+	binary.Write(&buf, binary.LittleEndian, rec.offset)
+	binary.Write(&buf, binary.LittleEndian, rec.slot)
+	writeString(&buf, rec.table)
+	binary.Write(&buf, binary.LittleEndian, int32(len(rec.data)))
+	buf.Write(rec.data)
+	return buf.Bytes()
+}
+
+func decodeWALRecord(payload []byte) (walRecord, error) {
+	r := bytes.NewReader(payload)
+	var rec walRecord
+	op, err := r.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+	rec.op = walOp(op)
+	if err := binary.Read(r, binary.LittleEndian, &rec.txId); err != nil {
+		return rec, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &rec.offset); err != nil {
+		return rec, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &rec.slot); err != nil {
+		return rec, err
+	}
+	if rec.table, err = readString(r); err != nil {
+		return rec, err
+	}
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return rec, err
+	}
+	if n < 0 || int(n) != r.Len() {
+		return rec, fmt.Errorf("bad data length %d", n)
+	}
+	rec.data = make([]byte, n)
+	_, err = io.ReadFull(r, rec.data)
+	return rec, err
+}
+
+// readWAL returns the intact records of the log at path, stopping at the
+// first torn or corrupt one.
+func readWAL(path string) ([]walRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []walRecord
+	r := bufio.NewReader(f)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		n := binary.LittleEndian.Uint32(header[0:])
+		sum := binary.LittleEndian.Uint32(header[4:])
+		if n > 1<<30 {
+			break
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, walCRC) != sum {
+			break
+		}
+		rec, err := decodeWALRecord(payload)
+		if err != nil {
+			break
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// recoverWAL brings the table files in dbDir to the state of the last
+// committed transaction: it replays every logged change in order, then undoes
+// the changes of transactions that neither committed nor finished aborting.
+// It returns the names of the tables it touched.
+func recoverWAL(dbDir string, records []walRecord) ([]string, error) {
+	touched := make(map[string]bool)
+	pending := make(map[uint64][]walRecord) // Undo stack per unfinished transaction
+	for _, rec := range records {
+		switch rec.op {
+		case walCommit, walAbort:
+			delete(pending, rec.txId)
+			continue
+		}
+		if err := applyWALRecord(dbDir, rec); err != nil {
+			return nil, err
+		}
+		touched[rec.table] = true
+
+		stack := pending[rec.txId]
+		switch rec.op {
+		case walInsert, walDelete:
+			pending[rec.txId] = append(stack, rec)
+		case walUndelete, walDiscard:
+			if len(stack) > 0 {
+				pending[rec.txId] = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	txIds := make([]uint64, 0, len(pending))
+	for id := range pending {
+		txIds = append(txIds, id)
+	}
+	sort.Slice(txIds, func(i, j int) bool { return txIds[i] > txIds[j] })
+	for _, id := range txIds {
+		stack := pending[id]
+		for i := len(stack) - 1; i >= 0; i-- {
+			undo := walRecord{op: walDiscard, table: stack[i].table, offset: stack[i].offset}
+			if stack[i].op == walDelete {
+				undo.op = walUndelete
+			}
+			if err := applyWALRecord(dbDir, undo); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(touched))
+	for name := range touched {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// applyWALRecord performs one logged change on a table file. Applying a
+// record again has the same effect, so replay may repeat changes that had
+// already reached the file.
+func applyWALRecord(dbDir string, rec walRecord) error {
+	path := tableFilePath(dbDir, rec.table)
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := applyToFile(f, rec); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func applyToFile(f *os.File, rec walRecord) error {
+	version, err := checkFileHeader(f)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return fmt.Errorf("%s is not a paged table file", f.Name())
+	}
+
+	switch rec.op {
+	case walInsert:
+		start := rec.offset - rec.offset%pageSize
+		page := slottedPage(make([]byte, pageSize))
+		if _, err := f.ReadAt(page, start); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		page.put(int(rec.slot), int(rec.offset-start), rec.data)
+		_, err = f.WriteAt(page, start)
+	case walDelete, walDiscard:
+		_, err = f.WriteAt([]byte{1}, rec.offset)
+	case walUndelete:
+		_, err = f.WriteAt([]byte{0}, rec.offset)
+	default:
+		return fmt.Errorf("unknown log record type %d", rec.op)
+	}
+	return err
+}
+
+// syncFile flushes a file's contents to stable storage.
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir makes the entries of directory dir, such as files just created or
+// renamed, survive a crash. Windows cannot sync directories, and does not
+// need to.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
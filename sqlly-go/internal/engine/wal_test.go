@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// crash closes the files of db as if the process had died: pages still in
+// its buffer pool are lost and its write-ahead log is left as it is.
+func crash(db *Database) {
+	for _, t := range db.tableList() {
+		t.file.discard()
+		t.index.discard()
+	}
+	db.wal.close()
+}
+
+func TestRecoveryKeepsOnlyCommittedWork(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, testOptions())
+	s := db.NewSession()
+	mustExec(t, s, `CREATE TABLE t (id INT PRIMARY KEY, v TEXT);
+		INSERT INTO t VALUES (1, 'a'), (2, 'b'), (3, 'c');
+		UPDATE t SET v = 'bb' WHERE id = 2;
+		DELETE FROM t WHERE id = 3`)
+	mustExec(t, s, `BEGIN;
+		INSERT INTO t VALUES (4, 'd');
+		UPDATE t SET v = 'z' WHERE id = 1;
+		DELETE FROM t WHERE id = 2`)
+	// A rolled back transaction leaves nothing either
+	other := db.NewSession()
+	mustExec(t, other, "BEGIN; INSERT INTO t VALUES (5, 'e'); ROLLBACK")
+	crash(db)
+
+	db = openTestDB(t, dir, testOptions())
+	s = db.NewSession()
+	want := [][]interface{}{{1, "a"}, {2, "bb"}}
+	checkRows(t, s, "SELECT id, v FROM t ORDER BY id", want)
+	// The primary key index was rebuilt from the recovered rows
+	checkRows(t, s, "SELECT v FROM t WHERE id = 2", [][]interface{}{{"bb"}})
+	mustExec(t, s, "INSERT INTO t VALUES (4, 'd')")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestDB(t, dir, testOptions())
+	defer db.Close()
+	checkRows(t, db.NewSession(), "SELECT id, v FROM t ORDER BY id", append(want, []interface{}{4, "d"}))
+}
+
+func TestRecoveryIgnoresTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, testOptions())
+	mustExec(t, db.NewSession(), `CREATE TABLE t (id INT PRIMARY KEY, v TEXT);
+		INSERT INTO t VALUES (1, 'a')`)
+	crash(db)
+
+	// A record cut short by the crash
+	f, err := os.OpenFile(walPath(dir), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{200, 0, 0, 0, 1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db = openTestDB(t, dir, testOptions())
+	defer db.Close()
+	checkRows(t, db.NewSession(), "SELECT id, v FROM t", [][]interface{}{{1, "a"}})
+}
+
+func TestCheckpointKeepsOpenTransactions(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.CheckpointSize = 1
+	db := openTestDB(t, dir, opts)
+	s1, s2 := db.NewSession(), db.NewSession()
+	mustExec(t, s1, "CREATE TABLE t (id INT PRIMARY KEY, v TEXT); INSERT INTO t VALUES (1, 'a'), (2, 'b')")
+
+	// Every statement checkpoints, leaving only the open transaction's records
+	mustExec(t, s1, "BEGIN; INSERT INTO t VALUES (3, 'open'); DELETE FROM t WHERE id = 1")
+	open := db.wal.len()
+	if open == 0 {
+		t.Fatal("checkpoint dropped the records of the open transaction")
+	}
+	for i := 4; i < 10; i++ {
+		mustExec(t, s2, fmt.Sprintf("INSERT INTO t VALUES (%d, 'done')", i))
+	}
+	if got := db.wal.len(); got != open {
+		t.Errorf("log holds %d bytes, want the %d of the open transaction", got, open)
+	}
+
+	// Recovery undoes the flushed changes of the transaction left open
+	crash(db)
+	db = openTestDB(t, dir, opts)
+	s1 = db.NewSession()
+	checkRows(t, s1, "SELECT id FROM t WHERE id < 5 ORDER BY id", [][]interface{}{{1}, {2}, {4}})
+
+	// and keeps them once it has committed
+	mustExec(t, s1, "BEGIN; INSERT INTO t VALUES (3, 'open'); DELETE FROM t WHERE id = 1")
+	mustExec(t, db.NewSession(), "INSERT INTO t VALUES (10, 'done')")
+	mustExec(t, s1, "COMMIT")
+	crash(db)
+	db = openTestDB(t, dir, opts)
+	defer db.Close()
+	checkRows(t, db.NewSession(), "SELECT id FROM t WHERE id < 5 ORDER BY id", [][]interface{}{{2}, {3}, {4}})
+	checkRows(t, db.NewSession(), "SELECT COUNT(*) FROM t", [][]interface{}{{9}})
+}
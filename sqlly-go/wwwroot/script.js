@@ -100,8 +100,8 @@ document.addEventListener('DOMContentLoaded', () => {
                         headers: { 'Content-Type': 'application/json' },
                         body: JSON.stringify(sql)
                     });
-                    const text = await res.json();
-                    out.innerText += text + "\n";
+                    const body = await res.json();
+                    out.innerText += formatQueryResponse(body) + "\n";
 
                     // Refresh table list if command was successful (could be CREATE/DROP)
                     if(res.ok) refreshTables(); 
@@ -121,6 +121,31 @@ document.addEventListener('DOMContentLoaded', () => {
     refreshTables();
 });
 
+// Renders the /api/query response: one JSON object per row for queries and
+// RETURNING clauses, the status message for other statements, then the error
+// if one failed.
+function formatQueryResponse(body) {
+    const lines = [];
+    for (const result of body.results || []) {
+        if (result.columns) {
+            for (const row of result.rows || []) {
+                const obj = {};
+                result.columns.forEach((col, i) => obj[col.name] = row[i]);
+                lines.push(JSON.stringify(obj));
+            }
+            // Writes with RETURNING also carry their status message
+            if (result.message) lines.push(result.message);
+        } else {
+            lines.push(result.message);
+        }
+    }
+    if (body.error) {
+        const label = body.error.code === "42601" ? "Syntax error" : "Error";
+        lines.push(`${label} [${body.error.code}]: ${body.error.message}`);
+    }
+    return lines.join("\n");
+}
+
 // --- Table View ---
 
 async function refreshTables() {
@@ -186,7 +211,7 @@ async function loadTable(tableName) {
         
         data.forEach(row => {
             html += '<tr>';
-            cols.forEach(c => html += `<td>${row[c]}</td>`);
+            cols.forEach(c => html += `<td>${row[c] === null ? 'NULL' : row[c]}</td>`);
             html += '</tr>';
         });
         html += '</tbody></table>';